@@ -1,39 +1,78 @@
 package Cache
 
 import (
+	"../OutsideInterface"
 	"../RFModel"
+	"context"
+	"errors"
 	"fmt"
 	"time"
 )
 
+// callTimeout limits a single rf model operation including device units discovery
+const callTimeout = 10 * time.Second
+
+// updateLoop runs update cycles and applies reloaded devices.json between them
 func (c *Cache) updateLoop() {
 	for {
-		time.Sleep(time.Millisecond * 100)
-		c.updateRoutine()
+		select {
+		case r := <-c.reloads:
+			r.result <- c.apply(r.config)
+		case <-time.After(time.Millisecond * 100):
+			c.updateRoutine()
+		}
 	}
 }
 
 // updateRoutine is a single cycle, which is synchronously:
-// write all pending values
+// write all pending values and deliver queued commands
+// publish device statistics once in statisticsPeriod
 // update access frequency
 // update all read values according to their access frequency
 func (c *Cache) updateRoutine() {
+	defer func(start time.Time) {
+		updateDuration.Observe(time.Now().Sub(start).Seconds())
+	}(time.Now())
 	// update device states first by pinging unit 0 function 0
 	c.deviceCacheMutex.RLock(); defer c.deviceCacheMutex.RUnlock()
+	var appeared, reconnected []DeviceKey
 	for key := range c.deviceCache {
+		device := c.deviceCache[key]
 		if c.probeDevice(key) {
-			c.deviceCache[key].State = SOnline
+			if SOnline != device.State {
+				appeared = append(appeared, key)
+			}
+			// offline state set by a timed out write is its failure, the attempts are not given again
+			if device.unreachable {
+				reconnected = append(reconnected, key)
+			}
+			device.unreachable = false
+			c.setDeviceState(key, SOnline)
 		} else {
-			c.deviceCache[key].State = SOffline
+			device.unreachable = true
+			c.setDeviceState(key, SOffline)
+		}
+	}
+	for _, key := range reconnected {
+		c.retryPendingWrites(key)
+	}
+	for _, key := range appeared {
+		c.discoverDataTypes(key)
+	}
+	for key, device := range c.deviceCache {
+		if SOnline == device.State && time.Now().After(device.StatisticsUpdate.Add(statisticsPeriod)) {
+			c.publishStatistics(key)
 		}
 	}
 	// and then perform update cycle
 	c.cacheMutex.RLock(); defer c.cacheMutex.RUnlock()
 	for key, value := range c.cache {
 		if SOnline == c.deviceCache[DeviceKey(key.UID.Address)].State {
-			if value.Writeable && WSPending == value.WriteState {
+			// commands have their own queue, see performCommands
+			if value.Writeable && !value.Command && WSPending == value.WriteState {
 				c.performWrite(key)
 			}
+			c.performCommands(key)
 			c.updateAccessPeriod(key)
 			if value.Readable && time.Now().After(value.LastUpdate.Add(value.AccessPeriod)) {
 				c.performRead(key)
@@ -45,78 +84,178 @@ func (c *Cache) updateRoutine() {
 // writeRequest is entrypoint for writing values from outside interface
 func (c *Cache) writeRequest(key Key, value string) {
 	c.cacheMutex.RLock(); defer c.cacheMutex.RUnlock()
+	if _, ok := c.cache[key]; !ok {
+		// removed by reload
+		return
+	}
 	c.cache[key].WriteValue = value
-	c.cache[key].WriteState = WSPending
+	c.cache[key].writeAttempts = 0
+	c.setWriteState(key, WSPending, "")
 }
 
 // performWrite is a routine to send write command to rf interface and update cache state
-// for the update routine
+// for the update routine, cacheMutex and deviceCacheMutex should be locked
 func (c *Cache) performWrite(key Key) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	if err := c.rf.WriteFunctionContext(ctx, key.UID, key.FNo, c.cache[key].WriteValue); nil != err {
+		c.log.Debug(fmt.Sprintf("Cache.performWrite(%v): %v", c.outputKey(key), err))
+		c.setCallErrorState(DeviceKey(key.UID.Address), err)
+		// it stays pending and is retried while attempts last
+		c.cache[key].writeAttempts++
+		if maxWriteAttempts <= c.cache[key].writeAttempts {
+			c.setWriteState(key, WSFailed, err.Error())
+		}
+		return
+	}
+	c.setWriteState(key, WSWritten, "")
+}
+
+// setWriteState updates the write state and reports it to the outside interface
+// cacheMutex should be locked
+func (c *Cache) setWriteState(key Key, state WriteState, reason string) {
+	value := c.cache[key]
+	value.WriteState = state
+	value.WriteError = reason
+	if listener, ok := c.out.(OutsideInterface.WriteStateListener); ok {
+		listener.UpdateWriteState(c.outputKey(key), state.String(), reason)
+	}
+}
+
+// retryPendingWrites gives pending writes of the device all the attempts again, when the probe reaches it again
+// failures while it was unreachable are not its writes fault
+func (c *Cache) retryPendingWrites(device DeviceKey) {
 	c.cacheMutex.RLock(); defer c.cacheMutex.RUnlock()
-	defer func() {
-		if r := recover(); r != nil {
-			c.deviceCacheMutex.RLock()
-			switch r.(RFModel.Error).Type {
-			case RFModel.EDeviceTimeout:
-				c.deviceCache[DeviceKey(key.UID.Address)].State = SOffline
-			default:
-				c.deviceCache[DeviceKey(key.UID.Address)].State = SError
-			}
-			c.deviceCacheMutex.RUnlock()
-			// we should generate event here
+	for key, value := range c.cache {
+		if DeviceKey(key.UID.Address) == device && !value.Command && WSPending == value.WriteState {
+			value.writeAttempts = 0
 		}
-	}()
-	c.rf.WriteFunction(key.UID, key.FNo, c.cache[key].WriteValue)
-	c.cache[key].WriteState = WSWritten
+	}
 }
 
 // performRead is a routine to send read command to rf interface, update cache values
 // and send updates to outside interface
-// for the update routine
+// for the update routine, cacheMutex should be locked
 func (c *Cache) performRead(key Key) {
-	c.cacheMutex.RLock(); defer c.cacheMutex.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	value, err := c.rf.ReadFunctionContext(ctx, key.UID, key.FNo)
 	defer func() {
-		c.out.UpdateComponent(c.outputKey(key), c.cache[key].ReadValue)
+		if listener, ok := c.out.(OutsideInterface.ComponentErrorListener); ok && nil != err {
+			listener.UpdateComponentError(c.outputKey(key), c.cache[key].ReadValue)
+		} else {
+			c.out.UpdateComponent(c.outputKey(key), c.cache[key].ReadValue)
+		}
 	}()
-	// this defer will run before unlock
-	defer func() {
-		if r := recover(); r != nil {
-			// todo what would it do if it can't convert error into RFModel.Error?
-			switch r.(RFModel.Error).Type {
-			case RFModel.EBadCode:
-				switch r.(RFModel.Error).Code {
-				case RFModel.ERCBadUnitId, RFModel.ERCBadFunctionId:
-					//panic(fmt.Errorf("Cache.performRead: incorrect mapping, return code is: %v; ", r.(RFModel.Error).Code))
-					c.cache[key].ReadValue = fmt.Sprintf("Cache.performRead: incorrect mapping, return code is: %X; ", r.(RFModel.Error).Code)
-				default:
-					c.cache[key].ReadValue = fmt.Sprintf("Cache.performRead: return code is: %X; ", r.(RFModel.Error).Code)
-				}
+	if nil != err {
+		var callError *RFModel.CallError
+		switch {
+		case errors.Is(err, RFModel.ErrBadCode) && errors.As(err, &callError):
+			switch callError.Code {
+			case RFModel.ERCBadUnitId, RFModel.ERCBadFunctionId:
+				c.cache[key].ReadValue = fmt.Sprintf("Cache.performRead: incorrect mapping, return code is: %X; ", byte(callError.Code))
 			default:
-				c.cache[key].ReadValue = fmt.Sprintf("Cache.performRead: error type is: %v; ", r.(RFModel.Error).Type)
+				c.cache[key].ReadValue = fmt.Sprintf("Cache.performRead: return code is: %X; ", byte(callError.Code))
 			}
+		case errors.As(err, &callError):
+			c.cache[key].ReadValue = fmt.Sprintf("Cache.performRead: error type is: %v; ", callError.Type)
+		default:
+			c.cache[key].ReadValue = fmt.Sprintf("Cache.performRead: %v; ", err)
 		}
-	}()
-	value := c.rf.ReadFunction(key.UID, key.FNo)
+		return
+	}
 	c.cache[key].ReadValue = fmt.Sprintf("%v", value)
-	// todo move that into error handler
 	c.cache[key].LastUpdate = time.Now()
 }
 
+// updateAccessPeriod makes the update period of the key follow its read period (LastRequest is being updated in GetCached, not in PeekCached)
+// time since the last read counts as the read period once it gets longer, so unread keys slow down to the max period
 func (c *Cache) updateAccessPeriod(key Key) {
-	// todo: implement update access period based on request frequency (LastRequest is being updated in GetCached)
+	value := c.cache[key]
+	if value.MinAccessPeriod == value.MaxAccessPeriod {
+		value.AccessPeriod = value.MinAccessPeriod
+		return
+	}
+	period := value.requestPeriod
+	if idle := time.Now().Sub(value.LastRequest); idle > period {
+		period = idle
+	}
+	if period < value.MinAccessPeriod {
+		period = value.MinAccessPeriod
+	}
+	if period > value.MaxAccessPeriod {
+		period = value.MaxAccessPeriod
+	}
+	value.AccessPeriod = period
 }
 
 func (c *Cache) probeDevice(key DeviceKey) (isOnline bool) {
 	// if there was a reply from a given device, it is online, otherwise it is offline :)
-	defer func() {
-		if r := recover(); r != nil {
-			isOnline = false
-		}
-	}()
 	// it tries hard enough to conclude that if it failed, the device must be offline
 	// that function of unit 0 should never fail if device is online
 	// tons of noise will cause devices to be offline too, but can we do anything about that?
 	// todo: consider obtain metrics here instead
-	c.rf.CallFunction(RFModel.UID{Address: RFModel.DeviceAddress(key), Unit: 0}, RFModel.FGetListOfUnitFunctions, []byte{})
-	return true
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	_, err := c.rf.CallFunctionContext(ctx, RFModel.UID{Address: RFModel.DeviceAddress(key), Unit: 0}, RFModel.FGetListOfUnitFunctions, []byte{})
+	return nil == err
+}
+
+// setDeviceState updates the device state and reports changes to the outside interface
+// deviceCacheMutex should be locked
+func (c *Cache) setDeviceState(key DeviceKey, state State) {
+	device := c.deviceCache[key]
+	deviceState.WithLabelValues(RFModel.AddressToString(RFModel.DeviceAddress(key))).Set(float64(state))
+	if device.reported && state == device.State {
+		return
+	}
+	device.State = state
+	device.reported = true
+	if listener, ok := c.out.(OutsideInterface.DeviceStateListener); ok {
+		listener.UpdateDeviceState(RFModel.AddressToString(RFModel.DeviceAddress(key)), state.String())
+	}
+}
+
+// setCallErrorState makes the device offline or error one depending on the failed call error
+// bad parameter is the failure of the call only, the device did not even get it
+// deviceCacheMutex should be locked
+func (c *Cache) setCallErrorState(key DeviceKey, err error) {
+	if errors.Is(err, RFModel.ErrBadParameter) {
+		return
+	}
+	if errors.Is(err, RFModel.ErrDeviceTimeout) {
+		c.setDeviceState(key, SOffline)
+	} else {
+		c.setDeviceState(key, SError)
+	}
+}
+
+// discoverDataTypes asks the device for data types, which were not set in devices.json
+// and describes its components once again
+func (c *Cache) discoverDataTypes(device DeviceKey) {
+	c.cacheMutex.RLock()
+	var keys []Key
+	for key, value := range c.cache {
+		if DeviceKey(key.UID.Address) == device && RFModel.EDUnspecified == value.DataType {
+			keys = append(keys, key)
+		}
+	}
+	c.cacheMutex.RUnlock()
+	for _, key := range keys {
+		ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+		read, write, err := c.rf.DataTypes(ctx, key.UID, key.FNo)
+		cancel()
+		if nil != err {
+			c.log.Warning(fmt.Sprintf("Cache.discoverDataTypes(%v): %v", c.outputKey(key), err))
+			continue
+		}
+		c.cacheMutex.RLock()
+		if c.cache[key].Readable {
+			c.cache[key].DataType = read
+		} else {
+			c.cache[key].DataType = write
+		}
+		c.cacheMutex.RUnlock()
+		c.describeComponent(key)
+	}
 }
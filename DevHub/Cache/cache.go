@@ -2,20 +2,26 @@
 // read, write and command
 // first two are states
 // commands are events, so we need a queue for them instead of a single value
+// functions with "command" in devices.json take commands from outside interface instead of writes
 //
 // First component needs to be registered so cache will pull its value at the next update cycle
 // Reading is just returns last value of the component
 // Reading affects update frequency: more often reads increase frequency, no reads decrease frequency
+// within "min access period" and "max access period" of devices.json function
+// reads are GetCached calls, Rest makes them for GET of a single function only, listings are PeekCached
+// Redis, Mqtt and Opcua clients read values there without the cache knowing, their functions are updated
+// once in "max access period", so functions for them should have a fixed "access period"
 // Writing is just store required value to a cache, it will be written at the next update cycle
+// devices.json is applied again on Reload, see it for what is kept
 //
 package Cache
 
 import (
 	"fmt"
-	"github.com/flynn/json5"
 	"github.com/sirupsen/logrus"
-	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +38,11 @@ type Cache struct {
 	cacheMutex  sync.RWMutex
 	deviceCache map[DeviceKey]*DeviceState
 	deviceCacheMutex sync.RWMutex
+	// guards command queues, they are appended outside of the update routine
+	commandMutex sync.Mutex
+	devicesFile  string
+	// devices.json configs to be applied between update cycles
+	reloads chan reloadRequest
 }
 
 type State byte
@@ -51,26 +62,72 @@ const (
 	WSFailed                   = 3
 )
 
+// maxWriteAttempts is how many times the write fails with the device online before it is WSFailed
+const maxWriteAttempts = 3
+
 type Key RFModel.UnitFunctionKey
 type DeviceKey RFModel.DeviceAddress
 
 // Value — either read value, or write value. One item per function
+
 type Value struct {
 	ReadValue    string
 	LastUpdate   time.Time     // when it was updated from the unit last time
 	LastRequest  time.Time     // when it was read from cache last time
 	AccessPeriod time.Duration // update if LastUpdate + AccessPeriod is bigger than time.Now()
-	WriteValue   string
-	WriteState   WriteState
-	DeviceName   string
-	UnitName     string
-	FunctionName string
-	Readable     bool
-	Writeable    bool
+	// AccessPeriod follows the read frequency within these bounds, equal bounds make it fixed
+	MinAccessPeriod time.Duration
+	MaxAccessPeriod time.Duration
+	requestPeriod   time.Duration // interval between reads, 0 until the second read
+	WriteValue      string
+	WriteState      WriteState
+	WriteError      string // reason of WSFailed
+	writeAttempts   int
+	DeviceName      string
+	UnitName        string
+	FunctionName    string
+	Readable        bool
+	Writeable       bool
+	DataType        RFModel.EDataType // EDUnspecified until known from devices.json or the device
+	Command         bool              // writes are commands, see Command
+	commands        []*command
+	configured      bool          // it is in devices.json, only these keys are removed on reload
+	unsubscribe     chan struct{} // closing it stops the writable subscription, nil if there is none
 }
 
 type DeviceState struct {
-	State State
+	State    State
+	reported bool
+	Name     string // devices.json name
+	// it is in devices.json, pre-shared key of it is set to the rf model
+	configured bool
+	key        []byte
+	// when diagnostics were published last time
+	StatisticsUpdate time.Time
+	// the last probe did not reach it, failed calls make it offline without that
+	unreachable bool
+}
+
+func (s State) String() string {
+	switch s {
+	case SOnline:
+		return OutsideInterface.DSOnline
+	case SError:
+		return OutsideInterface.DSError
+	}
+	return OutsideInterface.DSOffline
+}
+
+func (s WriteState) String() string {
+	switch s {
+	case WSPending:
+		return OutsideInterface.WSPending
+	case WSWritten:
+		return OutsideInterface.WSWritten
+	case WSFailed:
+		return OutsideInterface.WSFailed
+	}
+	return ""
 }
 
 func Init(self *Cache, rf *RFModel.RFModel, output OutsideInterface.Interface, devicesFile string) {
@@ -82,17 +139,16 @@ func Init(self *Cache, rf *RFModel.RFModel, output OutsideInterface.Interface, d
 	self.out = output
 	self.deviceCache = make(map[DeviceKey]*DeviceState)
 	self.cache = make(map[Key]*Value)
+	self.devicesFile = devicesFile
+	self.reloads = make(chan reloadRequest)
 	// now read the devices file and register the devices functions enlisted in it
-	jsonData, err := ioutil.ReadFile(devicesFile)
+	config, err := readConfig(devicesFile)
 	if nil != err {
-		panic(fmt.Errorf("Cache.Init: ioutil.ReadFile: %v; ", err.Error()))
+		panic(fmt.Errorf("Cache.Init: %v", err))
 	}
-	var data map[string]interface{}
-	err = json5.Unmarshal(jsonData, &data)
-	if nil != err {
-		panic(fmt.Errorf("Cache.Init: json5.Unmarshal: %v; ", err.Error()))
+	if err := self.apply(config); nil != err {
+		panic(fmt.Errorf("Cache.Init: %v", err))
 	}
-	self.registerItems(data)
 	// and now run goroutine to periodically update cache values
 	go self.updateLoop()
 }
@@ -109,8 +165,18 @@ func (c *Cache) RegisterItem(uid RFModel.UID, fno RFModel.FuncNo) {
 func (c *Cache) GetCached(uid RFModel.UID, fno RFModel.FuncNo) (value string, state State, timestamp time.Time) {
 	key := Key{UID: uid, FNo: fno}
 	c.ensureKeyExists(key, true)
+	return c.cached(key)
+}
+
+// PeekCached is GetCached, which is not counted as a read, so listing everything does not speed up everything
+func (c *Cache) PeekCached(uid RFModel.UID, fno RFModel.FuncNo) (value string, state State, timestamp time.Time) {
+	key := Key{UID: uid, FNo: fno}
+	c.ensureKeyExists(key, false)
+	return c.cached(key)
+}
+
+func (c *Cache) cached(key Key) (value string, state State, timestamp time.Time) {
 	c.cacheMutex.RLock(); defer c.cacheMutex.RUnlock()
-	c.cache[key].LastRequest = time.Now()
 	value = c.cache[key].ReadValue
 	state = c.deviceCache[DeviceKey(key.UID.Address)].State
 	if SOnline != state {
@@ -119,36 +185,62 @@ func (c *Cache) GetCached(uid RFModel.UID, fno RFModel.FuncNo) (value string, st
 	return value, state, c.cache[key].LastUpdate
 }
 
+// AccessPeriods returns effective update periods of the readable components by their output keys, Rest lists them
+func (c *Cache) AccessPeriods() map[string]time.Duration {
+	c.cacheMutex.RLock(); defer c.cacheMutex.RUnlock()
+	ret := make(map[string]time.Duration)
+	for key, value := range c.cache {
+		if value.Readable {
+			ret[c.outputKey(key)] = value.AccessPeriod
+		}
+	}
+	return ret
+}
+
 // SetCached return immediately
 func (c *Cache) SetCached(uid RFModel.UID, fno RFModel.FuncNo, value string) {
 	key := Key{UID: uid, FNo: fno}
 	c.ensureKeyExists(key, false)
-	c.cacheMutex.RLock(); defer c.cacheMutex.RUnlock()
-	c.cache[key].WriteValue = value
-	c.cache[key].WriteState = WSPending
+	c.writeRequest(key, value)
 }
 
 func (c *Cache) ensureKeyExists(key Key, isRead bool) {
+	// both are locked, so reload never removes the device of the key being added
+	c.deviceCacheMutex.Lock(); defer c.deviceCacheMutex.Unlock()
 	c.ensureDeviceExists(DeviceKey(key.UID.Address))
 	c.cacheMutex.Lock(); defer c.cacheMutex.Unlock()
 	_, ok := c.cache[key]
 	if ok {
 		if isRead {
-			// check if it is actual
-			// update read access time
-			c.cache[key].LastRequest = time.Now()
+			// update read access time and frequency
+			value := c.cache[key]
+			interval := time.Now().Sub(value.LastRequest)
+			// speeding up is immediate, slowing down is smoothed
+			if 0 == value.requestPeriod || interval < value.requestPeriod {
+				value.requestPeriod = interval
+			} else {
+				value.requestPeriod = (3*value.requestPeriod + interval) / 4
+			}
+			value.LastRequest = time.Now()
 		}
 	} else {
-		value := Value{
-			LastRequest:  time.Now(),
-			AccessPeriod: time.Second,
-		}
-		c.cache[key] = &value
+		c.cache[key] = newValue()
+	}
+}
+
+// newValue is the key, which is not in devices.json
+func newValue() *Value {
+	return &Value{
+		LastRequest:     time.Now(),
+		AccessPeriod:    time.Second,
+		MinAccessPeriod: time.Second,
+		MaxAccessPeriod: time.Second,
+		DataType:        RFModel.EDUnspecified,
 	}
 }
 
+// deviceCacheMutex should be locked
 func (c *Cache) ensureDeviceExists(key DeviceKey) {
-	c.deviceCacheMutex.Lock(); defer c.deviceCacheMutex.Unlock()
 	_, ok := c.deviceCache[key]
 	if !ok {
 		value := DeviceState{State: SOffline}
@@ -156,52 +248,65 @@ func (c *Cache) ensureDeviceExists(key DeviceKey) {
 	}
 }
 
-func (c *Cache) registerItems(data map[string]interface{}) {
-	for deviceName, deviceInterface := range data {
-		device := deviceInterface.(map[string]interface{})
-		units := device["units"].(map[string]interface{})
-		for unitName, unitInterface := range units {
-			unit := unitInterface.(map[string]interface{})
-			functions := unit["functions"].(map[string]interface{})
-			for functionName, functionInterface := range functions {
-				function := functionInterface.(map[string]interface{})
-				uid := RFModel.UID{
-					Address: RFModel.ParseAddress(device["address"].(string)),
-					Unit:    byte(unit["address"].(float64)),
-				}
-				key := Key{UID: uid, FNo: RFModel.FuncNo(byte(function["function"].(float64)))}
-				if function["read"].(bool) {
-					c.registerJsonItem(key, function, deviceName, unitName, functionName)
-					c.cacheMutex.RLock()
-					c.cache[key].Readable = true
-					c.cacheMutex.RUnlock()
-				}
-				if function["write"].(bool) {
-					key.FNo += 1
-					c.registerJsonItem(key, function, deviceName, unitName, functionName)
-					c.cacheMutex.RLock()
-					c.cache[key].Writeable = true
-					c.cacheMutex.RUnlock()
-					go func(channel <-chan OutsideInterface.SubMessage) {
-						for m := range channel {
-							c.writeRequest(key, m.Value)
-						}
-					}(c.out.RegisterWritableComponent(c.outputKey(key)))
-				}
+// registerWritable subscribes to writes or commands of the outside interface until unsubscribe of the key is closed
+// writes of the interfaces, which do not have commands, are queued as commands without ids
+func (c *Cache) registerWritable(key Key) {
+	c.cacheMutex.RLock()
+	isCommand := c.cache[key].Command
+	stop := c.cache[key].unsubscribe
+	c.cacheMutex.RUnlock()
+	commander, ok := c.out.(OutsideInterface.Commander)
+	if isCommand && ok {
+		go receive(stop, commander.RegisterCommandComponent(c.outputKey(key)), func(m OutsideInterface.SubMessage) {
+			c.commandRequest(key, m.Value, m.ID)
+		})
+		return
+	}
+	go receive(stop, c.out.RegisterWritableComponent(c.outputKey(key)), func(m OutsideInterface.SubMessage) {
+		if isCommand {
+			c.commandRequest(key, m.Value, "")
+			return
+		}
+		c.writeRequest(key, m.Value)
+	})
+}
+
+// receive passes messages of the subscription to handle until stop or the subscription is closed
+func receive(stop <-chan struct{}, channel <-chan OutsideInterface.SubMessage, handle func(m OutsideInterface.SubMessage)) {
+	for {
+		select {
+		case <-stop:
+			return
+		case m, ok := <-channel:
+			if !ok {
+				return
 			}
+			handle(m)
 		}
 	}
 }
 
-func (c *Cache) registerJsonItem(key Key, function map[string]interface{}, deviceName string, unitName string, functionName string) {
-	c.RegisterItem(key.UID, key.FNo)
-	c.cacheMutex.RLock(); defer c.cacheMutex.RUnlock()
-	if apInterface, ok := function["access period"]; ok {
-		c.cache[key].AccessPeriod = time.Duration(apInterface.(float64) * float64(time.Second))
+// describeComponent passes devices.json names to the outside interface, if it wants them
+func (c *Cache) describeComponent(key Key) {
+	describer, ok := c.out.(OutsideInterface.Describer)
+	if !ok {
+		return
+	}
+	c.cacheMutex.RLock()
+	info := OutsideInterface.ComponentInfo{
+		DeviceAddress: RFModel.AddressToString(key.UID.Address),
+		DeviceName:    c.cache[key].DeviceName,
+		UnitName:      c.cache[key].UnitName,
+		FunctionName:  c.cache[key].FunctionName,
+		Readable:      c.cache[key].Readable,
+		Writeable:     c.cache[key].Writeable,
+		Command:       c.cache[key].Command,
 	}
-	c.cache[key].DeviceName = deviceName
-	c.cache[key].UnitName = unitName
-	c.cache[key].FunctionName = functionName
+	if RFModel.EDUnspecified != c.cache[key].DataType {
+		info.DataType = c.cache[key].DataType.String()
+	}
+	c.cacheMutex.RUnlock()
+	describer.DescribeComponent(c.outputKey(key), info)
 }
 
 func (c *Cache) outputKey(key Key) string {
@@ -211,3 +316,29 @@ func (c *Cache) outputKey(key Key) string {
 	}
 	return RFModel.AddressToString(key.UID.Address) + ":" + unitAddress + "|" + fmt.Sprintf("%X", key.FNo)
 }
+
+// ParseOutputKey is the reverse of the outside interface key, "device address:unit|function" with hex numbers
+func ParseOutputKey(key string) (uid RFModel.UID, fno RFModel.FuncNo, err error) {
+	separator := strings.LastIndex(key, "|")
+	if 0 > separator {
+		return uid, fno, fmt.Errorf("Cache.ParseOutputKey(%v): no function; ", key)
+	}
+	function, err := strconv.ParseUint(key[separator+1:], 16, 8)
+	if nil != err {
+		return uid, fno, fmt.Errorf("Cache.ParseOutputKey(%v): function: %v; ", key, err)
+	}
+	unitPart := key[:separator]
+	separator = strings.LastIndex(unitPart, ":")
+	if 0 > separator {
+		return uid, fno, fmt.Errorf("Cache.ParseOutputKey(%v): no unit; ", key)
+	}
+	unit, err := strconv.ParseUint(unitPart[separator+1:], 16, 8)
+	if nil != err {
+		return uid, fno, fmt.Errorf("Cache.ParseOutputKey(%v): unit: %v; ", key, err)
+	}
+	address, err := RFModel.TryParseAddress(unitPart[:separator])
+	if nil != err {
+		return uid, fno, fmt.Errorf("Cache.ParseOutputKey(%v): %v", key, err)
+	}
+	return RFModel.UID{Address: address, Unit: byte(unit)}, RFModel.FuncNo(function), nil
+}
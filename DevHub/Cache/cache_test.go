@@ -0,0 +1,401 @@
+package Cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"../OutsideInterface"
+	"../RFModel"
+	"../SimTransciever"
+	"../TranscieverModel"
+	"github.com/sirupsen/logrus"
+)
+
+const testDevices = `{
+	"relay": {
+		"address": "AA:AA:AA:AA:01",
+		"units": {
+			"unit 1": {
+				"address": 1,
+				"functions": {
+					"pulse": {"function": 16, "read": true, "write": true, "command": true}
+				}
+			}
+		}
+	}
+}`
+
+type fakeOutput struct {
+	values       map[string]string
+	results      []string
+	writeStates  []string
+	unregistered []string
+}
+
+func (o *fakeOutput) UpdateComponent(key string, value string) { o.values[key] = value }
+func (o *fakeOutput) RegisterWritableComponent(key string) <-chan OutsideInterface.SubMessage {
+	return make(chan OutsideInterface.SubMessage)
+}
+func (o *fakeOutput) RegisterCommandComponent(key string) <-chan OutsideInterface.SubMessage {
+	return make(chan OutsideInterface.SubMessage)
+}
+func (o *fakeOutput) CommandResult(key string, id string, result string, reason string) {
+	o.results = append(o.results, id+" "+result)
+}
+
+func (o *fakeOutput) UpdateWriteState(key string, state string, reason string) {
+	o.writeStates = append(o.writeStates, state)
+}
+
+func (o *fakeOutput) UnregisterComponent(key string) {
+	o.unregistered = append(o.unregistered, key)
+}
+
+// writesOutput has no commands, writes of the command functions come from it
+type writesOutput struct {
+	writes      chan OutsideInterface.SubMessage
+	writeStates []string
+}
+
+func (o *writesOutput) UpdateComponent(key string, value string) {}
+func (o *writesOutput) RegisterWritableComponent(key string) <-chan OutsideInterface.SubMessage {
+	return o.writes
+}
+
+func (o *writesOutput) UpdateWriteState(key string, state string, reason string) {
+	o.writeStates = append(o.writeStates, state)
+}
+
+// lossyTransmitter loses the requests to the function of the unit, as if the device did not hear them
+// or only the responses, the device executes the requests then
+type lossyTransmitter struct {
+	*SimTransciever.SimTransmitter
+	unit      byte
+	fno       RFModel.FuncNo
+	responses bool
+	// requests to the function, which the device got
+	delivered int
+}
+
+func (tr *lossyTransmitter) SendCommandContext(ctx context.Context, a TranscieverModel.Address, data TranscieverModel.Payload) (TranscieverModel.Message, error) {
+	if int(RFModel.RequestHeaderSize) > len(data) || tr.unit != data[2] || byte(tr.fno) != data[3] {
+		return tr.SimTransmitter.SendCommandContext(ctx, a, data)
+	}
+	lost := TranscieverModel.Message{Address: a, Status: TranscieverModel.EMSSlaveTimeout}
+	if !tr.responses {
+		return lost, nil
+	}
+	tr.delivered++
+	if _, err := tr.SimTransmitter.SendCommandContext(ctx, a, data); nil != err {
+		return TranscieverModel.Message{}, err
+	}
+	return lost, nil
+}
+
+// initTestCache makes the cache over simulated devices without the update loop
+func initTestCache(t *testing.T) (*Cache, *fakeOutput) {
+	return initLossyTestCache(t, nil)
+}
+
+// initLossyTestCache makes the test cache, the simulated devices are behind the lossy transmitter if it is not nil
+func initLossyTestCache(t *testing.T, lossy *lossyTransmitter) (*Cache, *fakeOutput) {
+	dir, err := ioutil.TempDir("", "cache")
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	name := filepath.Join(dir, "devices.json")
+	if err := ioutil.WriteFile(name, []byte(testDevices), 0644); nil != err {
+		t.Fatal(err)
+	}
+	var tr SimTransciever.SimTransmitter
+	SimTransciever.Init(&tr, SimTransciever.TransmitterSettings{DevicesFile: name})
+	var rf RFModel.RFModel
+	if nil != lossy {
+		lossy.SimTransmitter = &tr
+		RFModel.Init(&rf, lossy)
+	} else {
+		RFModel.Init(&rf, &tr)
+	}
+	out := &fakeOutput{values: map[string]string{}}
+	c := &Cache{
+		rf:          &rf,
+		out:         out,
+		log:         logrus.New(),
+		cache:       make(map[Key]*Value),
+		deviceCache: make(map[DeviceKey]*DeviceState),
+	}
+	return c, out
+}
+
+func TestUpdateAccessPeriod(t *testing.T) {
+	c := Cache{cache: make(map[Key]*Value), deviceCache: make(map[DeviceKey]*DeviceState)}
+	key := Key{UID: RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}, FNo: 0x10}
+	c.ensureKeyExists(key, true)
+	value := c.cache[key]
+	c.updateAccessPeriod(key)
+	if time.Second != value.AccessPeriod {
+		t.Errorf("fixed period is %v", value.AccessPeriod)
+	}
+	value.MinAccessPeriod = 100 * time.Millisecond
+	value.MaxAccessPeriod = 10 * time.Second
+	// nobody reads it
+	value.LastRequest = time.Now().Add(-time.Hour)
+	c.updateAccessPeriod(key)
+	if value.MaxAccessPeriod != value.AccessPeriod {
+		t.Errorf("unread period is %v", value.AccessPeriod)
+	}
+	// read often
+	for i := 0; 5 > i; i++ {
+		c.ensureKeyExists(key, true)
+	}
+	c.updateAccessPeriod(key)
+	if value.MinAccessPeriod != value.AccessPeriod {
+		t.Errorf("watched period is %v", value.AccessPeriod)
+	}
+	// read once in 2 seconds
+	value.requestPeriod = 2 * time.Second
+	value.LastRequest = time.Now().Add(-time.Second)
+	c.updateAccessPeriod(key)
+	if 2*time.Second != value.AccessPeriod {
+		t.Errorf("period of reads once in 2 seconds is %v", value.AccessPeriod)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	c, out := initTestCache(t)
+	uid := RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}
+	first := c.Command(uid, 0x11, "true")
+	second := c.Command(uid, 0x11, "false")
+	c.commandRequest(Key{UID: uid, FNo: 0x11}, "true", "3")
+	c.updateRoutine()
+	for _, result := range []<-chan error{first, second} {
+		select {
+		case err := <-result:
+			if nil != err {
+				t.Errorf("command error %v", err)
+			}
+		default:
+			t.Error("command is not delivered")
+		}
+	}
+	if 1 != len(out.results) || "3 "+OutsideInterface.CRDelivered != out.results[0] {
+		t.Errorf("outside interface results are %v", out.results)
+	}
+	if v, err := c.rf.ReadFunctionContext(context.Background(), uid, 0x10); nil != err || true != v {
+		t.Errorf("value after the commands is %v, error %v", v, err)
+	}
+	// absent device keeps them queued until the queue is full
+	absent := RFModel.UID{Address: RFModel.ParseAddress("01:02:03:04:05"), Unit: 1}
+	for i := 0; commandQueueLength > i; i++ {
+		c.Command(absent, 0x11, "true")
+	}
+	select {
+	case err := <-c.Command(absent, 0x11, "true"):
+		if nil == err {
+			t.Error("command over the queue length is delivered")
+		}
+	default:
+		t.Error("command over the queue length is not failed")
+	}
+}
+
+func TestCommandLostResponse(t *testing.T) {
+	lossy := &lossyTransmitter{unit: 1, fno: 0x11, responses: true}
+	c, _ := initLossyTestCache(t, lossy)
+	uid := RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}
+	result := c.Command(uid, 0x11, "true")
+	c.updateRoutine()
+	select {
+	case err := <-result:
+		if !errors.Is(err, RFModel.ErrDeviceTimeout) {
+			t.Errorf("command error %v", err)
+		}
+	default:
+		t.Error("command is not failed")
+	}
+	// pulse is fired once
+	if 1 != lossy.delivered {
+		t.Errorf("device got the command %v times", lossy.delivered)
+	}
+}
+
+func TestCommandWrites(t *testing.T) {
+	c, _ := initTestCache(t)
+	out := &writesOutput{writes: make(chan OutsideInterface.SubMessage)}
+	c.out = out
+	if err := c.apply(testConfig(t, testDevices)); nil != err {
+		t.Fatal(err)
+	}
+	key := Key{UID: RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}, FNo: 0x11}
+	// pulses are not merged like values
+	out.writes <- OutsideInterface.SubMessage{Value: "true"}
+	out.writes <- OutsideInterface.SubMessage{Value: "true"}
+	for queued := 0; 2 != queued; time.Sleep(time.Millisecond) {
+		c.commandMutex.Lock()
+		queued = len(c.cache[key].commands)
+		c.commandMutex.Unlock()
+	}
+	c.updateRoutine()
+	if 0 != len(c.cache[key].commands) || WSWritten != c.cache[key].WriteState {
+		t.Errorf("commands left %v, write state %v", len(c.cache[key].commands), c.cache[key].WriteState)
+	}
+	expected := []string{OutsideInterface.WSPending, OutsideInterface.WSPending, OutsideInterface.WSPending, OutsideInterface.WSWritten}
+	if fmt.Sprint(expected) != fmt.Sprint(out.writeStates) {
+		t.Errorf("reported write states are %v", out.writeStates)
+	}
+}
+
+func TestWriteState(t *testing.T) {
+	c, out := initTestCache(t)
+	uid := RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}
+	key := Key{UID: uid, FNo: 0x11}
+	c.SetCached(uid, 0x11, "true")
+	c.cache[key].Writeable = true
+	c.updateRoutine()
+	if WSWritten != c.cache[key].WriteState {
+		t.Errorf("write state is %v", c.cache[key].WriteState)
+	}
+	// the device does not have the function, so its value can not be serialized
+	bad := Key{UID: uid, FNo: 0x13}
+	c.SetCached(uid, 0x13, "1")
+	c.cache[bad].Writeable = true
+	for i := 0; maxWriteAttempts > i; i++ {
+		if WSPending != c.cache[bad].WriteState {
+			t.Errorf("write state after %v attempts is %v", i, c.cache[bad].WriteState)
+		}
+		c.updateRoutine()
+	}
+	if WSFailed != c.cache[bad].WriteState || "" == c.cache[bad].WriteError {
+		t.Errorf("write state is %v, reason <%v>", c.cache[bad].WriteState, c.cache[bad].WriteError)
+	}
+	if SOnline != c.deviceCache[DeviceKey(uid.Address)].State {
+		t.Errorf("device state after the bad value is %v", c.deviceCache[DeviceKey(uid.Address)].State)
+	}
+	expected := []string{OutsideInterface.WSPending, OutsideInterface.WSWritten, OutsideInterface.WSPending, OutsideInterface.WSFailed}
+	if fmt.Sprint(expected) != fmt.Sprint(out.writeStates) {
+		t.Errorf("reported write states are %v", out.writeStates)
+	}
+}
+
+func TestTimedOutWriteFails(t *testing.T) {
+	c, out := initLossyTestCache(t, &lossyTransmitter{unit: 1, fno: 0x11})
+	uid := RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}
+	key := Key{UID: uid, FNo: 0x11}
+	c.SetCached(uid, 0x11, "true")
+	c.cache[key].Writeable = true
+	// timed out write makes the device offline, the probe finds it online again, attempts are not given anew
+	for i := 0; maxWriteAttempts > i; i++ {
+		c.updateRoutine()
+	}
+	if WSFailed != c.cache[key].WriteState {
+		t.Errorf("write state after %v timed out attempts is %v", maxWriteAttempts, c.cache[key].WriteState)
+	}
+	expected := []string{OutsideInterface.WSPending, OutsideInterface.WSFailed}
+	if fmt.Sprint(expected) != fmt.Sprint(out.writeStates) {
+		t.Errorf("reported write states are %v", out.writeStates)
+	}
+}
+
+func TestParseOutputKey(t *testing.T) {
+	c := Cache{}
+	key := Key{UID: RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 0x1F}, FNo: 0x1A}
+	uid, fno, err := ParseOutputKey(c.outputKey(key))
+	if nil != err || key.UID != uid || key.FNo != fno {
+		t.Errorf("ParseOutputKey(%v) = %v, %v, %v", c.outputKey(key), uid, fno, err)
+	}
+	if _, _, err := ParseOutputKey("AA:AA:AA:AA:01|1A"); nil == err {
+		t.Error("key without unit is parsed")
+	}
+}
+
+func testConfig(t *testing.T, devices string) config {
+	ret, err := ParseDevices([]byte(devices))
+	if nil != err {
+		t.Fatal(err)
+	}
+	return ret.config()
+}
+
+func TestReload(t *testing.T) {
+	c, out := initTestCache(t)
+	if err := c.apply(testConfig(t, testDevices)); nil != err {
+		t.Fatal(err)
+	}
+	uid := RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}
+	read, write := Key{UID: uid, FNo: 0x10}, Key{UID: uid, FNo: 0x11}
+	c.cache[read].ReadValue = "true"
+	subscription := c.cache[write].unsubscribe
+	queued := c.Command(uid, 0x11, "true")
+	// renamed, slower, with a new unit and a new device
+	if err := c.apply(testConfig(t, `{
+		"relay": {
+			"address": "AA:AA:AA:AA:01",
+			"units": {
+				"unit 1": {
+					"address": 1,
+					"functions": {
+						"impulse": {"function": 16, "read": true, "write": true, "command": true, "access period": 5}
+					}
+				},
+				"unit 2": {
+					"address": 2,
+					"functions": {
+						"state": {"function": 32, "read": true, "write": false}
+					}
+				}
+			}
+		},
+		"sensor": {
+			"address": "AA:AA:AA:AA:02",
+			"units": {}
+		}
+	}`)); nil != err {
+		t.Fatal(err)
+	}
+	if "true" != c.cache[read].ReadValue || "impulse" != c.cache[read].FunctionName || 5*time.Second != c.cache[read].MaxAccessPeriod {
+		t.Errorf("kept key is %+v", c.cache[read])
+	}
+	if subscription != c.cache[write].unsubscribe || 1 != len(c.cache[write].commands) {
+		t.Error("unchanged writable key is subscribed again or its commands are lost")
+	}
+	if value, ok := c.cache[Key{UID: RFModel.UID{Address: uid.Address, Unit: 2}, FNo: 0x20}]; !ok || !value.Readable {
+		t.Error("new key is not registered")
+	}
+	if state, ok := c.deviceCache[DeviceKey(RFModel.ParseAddress("AA:AA:AA:AA:02"))]; !ok || "sensor" != state.Name {
+		t.Error("new device is not registered")
+	}
+	if 0 != len(out.unregistered) {
+		t.Errorf("unregistered %v", out.unregistered)
+	}
+	// relay is removed
+	if err := c.apply(testConfig(t, `{"sensor": {"address": "AA:AA:AA:AA:02", "units": {}}}`)); nil != err {
+		t.Fatal(err)
+	}
+	if 0 != len(c.cache) {
+		t.Errorf("keys left %v", c.cache)
+	}
+	if _, ok := c.deviceCache[DeviceKey(uid.Address)]; ok {
+		t.Error("removed device is left")
+	}
+	select {
+	case err := <-queued:
+		if nil == err {
+			t.Error("queued command of the removed key is delivered")
+		}
+	default:
+		t.Error("queued command of the removed key is not failed")
+	}
+	sort.Strings(out.unregistered)
+	expected := []string{"AA:AA:AA:AA:01:00|D", "AA:AA:AA:AA:01:01|10", "AA:AA:AA:AA:01:01|11", "AA:AA:AA:AA:01:02|20"}
+	if fmt.Sprint(expected) != fmt.Sprint(out.unregistered) {
+		t.Errorf("unregistered %v", out.unregistered)
+	}
+}
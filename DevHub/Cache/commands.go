@@ -0,0 +1,115 @@
+package Cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"../OutsideInterface"
+	"../RFModel"
+)
+
+// commandQueueLength limits commands waiting for the device, the rest are failed right away
+const commandQueueLength = 16
+
+// command is a single queued write, which is never merged with the others
+type command struct {
+	value  string
+	id     string // of the outside interface message, empty for Command calls
+	result chan error
+}
+
+// Command queues the value to be written to the function, commands are delivered once each in order
+// returned channel receives nil when the command is delivered or the reason it failed
+// the request is sent once, the device would execute it again, failed command could have been delivered with the response lost
+func (c *Cache) Command(uid RFModel.UID, fno RFModel.FuncNo, value string) <-chan error {
+	key := Key{UID: uid, FNo: fno}
+	c.ensureKeyExists(key, false)
+	return c.commandRequest(key, value, "")
+}
+
+// commandRequest is entrypoint for commands from outside interface
+func (c *Cache) commandRequest(key Key, value string, id string) <-chan error {
+	cmd := &command{value: value, id: id, result: make(chan error, 1)}
+	c.cacheMutex.RLock(); defer c.cacheMutex.RUnlock()
+	if _, ok := c.cache[key]; !ok {
+		c.commandDone(key, cmd, fmt.Errorf("Cache.commandRequest(%v): removed from devices.json; ", c.outputKey(key)))
+		return cmd.result
+	}
+	c.commandMutex.Lock()
+	if commandQueueLength <= len(c.cache[key].commands) {
+		err := fmt.Errorf("Cache.commandRequest(%v): queue is full; ", c.outputKey(key))
+		c.commandWriteState(key, err)
+		c.commandMutex.Unlock()
+		c.commandDone(key, cmd, err)
+		return cmd.result
+	}
+	c.cache[key].commands = append(c.cache[key].commands, cmd)
+	c.commandWriteState(key, nil)
+	c.commandMutex.Unlock()
+	return cmd.result
+}
+
+// performCommands delivers queued commands of the key until the queue is empty or delivery fails
+// for the update routine, cacheMutex and deviceCacheMutex should be locked
+func (c *Cache) performCommands(key Key) {
+	for {
+		c.commandMutex.Lock()
+		if 0 == len(c.cache[key].commands) {
+			c.commandMutex.Unlock()
+			return
+		}
+		cmd := c.cache[key].commands[0]
+		c.cache[key].commands = c.cache[key].commands[1:]
+		c.commandMutex.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+		err := c.rf.WriteFunctionOnceContext(ctx, key.UID, key.FNo, cmd.value)
+		cancel()
+		c.commandDone(key, cmd, err)
+		c.commandMutex.Lock()
+		c.commandWriteState(key, err)
+		c.commandMutex.Unlock()
+		if nil != err {
+			c.log.Debug(fmt.Sprintf("Cache.performCommands(%v): %v", c.outputKey(key), err))
+			if errors.Is(err, RFModel.ErrBadParameter) {
+				// the device did not get it, the rest are delivered
+				continue
+			}
+			c.setCallErrorState(DeviceKey(key.UID.Address), err)
+			// the rest waits for the device to be online again
+			return
+		}
+	}
+}
+
+// commandDone reports the command result to its channel and to the outside interface
+func (c *Cache) commandDone(key Key, cmd *command, err error) {
+	cmd.result <- err
+	commander, ok := c.out.(OutsideInterface.Commander)
+	if !ok || "" == cmd.id {
+		return
+	}
+	if nil == err {
+		commander.CommandResult(c.outputKey(key), cmd.id, OutsideInterface.CRDelivered, "")
+	} else {
+		commander.CommandResult(c.outputKey(key), cmd.id, OutsideInterface.CRFailed, err.Error())
+	}
+}
+
+// commandWriteState reports the commands of the command function as its write state:
+// failed with the last error, pending while more of them are queued, written when all of them are delivered
+// Command calls to the functions, which are not commands, are not their writes
+// cacheMutex and commandMutex should be locked
+func (c *Cache) commandWriteState(key Key, err error) {
+	if !c.cache[key].Command {
+		return
+	}
+	switch {
+	case nil != err:
+		c.setWriteState(key, WSFailed, err.Error())
+	case 0 != len(c.cache[key].commands):
+		c.setWriteState(key, WSPending, "")
+	default:
+		c.setWriteState(key, WSWritten, "")
+	}
+}
@@ -0,0 +1,76 @@
+package Cache
+
+import (
+	"time"
+
+	"../RFModel"
+)
+
+// config is Devices as the cache keys see them
+type config struct {
+	devices map[DeviceKey]*deviceConfig
+	keys    map[Key]*functionConfig
+}
+
+type deviceConfig struct {
+	name string
+	key  []byte // pre-shared key, nil for plaintext devices
+}
+
+// functionConfig is devices.json function of a single key, read and write keys of the function get one each
+type functionConfig struct {
+	deviceName      string
+	unitName        string
+	functionName    string
+	readable        bool
+	writeable       bool
+	accessPeriod    time.Duration
+	minAccessPeriod time.Duration
+	maxAccessPeriod time.Duration
+	dataType        RFModel.EDataType // EDUnspecified if it is not in devices.json
+	command         bool
+}
+
+// readConfig reads and validates devices.json
+func readConfig(devicesFile string) (config, error) {
+	devices, err := ReadDevices(devicesFile)
+	if nil != err {
+		return config{}, err
+	}
+	return devices.config(), nil
+}
+
+// config makes cache keys of the devices
+func (d Devices) config() config {
+	ret := config{devices: make(map[DeviceKey]*deviceConfig), keys: make(map[Key]*functionConfig)}
+	for deviceName, device := range d {
+		ret.devices[DeviceKey(device.Address)] = &deviceConfig{name: deviceName, key: device.Key}
+		for unitName, unit := range device.Units {
+			for functionName, function := range unit.Functions {
+				fc := functionConfig{
+					deviceName:      deviceName,
+					unitName:        unitName,
+					functionName:    functionName,
+					accessPeriod:    function.AccessPeriod,
+					minAccessPeriod: function.MinAccessPeriod,
+					maxAccessPeriod: function.MaxAccessPeriod,
+					dataType:        function.Type,
+					command:         function.Command,
+				}
+				key := Key{UID: RFModel.UID{Address: device.Address, Unit: unit.Address}, FNo: function.Function}
+				if function.Read {
+					read := fc
+					read.readable = true
+					ret.keys[key] = &read
+				}
+				if function.Write {
+					write := fc
+					write.writeable = true
+					key.FNo += 1
+					ret.keys[key] = &write
+				}
+			}
+		}
+	}
+	return ret
+}
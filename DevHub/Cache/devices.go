@@ -0,0 +1,303 @@
+package Cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"../RFModel"
+	"github.com/flynn/json5"
+)
+
+// firstFunction is the first function number of devices.json, lower ones are standard functions of every unit
+const firstFunction = int(RFModel.FSetTextDescription) + 1
+
+// Devices is devices.json by device names
+type Devices map[string]*Device
+
+type Device struct {
+	Address RFModel.DeviceAddress
+	Key     []byte // pre-shared key, nil for plaintext devices
+	// by unit names
+	Units map[string]*Unit
+}
+
+type Unit struct {
+	Address byte
+	// by function names
+	Functions map[string]*Function
+}
+
+// Function is a pair of read and write functions, write function number is read one + 1
+type Function struct {
+	Function        RFModel.FuncNo
+	Read            bool
+	Write           bool
+	AccessPeriod    time.Duration
+	MinAccessPeriod time.Duration
+	MaxAccessPeriod time.Duration
+	Type            RFModel.EDataType // EDUnspecified if it is not in devices.json
+	Command         bool
+}
+
+// ConfigError is every problem of devices.json, each of them starts with its device/unit/function path
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("devices.json has %v problems: %v", len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// ReadDevices reads and validates devices.json, problems of it are *ConfigError
+func ReadDevices(fileName string) (Devices, error) {
+	text, err := ioutil.ReadFile(fileName)
+	if nil != err {
+		return nil, fmt.Errorf("Cache.ReadDevices: ioutil.ReadFile: %v; ", err)
+	}
+	return ParseDevices(text)
+}
+
+// ParseDevices validates devices.json text and makes Devices out of it
+// unknown keys are allowed, simulator takes its own ones from the same format
+func ParseDevices(text []byte) (Devices, error) {
+	var data interface{}
+	if err := json5.Unmarshal(text, &data); nil != err {
+		return nil, fmt.Errorf("Cache.ParseDevices: json5.Unmarshal: %v; ", err)
+	}
+	var v validator
+	ret := v.devices(data)
+	if 0 != len(v.problems) {
+		return nil, &ConfigError{Problems: v.problems}
+	}
+	return ret, nil
+}
+
+// validator collects problems instead of stopping at the first one
+type validator struct {
+	problems []string
+}
+
+func (v *validator) problem(path string, format string, args ...interface{}) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	ret := make([]string, 0, len(m))
+	for key := range m {
+		ret = append(ret, key)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// value of the key, missing required one is a problem
+func (v *validator) value(path string, object map[string]interface{}, key string, required bool) (interface{}, bool) {
+	value, ok := object[key]
+	if !ok && required {
+		v.problem(path, "\"%v\" is missing", key)
+	}
+	return value, ok
+}
+
+func (v *validator) object(path string, object map[string]interface{}, key string) (map[string]interface{}, bool) {
+	value, ok := v.value(path, object, key, true)
+	if !ok {
+		return nil, false
+	}
+	ret, ok := value.(map[string]interface{})
+	if !ok {
+		v.problem(path, "\"%v\" has to be an object", key)
+	}
+	return ret, ok
+}
+
+func (v *validator) boolean(path string, object map[string]interface{}, key string, required bool) (ret bool, ok bool) {
+	value, ok := v.value(path, object, key, required)
+	if !ok {
+		return false, false
+	}
+	if ret, ok = value.(bool); !ok {
+		v.problem(path, "\"%v\" has to be true or false", key)
+	}
+	return ret, ok
+}
+
+func (v *validator) text(path string, object map[string]interface{}, key string, required bool) (ret string, ok bool) {
+	value, ok := v.value(path, object, key, required)
+	if !ok {
+		return "", false
+	}
+	if ret, ok = value.(string); !ok {
+		v.problem(path, "\"%v\" has to be a string", key)
+	}
+	return ret, ok
+}
+
+// byteNumber is an integer of min..255
+func (v *validator) byteNumber(path string, object map[string]interface{}, key string, min int) (byte, bool) {
+	value, ok := v.value(path, object, key, true)
+	if !ok {
+		return 0, false
+	}
+	number, ok := value.(float64)
+	if !ok || number != math.Trunc(number) || float64(min) > number || 255 < number {
+		v.problem(path, "\"%v\" has to be an integer of %v..255, got %v", key, min, value)
+		return 0, false
+	}
+	return byte(number), true
+}
+
+// period in seconds, it can not be negative
+func (v *validator) period(path string, object map[string]interface{}, key string) (time.Duration, bool) {
+	value, ok := v.value(path, object, key, false)
+	if !ok {
+		return 0, false
+	}
+	seconds, ok := value.(float64)
+	if !ok || 0 > seconds {
+		v.problem(path, "\"%v\" has to be a non-negative number of seconds, got %v", key, value)
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+func (v *validator) devices(data interface{}) Devices {
+	ret := make(Devices)
+	devices, ok := data.(map[string]interface{})
+	if !ok {
+		v.problem("devices.json", "has to be an object of devices")
+		return ret
+	}
+	addresses := make(map[RFModel.DeviceAddress]string)
+	for _, name := range sortedKeys(devices) {
+		device, ok := devices[name].(map[string]interface{})
+		if !ok {
+			v.problem(name, "device has to be an object")
+			continue
+		}
+		d := &Device{Units: make(map[string]*Unit)}
+		if address, ok := v.text(name, device, "address", true); ok {
+			var err error
+			if d.Address, err = RFModel.TryParseAddress(address); nil != err {
+				v.problem(name, "bad \"address\" %v, it is like AA:AA:AA:AA:01", address)
+			} else if other, ok := addresses[d.Address]; ok {
+				v.problem(name, "address %v is the same as of %v", address, other)
+			} else {
+				addresses[d.Address] = name
+			}
+		}
+		if key, ok := v.text(name, device, "key", false); ok {
+			var err error
+			if d.Key, err = RFModel.ParseKey(key); nil != err {
+				v.problem(name, "bad \"key\", it has to be %v hex bytes", RFModel.KeyLength)
+			}
+		}
+		if units, ok := v.object(name, device, "units"); ok {
+			v.units(name, units, d)
+		}
+		ret[name] = d
+	}
+	return ret
+}
+
+func (v *validator) units(devicePath string, units map[string]interface{}, d *Device) {
+	addresses := make(map[byte]string)
+	for _, name := range sortedKeys(units) {
+		path := devicePath + "/" + name
+		unit, ok := units[name].(map[string]interface{})
+		if !ok {
+			v.problem(path, "unit has to be an object")
+			continue
+		}
+		u := &Unit{Functions: make(map[string]*Function)}
+		// unit 0 is the device itself
+		if address, ok := v.byteNumber(path, unit, "address", 1); ok {
+			if other, ok := addresses[address]; ok {
+				v.problem(path, "unit address %v is the same as of %v", address, other)
+			}
+			addresses[address] = name
+			u.Address = address
+		}
+		if functions, ok := v.object(path, unit, "functions"); ok {
+			v.functions(path, functions, u)
+		}
+		d.Units[name] = u
+	}
+}
+
+func (v *validator) functions(unitPath string, functions map[string]interface{}, u *Unit) {
+	// devices.json function numbers and function numbers of the unit taken by read and write functions
+	numbers := make(map[RFModel.FuncNo]string)
+	taken := make(map[RFModel.FuncNo]string)
+	take := func(path string, fno RFModel.FuncNo, name string, kind string) {
+		if other, ok := taken[fno]; ok {
+			v.problem(path, "%v function 0x%X is taken by %v", kind, byte(fno), other)
+			return
+		}
+		taken[fno] = kind + " function of " + name
+	}
+	for _, name := range sortedKeys(functions) {
+		path := unitPath + "/" + name
+		function, ok := functions[name].(map[string]interface{})
+		if !ok {
+			v.problem(path, "function has to be an object")
+			continue
+		}
+		f := &Function{AccessPeriod: time.Second, Type: RFModel.EDUnspecified}
+		fno, fnoOk := v.byteNumber(path, function, "function", firstFunction)
+		f.Function = RFModel.FuncNo(fno)
+		var readOk, writeOk bool
+		f.Read, readOk = v.boolean(path, function, "read", true)
+		f.Write, writeOk = v.boolean(path, function, "write", true)
+		if fnoOk {
+			if other, ok := numbers[f.Function]; ok {
+				v.problem(path, "function 0x%X is the same as of %v", fno, other)
+				fnoOk = false
+			} else {
+				numbers[f.Function] = name
+			}
+		}
+		if fnoOk {
+			if f.Read {
+				take(path, f.Function, name, "read")
+			}
+			if f.Write && 255 == fno {
+				v.problem(path, "write function number 0x%X + 1 is out of range", fno)
+			} else if f.Write {
+				take(path, f.Function+1, name, "write")
+			}
+		}
+		if readOk && writeOk && !f.Read && !f.Write {
+			v.problem(path, "function is neither read nor write")
+		}
+		if period, ok := v.period(path, function, "access period"); ok {
+			f.AccessPeriod = period
+		}
+		f.MinAccessPeriod, f.MaxAccessPeriod = f.AccessPeriod, f.AccessPeriod
+		if period, ok := v.period(path, function, "min access period"); ok {
+			f.MinAccessPeriod = period
+		}
+		if period, ok := v.period(path, function, "max access period"); ok {
+			f.MaxAccessPeriod = period
+		}
+		if f.MinAccessPeriod > f.MaxAccessPeriod {
+			v.problem(path, "\"min access period\" %v is bigger than \"max access period\" %v", f.MinAccessPeriod, f.MaxAccessPeriod)
+		}
+		if dataType, ok := v.text(path, function, "type", false); ok {
+			if f.Type, ok = RFModel.ParseDataType(dataType); !ok {
+				v.problem(path, "unknown \"type\" %v", dataType)
+			}
+		}
+		if command, ok := v.boolean(path, function, "command", false); ok {
+			f.Command = command
+			if command && !f.Write {
+				v.problem(path, "\"command\" function has to be write one")
+			}
+		}
+		u.Functions[name] = f
+	}
+}
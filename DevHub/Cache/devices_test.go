@@ -0,0 +1,81 @@
+package Cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"../RFModel"
+)
+
+func TestParseDevices(t *testing.T) {
+	devices, err := ParseDevices([]byte(testDevices))
+	if nil != err {
+		t.Fatal(err)
+	}
+	f := devices["relay"].Units["unit 1"].Functions["pulse"]
+	if RFModel.ParseAddress("AA:AA:AA:AA:01") != devices["relay"].Address || 1 != devices["relay"].Units["unit 1"].Address {
+		t.Errorf("relay is %+v", devices["relay"])
+	}
+	if 0x10 != f.Function || !f.Read || !f.Write || !f.Command || time.Second != f.AccessPeriod || RFModel.EDUnspecified != f.Type {
+		t.Errorf("pulse is %+v", f)
+	}
+}
+
+func TestParseDevicesProblems(t *testing.T) {
+	_, err := ParseDevices([]byte(`{
+		"relay": {
+			"address": "AA:AA:AA:AA",
+			"units": {
+				"unit 1": {
+					"address": 1,
+					"functions": {
+						"a": {"function": 0x10, "write": true},
+						"b": {"function": 0x10, "read": true, "write": false},
+						"c": {"function": 0x11, "read": true, "write": false, "access period": -1},
+						"d": {"function": "0x12", "read": true, "write": false, "type": "float"},
+					},
+				},
+			},
+		},
+		"sensor": {
+			"address": "AA:AA:AA:AA:02",
+			"units": {"unit 1": {"address": 0, "functions": {}}},
+		},
+		"copy": {
+			"address": "aa:aa:aa:aa:02",
+		},
+	}`))
+	configError, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("error is %v", err)
+	}
+	expected := []string{
+		`copy: "units" is missing`,
+		`relay: bad "address" AA:AA:AA:AA`,
+		`relay/unit 1/a: "read" is missing`,
+		`relay/unit 1/b: function 0x10 is the same as of a`,
+		`relay/unit 1/c: read function 0x11 is taken by write function of a`,
+		`relay/unit 1/c: "access period" has to be a non-negative number of seconds`,
+		`relay/unit 1/d: "function" has to be an integer`,
+		`relay/unit 1/d: unknown "type" float`,
+		`sensor: address AA:AA:AA:AA:02 is the same as of copy`,
+		`sensor/unit 1: "address" has to be an integer of 1..255`,
+	}
+	if len(expected) != len(configError.Problems) {
+		t.Errorf("problems are %v", strings.Join(configError.Problems, "\n"))
+	}
+	for i := 0; i < len(expected) && i < len(configError.Problems); i++ {
+		if !strings.HasPrefix(configError.Problems[i], expected[i]) {
+			t.Errorf("problem %v is <%v>, expected <%v>", i, configError.Problems[i], expected[i])
+		}
+	}
+}
+
+func TestReadDevices(t *testing.T) {
+	for _, fileName := range []string{"../devices.json", "../sim devices.json"} {
+		if _, err := ReadDevices(fileName); nil != err {
+			t.Errorf("%v: %v", fileName, err)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package Cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// prometheus metrics of the update routine and devices
+var (
+	updateDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "devhub", Subsystem: "cache", Name: "update_duration_seconds",
+		Help:    "Duration of the update routine cycle.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+	})
+	deviceState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "devhub", Subsystem: "cache", Name: "device_state",
+		Help: "State of the device: 0 offline, 1 online, 2 error.",
+	}, []string{"device"})
+)
@@ -0,0 +1,228 @@
+package Cache
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"../OutsideInterface"
+	"../RFModel"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDelay collects the events of a single devices.json save, editors write it in several steps
+const watchDelay = 500 * time.Millisecond
+
+type reloadRequest struct {
+	config config
+	result chan error
+}
+
+// Reload reads devices.json again and applies the difference to the cache between update cycles:
+// keys of removed functions are unregistered, keys of new ones are registered and subscribed,
+// names, access periods, types and commands of the rest are updated
+// values, pending writes and queued commands of the kept keys stay as they are
+// broken devices.json is returned as the error and changes nothing
+func (c *Cache) Reload() error {
+	config, err := readConfig(c.devicesFile)
+	if nil != err {
+		return err
+	}
+	r := reloadRequest{config: config, result: make(chan error, 1)}
+	c.reloads <- r
+	return <-r.result
+}
+
+// Watch reloads devices.json every time it is saved
+// the directory is watched, so the file could be replaced instead of written
+func (c *Cache) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if nil != err {
+		return fmt.Errorf("Cache.Watch: fsnotify.NewWatcher: %v; ", err)
+	}
+	name := filepath.Clean(c.devicesFile)
+	if err := watcher.Add(filepath.Dir(name)); nil != err {
+		_ = watcher.Close()
+		return fmt.Errorf("Cache.Watch: %v: %v; ", filepath.Dir(name), err)
+	}
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if name == filepath.Clean(event.Name) && 0 != event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					timer = time.After(watchDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				c.log.Warning(fmt.Sprintf("Cache.Watch: %v", err))
+			case <-timer:
+				timer = nil
+				if err := c.Reload(); nil != err {
+					c.log.Error(fmt.Sprintf("Cache.Watch: devices.json is not reloaded: %v", err))
+				} else {
+					c.log.Info(fmt.Sprintf("Cache.Watch: %v is reloaded", name))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// apply makes the cache match devices.json config, the update routine should not be running
+func (c *Cache) apply(config config) error {
+	var keyChanges []DeviceKey
+	var describedDevices, removedDevices []DeviceKey
+	c.deviceCacheMutex.Lock()
+	for device, dc := range config.devices {
+		state, ok := c.deviceCache[device]
+		if !ok {
+			state = &DeviceState{State: SOffline}
+			c.deviceCache[device] = state
+		}
+		if !state.configured || dc.name != state.Name {
+			describedDevices = append(describedDevices, device)
+		}
+		if !bytes.Equal(dc.key, state.key) {
+			keyChanges = append(keyChanges, device)
+		}
+		state.configured = true
+		state.Name = dc.name
+		state.key = dc.key
+	}
+	for device, state := range c.deviceCache {
+		if _, ok := config.devices[device]; state.configured && !ok {
+			if nil != state.key {
+				keyChanges = append(keyChanges, device)
+			}
+			state.configured = false
+			state.Name = ""
+			state.key = nil
+			removedDevices = append(removedDevices, device)
+		}
+	}
+	c.deviceCacheMutex.Unlock()
+	for _, device := range keyChanges {
+		if err := c.rf.SetDeviceKey(RFModel.DeviceAddress(device), config.devices[device].keyOrNil()); nil != err {
+			return fmt.Errorf("Cache.apply: key of %v: %v", RFModel.AddressToString(RFModel.DeviceAddress(device)), err)
+		}
+	}
+
+	var described, subscribed, unregistered []Key
+	removed := make(map[Key]*Value)
+	c.cacheMutex.Lock()
+	for key, value := range c.cache {
+		if _, ok := config.keys[key]; value.configured && !ok {
+			if nil != value.unsubscribe {
+				close(value.unsubscribe)
+			}
+			delete(c.cache, key)
+			removed[key] = value
+			unregistered = append(unregistered, key)
+		}
+	}
+	for key, fc := range config.keys {
+		value, ok := c.cache[key]
+		if !ok {
+			value = newValue()
+			c.cache[key] = value
+		}
+		changed := !value.configured || fc.deviceName != value.DeviceName || fc.unitName != value.UnitName ||
+			fc.functionName != value.FunctionName || fc.readable != value.Readable || fc.writeable != value.Writeable ||
+			(RFModel.EDUnspecified != fc.dataType && fc.dataType != value.DataType)
+		if nil != value.unsubscribe && (!fc.writeable || fc.command != value.Command) {
+			// the interface registers and describes it once again as writes or commands
+			close(value.unsubscribe)
+			value.unsubscribe = nil
+			unregistered = append(unregistered, key)
+			changed = true
+		}
+		if changed {
+			described = append(described, key)
+		}
+		if fc.writeable && nil == value.unsubscribe {
+			value.unsubscribe = make(chan struct{})
+			subscribed = append(subscribed, key)
+		}
+		value.DeviceName, value.UnitName, value.FunctionName = fc.deviceName, fc.unitName, fc.functionName
+		value.Readable, value.Writeable = fc.readable, fc.writeable
+		value.AccessPeriod = fc.accessPeriod
+		value.MinAccessPeriod, value.MaxAccessPeriod = fc.minAccessPeriod, fc.maxAccessPeriod
+		if RFModel.EDUnspecified != fc.dataType {
+			value.DataType = fc.dataType
+		}
+		value.Command = fc.command
+		value.configured = true
+	}
+	c.cacheMutex.Unlock()
+
+	for key, value := range removed {
+		c.commandMutex.Lock()
+		commands := value.commands
+		value.commands = nil
+		c.commandMutex.Unlock()
+		for _, cmd := range commands {
+			c.commandDone(key, cmd, fmt.Errorf("Cache.apply(%v): removed from devices.json; ", c.outputKey(key)))
+		}
+	}
+	if unregisterer, ok := c.out.(OutsideInterface.Unregisterer); ok {
+		for _, key := range unregistered {
+			unregisterer.UnregisterComponent(c.outputKey(key))
+		}
+	}
+	for _, device := range describedDevices {
+		c.describeStatistics(device)
+	}
+	for _, key := range described {
+		c.describeComponent(key)
+	}
+	for _, key := range subscribed {
+		c.registerWritable(key)
+	}
+	c.removeDevices(removedDevices)
+	if 0 != len(unregistered)+len(subscribed)+len(described) {
+		c.log.Info(fmt.Sprintf("Cache.apply: %v keys are removed, %v subscribed, %v described", len(removed), len(subscribed), len(described)))
+	}
+	return nil
+}
+
+// removeDevices forgets the devices removed from devices.json, unless some keys out of devices.json are read from them
+func (c *Cache) removeDevices(devices []DeviceKey) {
+	// keys are added with both locked, see ensureKeyExists
+	c.deviceCacheMutex.Lock()
+	c.cacheMutex.RLock()
+	used := make(map[DeviceKey]bool)
+	for key := range c.cache {
+		used[DeviceKey(key.UID.Address)] = true
+	}
+	var unused []DeviceKey
+	for _, device := range devices {
+		if !used[device] {
+			delete(c.deviceCache, device)
+			unused = append(unused, device)
+		}
+	}
+	c.cacheMutex.RUnlock()
+	c.deviceCacheMutex.Unlock()
+	unregisterer, ok := c.out.(OutsideInterface.Unregisterer)
+	for _, device := range unused {
+		deviceState.DeleteLabelValues(RFModel.AddressToString(RFModel.DeviceAddress(device)))
+		if ok {
+			unregisterer.UnregisterComponent(c.outputKey(statisticsKey(device)))
+		}
+	}
+}
+
+// keyOrNil is the pre-shared key of the device, nil for the device removed from devices.json
+func (dc *deviceConfig) keyOrNil() []byte {
+	if nil == dc {
+		return nil
+	}
+	return dc.key
+}
@@ -0,0 +1,82 @@
+package Cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"../OutsideInterface"
+	"../RFModel"
+)
+
+// statisticsPeriod is how often device statistics are asked and published
+const statisticsPeriod = time.Minute
+
+// diagnostics is the value of the per-device diagnostics component
+type diagnostics struct {
+	BuildNumber        uint32 `json:"build number"`
+	Uptime             uint32 `json:"uptime"` // seconds
+	RxPackets          uint32 `json:"rx packets"`
+	TxPackets          uint32 `json:"tx packets"`
+	TxFailures         uint16 `json:"tx failures"`
+	RxErrors           uint16 `json:"rx errors"`
+	TransactionErrors  uint16 `json:"transaction errors"`
+	Resets             uint16 `json:"resets"`
+	TransactionResyncs uint   `json:"transaction resyncs"` // counted by the hub, not the device
+	Updated            string `json:"updated"`
+}
+
+// statisticsKey is the diagnostics component key, unit 0 statistics function of the device
+func statisticsKey(device DeviceKey) Key {
+	return Key{UID: RFModel.UID{Address: RFModel.DeviceAddress(device), Unit: 0}, FNo: RFModel.F0GetDeviceStatistics}
+}
+
+// describeStatistics passes the diagnostics component names to the outside interface, if it wants them
+func (c *Cache) describeStatistics(device DeviceKey) {
+	describer, ok := c.out.(OutsideInterface.Describer)
+	if !ok {
+		return
+	}
+	c.deviceCacheMutex.RLock()
+	info := OutsideInterface.ComponentInfo{
+		DeviceAddress: RFModel.AddressToString(RFModel.DeviceAddress(device)),
+		DeviceName:    c.deviceCache[device].Name,
+		UnitName:      "device",
+		FunctionName:  "diagnostics",
+		Readable:      true,
+	}
+	c.deviceCacheMutex.RUnlock()
+	describer.DescribeComponent(c.outputKey(statisticsKey(device)), info)
+}
+
+// publishStatistics asks the device statistics and sends them to the outside interface
+// failed request is not repeated until the next period, firmware may not implement it at all
+// deviceCacheMutex should be locked
+func (c *Cache) publishStatistics(device DeviceKey) {
+	c.deviceCache[device].StatisticsUpdate = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	address := RFModel.DeviceAddress(device)
+	statistics, err := c.rf.DeviceStatistics(ctx, address, statisticsPeriod)
+	if nil != err {
+		c.log.Debug(fmt.Sprintf("Cache.publishStatistics(%v): %v", RFModel.AddressToString(address), err))
+		return
+	}
+	value, err := json.Marshal(diagnostics{
+		BuildNumber:        statistics.BuildNumber,
+		Uptime:             uint32(statistics.Uptime / time.Second),
+		RxPackets:          statistics.RxPackets,
+		TxPackets:          statistics.TxPackets,
+		TxFailures:         statistics.TxFailures,
+		RxErrors:           statistics.RxErrors,
+		TransactionErrors:  statistics.TransactionErrors,
+		Resets:             statistics.Resets,
+		TransactionResyncs: c.rf.TransactionResyncs(address),
+		Updated:            statistics.Time.Format(time.RFC3339),
+	})
+	if nil != err {
+		panic(fmt.Errorf("Cache.publishStatistics: json.Marshal: %v; ", err))
+	}
+	c.out.UpdateComponent(c.outputKey(statisticsKey(device)), string(value))
+}
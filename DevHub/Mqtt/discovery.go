@@ -0,0 +1,109 @@
+package Mqtt
+
+import (
+	"encoding/json"
+	"strings"
+
+	"../OutsideInterface"
+)
+
+// entity is a single devices.json function as Home Assistant sees it
+// read and write keys of the function are merged into one entity
+type entity struct {
+	uniqueID      string
+	info          OutsideInterface.ComponentInfo
+	stateTopic    string // empty for write only functions
+	commandTopic  string // empty for read only functions
+	published     string // component the config was published for
+	deviceTopic   string
+	hubStatus     string
+	discoveryRoot string
+	nodeID        string
+}
+
+// topicID makes topic level out of the device address
+func topicID(address string) string {
+	return strings.Replace(address, ":", "", -1)
+}
+
+// componentTopic is "prefix/device/unit/function" for the key of "device address:unit|function" format
+func componentTopic(prefix string, key string) string {
+	separator := strings.LastIndex(key, "|")
+	if 0 > separator {
+		return prefix + "/" + strings.NewReplacer(":", "", "/", "_", "+", "_", "#", "_").Replace(key)
+	}
+	unitPart, function := key[:separator], key[separator+1:]
+	if separator := strings.LastIndex(unitPart, ":"); 0 <= separator {
+		return prefix + "/" + topicID(unitPart[:separator]) + "/" + unitPart[separator+1:] + "/" + function
+	}
+	return prefix + "/" + topicID(unitPart) + "/" + function
+}
+
+// component is Home Assistant entity platform, empty while the data type is unknown
+func (e *entity) component() string {
+	switch e.info.DataType {
+	case "":
+		return ""
+	case "bool":
+		if "" == e.commandTopic {
+			return "binary_sensor"
+		}
+		return "switch"
+	case "byte", "int32":
+		if "" == e.commandTopic {
+			return "sensor"
+		}
+		return "number"
+	default:
+		if "" == e.commandTopic {
+			return "sensor"
+		}
+		return "text"
+	}
+}
+
+func (e *entity) configTopic(component string) string {
+	return e.discoveryRoot + "/" + component + "/" + e.nodeID + "/" + e.uniqueID + "/config"
+}
+
+// config is Home Assistant MQTT discovery payload
+func (e *entity) config() []byte {
+	config := map[string]interface{}{
+		"name":      e.info.FunctionName,
+		"unique_id": e.uniqueID,
+		"availability": []map[string]string{
+			{"topic": e.hubStatus},
+			{"topic": e.deviceTopic + "/availability"},
+		},
+		"availability_mode": "all",
+		"device": map[string]interface{}{
+			"identifiers": []string{e.nodeID + "_" + topicID(e.info.DeviceAddress)},
+			"name":        e.info.DeviceName,
+		},
+	}
+	if "" != e.stateTopic {
+		config["state_topic"] = e.stateTopic
+	}
+	if "" != e.commandTopic {
+		config["command_topic"] = e.commandTopic
+	}
+	switch e.info.DataType {
+	case "bool":
+		// Cache formats bool values with %v, RFModel.WriteFunction accepts the same
+		config["payload_on"] = "true"
+		config["payload_off"] = "false"
+	case "byte":
+		if "number" == e.component() {
+			config["min"] = 0
+			config["max"] = 255
+		}
+	case "int32":
+		if "number" == e.component() {
+			config["min"] = -2147483648
+			config["max"] = 2147483647
+			config["mode"] = "box"
+		}
+	}
+	ret, _ := json.Marshal(config)
+	return ret
+}
@@ -0,0 +1,280 @@
+// Mqtt translates components to mqtt topics
+// value of the component is retained at "prefix/device/unit/function", writes are expected at ".../set"
+// commands are expected at ".../set" as well, their results are published at ".../result", not retained
+// write state of the writable component is retained at ".../status"
+// retained topics and discovery configs of the components removed from devices.json are cleared
+// devices availability is at "prefix/device/availability", hub itself is at "prefix/status"
+// optionally Home Assistant discovery configs are published for every devices.json function
+package Mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../OutsideInterface"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.New()
+
+const (
+	qos          = 1
+	tokenTimeout = 5 * time.Second
+)
+
+type Settings struct {
+	Server          string
+	ClientID        string
+	Username        string
+	Password        string
+	TopicPrefix     string
+	DiscoveryPrefix string // empty disables Home Assistant discovery
+}
+
+type Interface struct {
+	client   mqtt.Client
+	settings Settings
+	mutex    sync.Mutex
+	// by devices.json path
+	entities map[string]*entity
+	// by set topic
+	writable map[string]*writableComponent
+	// id of the last command message
+	commandID uint64
+}
+
+type writableComponent struct {
+	key     string
+	channel chan OutsideInterface.SubMessage
+	command bool
+	// closed on unregister, the message in flight is dropped instead of blocking the client router
+	done chan struct{}
+}
+
+// writeStatus is the payload of the status topic
+type writeStatus struct {
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// commandResult is the payload of the result topic
+type commandResult struct {
+	ID     string `json:"id"`
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func Init(self *Interface, settings Settings) {
+	log.Formatter = new(logrus.TextFormatter)
+	log.Level = logrus.InfoLevel
+	log.Out = os.Stdout
+	options := mqtt.NewClientOptions().
+		AddBroker(settings.Server).
+		SetClientID(settings.ClientID).
+		SetUsername(settings.Username).
+		SetPassword(settings.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(settings.TopicPrefix+"/status", OutsideInterface.DSOffline, qos, true).
+		SetOnConnectHandler(func(mqtt.Client) { self.onConnect() })
+	initClient(self, mqtt.NewClient(options), settings)
+	if token := self.client.Connect(); token.WaitTimeout(tokenTimeout) && nil != token.Error() {
+		panic(fmt.Errorf("Mqtt.Init: connect to %v: %v; ", settings.Server, token.Error()))
+	}
+}
+
+func initClient(self *Interface, client mqtt.Client, settings Settings) {
+	self.client = client
+	self.settings = settings
+	self.entities = make(map[string]*entity)
+	self.writable = make(map[string]*writableComponent)
+}
+
+// onConnect is called on every (re)connection, subscriptions are not persistent
+func (i *Interface) onConnect() {
+	log.Info(fmt.Sprintf("Mqtt.onConnect: connected to %v", i.settings.Server))
+	i.publish(i.settings.TopicPrefix+"/status", OutsideInterface.DSOnline)
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for topic := range i.writable {
+		i.subscribe(topic)
+	}
+}
+
+func (i *Interface) publish(topic string, payload interface{}) {
+	i.send(topic, true, payload)
+}
+
+func (i *Interface) send(topic string, retained bool, payload interface{}) {
+	token := i.client.Publish(topic, qos, retained, payload)
+	go func() {
+		if token.WaitTimeout(tokenTimeout) && nil != token.Error() {
+			log.Warning(fmt.Sprintf("Mqtt.publish(%s): %v", topic, token.Error()))
+		}
+	}()
+}
+
+// subscribe to the set topic, mutex should be locked
+func (i *Interface) subscribe(topic string) {
+	w := i.writable[topic]
+	i.client.Subscribe(topic, qos, func(client mqtt.Client, message mqtt.Message) {
+		log.Debug(fmt.Sprintf("Mqtt.subscribe(%s): key %s, payload <%s>", message.Topic(), w.key, message.Payload()))
+		m := OutsideInterface.SubMessage{
+			Value: string(message.Payload()),
+			Key:   w.key,
+		}
+		if w.command {
+			i.mutex.Lock()
+			i.commandID++
+			m.ID = strconv.FormatUint(i.commandID, 10)
+			i.mutex.Unlock()
+		}
+		select {
+		case w.channel <- m:
+		case <-w.done:
+		}
+	})
+}
+
+func (i *Interface) UpdateComponent(key string, value string) {
+	i.publish(componentTopic(i.settings.TopicPrefix, key), value)
+}
+
+func (i *Interface) RegisterWritableComponent(key string) <-chan OutsideInterface.SubMessage {
+	return i.registerSetTopic(key, false)
+}
+
+// RegisterCommandComponent is the same set topic, but every message gets an id for its result
+func (i *Interface) RegisterCommandComponent(key string) <-chan OutsideInterface.SubMessage {
+	return i.registerSetTopic(key, true)
+}
+
+// CommandResult publishes the result of the command, it is an event and is not retained
+func (i *Interface) CommandResult(key string, id string, result string, reason string) {
+	payload, _ := json.Marshal(commandResult{ID: id, Result: result, Reason: reason})
+	i.send(componentTopic(i.settings.TopicPrefix, key)+"/result", false, payload)
+}
+
+// UpdateWriteState publishes the write state of the writable component
+func (i *Interface) UpdateWriteState(key string, state string, reason string) {
+	payload, _ := json.Marshal(writeStatus{State: state, Reason: reason})
+	i.publish(componentTopic(i.settings.TopicPrefix, key)+"/status", payload)
+}
+
+func (i *Interface) registerSetTopic(key string, command bool) <-chan OutsideInterface.SubMessage {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	topic := componentTopic(i.settings.TopicPrefix, key) + "/set"
+	w := &writableComponent{
+		key:     key,
+		channel: make(chan OutsideInterface.SubMessage, 2),
+		command: command,
+		done:    make(chan struct{}),
+	}
+	if previous, ok := i.writable[topic]; ok {
+		close(previous.done)
+	}
+	i.writable[topic] = w
+	if i.client.IsConnected() {
+		i.subscribe(topic)
+	}
+	return w.channel
+}
+
+// UnregisterComponent unsubscribes the set topic of the key, clears its retained topics
+// and takes the key out of its discovery entity, entity without keys is removed
+func (i *Interface) UnregisterComponent(key string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	topic := componentTopic(i.settings.TopicPrefix, key)
+	if w, ok := i.writable[topic+"/set"]; ok {
+		close(w.done)
+		delete(i.writable, topic+"/set")
+		i.client.Unsubscribe(topic + "/set")
+	}
+	// empty retained payload removes the retained message
+	i.publish(topic, "")
+	i.publish(topic+"/status", "")
+	for path, e := range i.entities {
+		switch {
+		case topic == e.stateTopic:
+			e.stateTopic = ""
+			e.info.Readable = false
+		case topic+"/set" == e.commandTopic:
+			e.commandTopic = ""
+			e.info.Writeable = false
+		default:
+			continue
+		}
+		component := e.component()
+		if "" == e.stateTopic && "" == e.commandTopic {
+			component = ""
+			delete(i.entities, path)
+		}
+		if "" != e.published && component != e.published {
+			i.publish(e.configTopic(e.published), "")
+		}
+		e.published = component
+		if "" != component {
+			i.publish(e.configTopic(component), e.config())
+		}
+	}
+}
+
+// UpdateDeviceState publishes availability of the device
+func (i *Interface) UpdateDeviceState(device string, state string) {
+	availability := OutsideInterface.DSOffline
+	if OutsideInterface.DSOnline == state {
+		availability = OutsideInterface.DSOnline
+	}
+	i.publish(i.settings.TopicPrefix+"/"+topicID(device)+"/availability", availability)
+}
+
+// DescribeComponent merges the key into its devices.json function entity and publishes discovery config
+func (i *Interface) DescribeComponent(key string, info OutsideInterface.ComponentInfo) {
+	if "" == i.settings.DiscoveryPrefix {
+		return
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	path := strings.Join([]string{info.DeviceAddress, info.DeviceName, info.UnitName, info.FunctionName}, "/")
+	e, ok := i.entities[path]
+	if !ok {
+		e = &entity{
+			uniqueID:      i.settings.ClientID + strings.Replace(componentTopic("", key), "/", "_", -1),
+			deviceTopic:   i.settings.TopicPrefix + "/" + topicID(info.DeviceAddress),
+			hubStatus:     i.settings.TopicPrefix + "/status",
+			discoveryRoot: i.settings.DiscoveryPrefix,
+			nodeID:        i.settings.ClientID,
+		}
+		i.entities[path] = e
+	}
+	known := e.info
+	e.info = info
+	e.info.Readable = known.Readable || info.Readable
+	e.info.Writeable = known.Writeable || info.Writeable
+	if "" == info.DataType {
+		e.info.DataType = known.DataType
+	}
+	if info.Readable {
+		e.stateTopic = componentTopic(i.settings.TopicPrefix, key)
+	}
+	if info.Writeable {
+		e.commandTopic = componentTopic(i.settings.TopicPrefix, key) + "/set"
+	}
+	component := e.component()
+	if "" != e.published && component != e.published {
+		// empty retained config removes the entity of the previous platform
+		i.publish(e.configTopic(e.published), "")
+	}
+	e.published = component
+	if "" != component {
+		i.publish(e.configTopic(component), e.config())
+	}
+}
@@ -0,0 +1,221 @@
+package Mqtt
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"../OutsideInterface"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type fakeToken struct{}
+
+func (t *fakeToken) Wait() bool                     { return true }
+func (t *fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (t *fakeToken) Done() <-chan struct{}          { c := make(chan struct{}); close(c); return c }
+func (t *fakeToken) Error() error                   { return nil }
+
+type fakeMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return qos }
+func (m *fakeMessage) Retained() bool    { return false }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return 0 }
+func (m *fakeMessage) Payload() []byte   { return m.payload }
+func (m *fakeMessage) Ack()              {}
+
+// fakeClient keeps the last retained payload of every topic, like a broker does
+type fakeClient struct {
+	mutex         sync.Mutex
+	retained      map[string]string
+	subscriptions map[string]mqtt.MessageHandler
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{retained: map[string]string{}, subscriptions: map[string]mqtt.MessageHandler{}}
+}
+
+func (c *fakeClient) IsConnected() bool      { return true }
+func (c *fakeClient) IsConnectionOpen() bool { return true }
+func (c *fakeClient) Connect() mqtt.Token    { return &fakeToken{} }
+func (c *fakeClient) Disconnect(uint)        {}
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch p := payload.(type) {
+	case string:
+		c.retained[topic] = p
+	case []byte:
+		c.retained[topic] = string(p)
+	}
+	return &fakeToken{}
+}
+func (c *fakeClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.subscriptions[topic] = callback
+	return &fakeToken{}
+}
+func (c *fakeClient) SubscribeMultiple(map[string]byte, mqtt.MessageHandler) mqtt.Token {
+	return &fakeToken{}
+}
+func (c *fakeClient) Unsubscribe(...string) mqtt.Token        { return &fakeToken{} }
+func (c *fakeClient) AddRoute(string, mqtt.MessageHandler)    {}
+func (c *fakeClient) OptionsReader() mqtt.ClientOptionsReader { return mqtt.ClientOptionsReader{} }
+func (c *fakeClient) get(topic string) (payload string, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	payload, ok = c.retained[topic]
+	return payload, ok
+}
+
+func initTestInterface() (*Interface, *fakeClient) {
+	client := newFakeClient()
+	var i Interface
+	initClient(&i, client, Settings{ClientID: "devhub", TopicPrefix: "devhub", DiscoveryPrefix: "homeassistant"})
+	return &i, client
+}
+
+func Test_componentTopic(t *testing.T) {
+	if got := componentTopic("devhub", "AA:AA:AA:AA:01:01|10"); "devhub/AAAAAAAA01/01/10" != got {
+		t.Errorf("componentTopic() = %v", got)
+	}
+}
+
+func TestUpdateAndWrite(t *testing.T) {
+	i, client := initTestInterface()
+	i.UpdateComponent("AA:AA:AA:AA:01:01|10", "true")
+	if payload, _ := client.get("devhub/AAAAAAAA01/01/10"); "true" != payload {
+		t.Errorf("published value is <%v>", payload)
+	}
+	channel := i.RegisterWritableComponent("AA:AA:AA:AA:01:01|11")
+	client.subscriptions["devhub/AAAAAAAA01/01/11/set"](client, &fakeMessage{topic: "devhub/AAAAAAAA01/01/11/set", payload: []byte("false")})
+	select {
+	case m := <-channel:
+		if "false" != m.Value || "AA:AA:AA:AA:01:01|11" != m.Key {
+			t.Errorf("unexpected message %v", m)
+		}
+	case <-time.After(time.Second):
+		t.Error("no message from the set topic")
+	}
+	i.UpdateWriteState("AA:AA:AA:AA:01:01|11", OutsideInterface.WSFailed, "device timeout")
+	if payload, _ := client.get("devhub/AAAAAAAA01/01/11/status"); `{"state":"failed","reason":"device timeout"}` != payload {
+		t.Errorf("write status is <%v>", payload)
+	}
+	i.UpdateDeviceState("AA:AA:AA:AA:01", OutsideInterface.DSError)
+	if payload, _ := client.get("devhub/AAAAAAAA01/availability"); OutsideInterface.DSOffline != payload {
+		t.Errorf("availability is <%v>", payload)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	i, client := initTestInterface()
+	channel := i.RegisterCommandComponent("AA:AA:AA:AA:01:01|11")
+	set := client.subscriptions["devhub/AAAAAAAA01/01/11/set"]
+	set(client, &fakeMessage{topic: "devhub/AAAAAAAA01/01/11/set", payload: []byte("true")})
+	set(client, &fakeMessage{topic: "devhub/AAAAAAAA01/01/11/set", payload: []byte("true")})
+	first, second := <-channel, <-channel
+	if "true" != first.Value || "" == first.ID || first.ID == second.ID {
+		t.Errorf("unexpected messages %v, %v", first, second)
+	}
+	i.CommandResult("AA:AA:AA:AA:01:01|11", second.ID, OutsideInterface.CRFailed, "timeout")
+	var result commandResult
+	payload, _ := client.get("devhub/AAAAAAAA01/01/11/result")
+	if err := json.Unmarshal([]byte(payload), &result); nil != err || second.ID != result.ID || OutsideInterface.CRFailed != result.Result || "timeout" != result.Reason {
+		t.Errorf("result is <%v>, error %v", payload, err)
+	}
+}
+
+func TestUnregisterInFlight(t *testing.T) {
+	i, client := initTestInterface()
+	i.RegisterWritableComponent("AA:AA:AA:AA:01:01|11")
+	set := client.subscriptions["devhub/AAAAAAAA01/01/11/set"]
+	i.UnregisterComponent("AA:AA:AA:AA:01:01|11")
+	// the channel is not read anymore, messages already routed to the callback do not block
+	done := make(chan struct{})
+	go func() {
+		for n := 0; 3 > n; n++ {
+			set(client, &fakeMessage{topic: "devhub/AAAAAAAA01/01/11/set", payload: []byte("true")})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("set callback blocks after unregister")
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	info := OutsideInterface.ComponentInfo{
+		DeviceAddress: "AA:AA:AA:AA:01",
+		DeviceName:    "actuator",
+		UnitName:      "unit 1",
+		FunctionName:  "Out 1",
+		DataType:      "bool",
+	}
+	tests := []struct {
+		name      string
+		readable  bool
+		writeable bool
+		topic     string
+	}{
+		{"read only bool", true, false, "homeassistant/binary_sensor/devhub/devhub_AAAAAAAA01_01_10/config"},
+		{"write only bool", false, true, "homeassistant/switch/devhub/devhub_AAAAAAAA01_01_11/config"},
+		{"read and write bool", true, true, "homeassistant/switch/devhub/devhub_AAAAAAAA01_01_10/config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, client := initTestInterface()
+			if tt.readable {
+				readInfo := info
+				readInfo.Readable = true
+				i.DescribeComponent("AA:AA:AA:AA:01:01|10", readInfo)
+			}
+			if tt.writeable {
+				writeInfo := info
+				writeInfo.Writeable = true
+				i.DescribeComponent("AA:AA:AA:AA:01:01|11", writeInfo)
+			}
+			payload, ok := client.get(tt.topic)
+			if !ok {
+				t.Fatalf("no config at %v, got %v", tt.topic, client.retained)
+			}
+			var config map[string]interface{}
+			if err := json.Unmarshal([]byte(payload), &config); nil != err {
+				t.Fatal(err)
+			}
+			if _, ok := config["state_topic"]; ok != tt.readable {
+				t.Errorf("state_topic presence is %v", ok)
+			}
+			if _, ok := config["command_topic"]; ok != tt.writeable {
+				t.Errorf("command_topic presence is %v", ok)
+			}
+			if "Out 1" != config["name"] || "true" != config["payload_on"] {
+				t.Errorf("unexpected config %v", config)
+			}
+		})
+	}
+	// read and write bool replaces binary sensor with the switch
+	i, client := initTestInterface()
+	readInfo, writeInfo := info, info
+	readInfo.Readable, writeInfo.Writeable = true, true
+	i.DescribeComponent("AA:AA:AA:AA:01:01|10", readInfo)
+	i.DescribeComponent("AA:AA:AA:AA:01:01|11", writeInfo)
+	if payload, _ := client.get("homeassistant/binary_sensor/devhub/devhub_AAAAAAAA01_01_10/config"); "" != payload {
+		t.Errorf("binary sensor config was not removed: %v", payload)
+	}
+	// unknown data type is not published
+	i, client = initTestInterface()
+	info.DataType = ""
+	i.DescribeComponent("AA:AA:AA:AA:01:01|10", info)
+	if 0 != len(client.retained) {
+		t.Errorf("something was published for unknown data type: %v", client.retained)
+	}
+}
@@ -1,6 +1,7 @@
 package NRFTransciever
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -19,27 +20,55 @@ import (
 
 var log = logrus.New()
 
+// Default timeouts of SendCommand
+const (
+	// TX_DS or MAX_RT comes in a few milliseconds, it is not coming at all if the chip is broken
+	DefaultTxTimeout = 100 * time.Millisecond
+	// the device answers right after the request
+	DefaultListenTimeout = time.Second
+)
+
+// irqCheckPeriod is how often run checks the status without an IRQ edge, missed edges do not stall transactions
+const irqCheckPeriod = 100 * time.Millisecond
+
+// DefaultPollPeriod of the status without IRQ pin, the packet takes about a millisecond on the air
+const DefaultPollPeriod = time.Millisecond
+
 // NRFTransmitter "handle"
 type NRFTransmitter struct {
-	TranscieverModel.Transmitter
-	port           spi.PortCloser
-	connection     spi.Conn
-	status         uint8
-	channel        uint8
-	ce             gpio.PinOut
-	irq            gpio.PinIn
-	ReceiveMessage chan TranscieverModel.Message
-	//SendMessage       chan TranscieverModel.Message
-	SendMessageStatus chan TranscieverModel.Message
-	mutex             sync.Mutex
+	port          spi.PortCloser
+	connection    spi.Conn
+	status        uint8
+	channel       uint8
+	ce            gpio.PinOut
+	irq           gpio.PinIn // nil if the status is polled
+	pollPeriod    time.Duration
+	txTimeout     time.Duration
+	listenTimeout time.Duration
+	// TX_DS, MAX_RT and received packets from the IRQ handler, SendCommand takes them during its transaction
+	// the ones, which came between transactions, are discarded
+	events chan TranscieverModel.Message
+	// stop is closed by Close, stopped is closed by run on exit
+	stop            chan struct{}
+	stopped         chan struct{}
+	closeOnce       sync.Once
+	mutex           sync.Mutex
 	sendCommandLock sync.Mutex
 }
 
 type TransmitterSettings struct {
 	PortName string
-	IrqName  string
-	CEName   string
-	Speed    float32
+	// empty one polls the status every PollPeriod, for boards without IRQ line or edge detection
+	IrqName string
+	CEName  string
+	Speed   float32
+	// zero one is DefaultPollPeriod
+	PollPeriod time.Duration
+	// zero ones are DefaultTxTimeout and DefaultListenTimeout
+	TxTimeout     time.Duration
+	ListenTimeout time.Duration
+	// zero one is DefaultRadioSettings
+	Radio RadioSettings
 }
 
 // BV returns 2^b
@@ -64,7 +93,7 @@ func setCE(rf *NRFTransmitter, value bool) {
  * @param data length of data array determines how much bytes would be read and written
  */
 func sendCommand(rf *NRFTransmitter, command Command, data []byte) []byte {
-	log.Debug(fmt.Sprintf("sendCommand %x, data %v, rf %v\n", command, data, rf))
+	log.Debug(fmt.Sprintf("sendCommand %x, data %v\n", command, data))
 	var write = make([]byte, 1)
 	write[0] = byte(command)
 	write = append(write, data...)
@@ -101,18 +130,13 @@ func setPrimRx(rf *NRFTransmitter, v bool) {
 	writeRegister(rf, RConfig, config)
 }
 
+// getPipeNumberReceived is the pipe of the RX FIFO head packet of the last status, 7 if RX FIFO is empty
 func getPipeNumberReceived(rf *NRFTransmitter) byte {
-	ret := (rf.status & BRxPNoMask) >> BRxPNo
-	if 7 == ret {
-		panic(errors.New("RX FIFO empty"))
-	}
-	return ret
+	return (rf.status & BRxPNoMask) >> BRxPNo
 }
 
 // Init ...
 func Init(rf *NRFTransmitter, settings TransmitterSettings) {
-	rf.mutex.Lock()
-	defer rf.mutex.Unlock()
 	// logging
 	//log.Formatter = new(logrus.JSONFormatter)
 	log.Formatter = new(logrus.TextFormatter) //default
@@ -120,7 +144,7 @@ func Init(rf *NRFTransmitter, settings TransmitterSettings) {
 	//log.Formatter.(*logrus.TextFormatter).DisableTimestamp = true // remove timestamp from test output
 	log.Level = logrus.InfoLevel
 	log.Out = os.Stdout
-	log.Info(fmt.Sprintf("OpenTransmitter begin, %v", &rf.mutex))
+	log.Info("OpenTransmitter begin")
 	// Make sure periphery is initialized.
 	if _, err := host.Init(); err != nil {
 		panic(errors.New("host.Init: " + err.Error()))
@@ -143,56 +167,109 @@ func Init(rf *NRFTransmitter, settings TransmitterSettings) {
 	if err != nil {
 		panic(errors.New("port.Connect: " + err.Error()))
 	}
-	rf.connection = connection
 	// now GPIO
 	// notice: if pin configured as input and tied to irq, it can not be reconfigured as output, but it does not produce error
 	// so it needs to be unexported or untied from irq before changing direction
 	// CE (this signal is active high and used to activate the chip in RX or TX mode)
-	rf.ce = gpioreg.ByName(settings.CEName)
-	if nil == rf.ce {
+	ce := gpioreg.ByName(settings.CEName)
+	if nil == ce {
 		panic(errors.New("ce pin <" + settings.CEName + "> was not initialized"))
 	}
+	// IRQ (this signal is active low and controlled by three maskable interrupt sources)
+	var irq gpio.PinIn
+	if "" != settings.IrqName {
+		if irq = gpioreg.ByName(settings.IrqName); nil == irq {
+			panic(errors.New("irq pin <" + settings.IrqName + "> was not initialized"))
+		}
+	}
+	start(rf, settings, connection, ce, irq)
+}
+
+// start configures the pins and the chip and starts the IRQ handler, nil irq is polling mode
+func start(rf *NRFTransmitter, settings TransmitterSettings, connection spi.Conn, ce gpio.PinOut, irq gpio.PinIn) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	rf.connection = connection
+	rf.ce = ce
+	rf.txTimeout = settings.TxTimeout
+	if 0 == rf.txTimeout {
+		rf.txTimeout = DefaultTxTimeout
+	}
+	rf.listenTimeout = settings.ListenTimeout
+	if 0 == rf.listenTimeout {
+		rf.listenTimeout = DefaultListenTimeout
+	}
 	if err := rf.ce.Out(gpio.Low); err != nil {
 		panic(errors.New("initialization CE, PinOut.Out: " + err.Error()))
 	}
-	// IRQ (this signal is active low and controlled by three maskable interrupt sources)
-	rf.irq = gpioreg.ByName(settings.IrqName)
-	if nil == rf.irq {
-		panic(errors.New("irq pin <" + settings.IrqName + "> was not initialized"))
+	rf.irq = irq
+	rf.pollPeriod = settings.PollPeriod
+	if 0 == rf.pollPeriod {
+		rf.pollPeriod = DefaultPollPeriod
 	}
-	if err := rf.irq.In(gpio.PullNoChange, gpio.FallingEdge); err != nil {
+	if nil == rf.irq {
+		log.Info(fmt.Sprintf("nRF: no IRQ pin, the status is polled every %v", rf.pollPeriod))
+	} else if err := rf.irq.In(gpio.PullNoChange, gpio.FallingEdge); err != nil {
 		panic(errors.New("Initialization IRQ, PinIn.In: " + err.Error()))
 	}
-	initNRF(rf)
+	radio := settings.Radio
+	if (RadioSettings{}) == radio {
+		radio = DefaultRadioSettings()
+	}
+	initNRF(rf, radio)
+	if log.IsLevelEnabled(logrus.DebugLevel) {
+		for _, register := range readRegisters(rf) {
+			log.Debug(register)
+		}
+	}
+	// TX_DS and the response of the transaction, the rest is for packets nobody waits for
+	rf.events = make(chan TranscieverModel.Message, 8)
+	rf.stop = make(chan struct{})
+	rf.stopped = make(chan struct{})
 	go run(rf)
 }
 
-func initNRF(rf *NRFTransmitter) {
+func initNRF(rf *NRFTransmitter, radio RadioSettings) {
 	// we do not use nrf pipes 2-5
 	setCE(rf, false)
+	selfTest(rf)
 	sendCommand(rf, CFlushRx, []byte{})
 	sendCommand(rf, CFlushTx, []byte{})
 	// clear all interrupts
 	writeByteRegister(rf, RStatus, BV(BRxDr)|BV(BTxDs)|BV(BMaxRt))
-	writeByteRegister(rf, RConfig, BV(BEnCrc)|BV(BCrcO)|BV(BPwrUp)|BV(BPrimRx))
-	// disable auto ack
-	writeByteRegister(rf, REnAA, 0)
+	// crc, auto ack, data rate, power and channel
+	applyRadio(rf, radio)
 	writeByteRegister(rf, RDynPd, BV(BDplP0)|BV(BDplP1))
 	writeByteRegister(rf, RFeature, BV(BEnDpl))
 	writeByteRegister(rf, REnRxAddr, BV(BEnRxP0))
-	// 1Mbps, max power
-	writeByteRegister(rf, RRFSetup, 0x03<<byte(BRfPwr))
 	// mode receive
 	setPrimRx(rf, true)
 }
 
-// Close — release port and gpio
+// Close — stop the IRQ handler, release port and gpio
 func (rf *NRFTransmitter) Close() {
-	if nil != rf.port {
-		_ = rf.port.Close()
-	}
-	if nil != rf.irq {
-		rf.irq.In(gpio.PullNoChange, gpio.NoEdge)
+	rf.closeOnce.Do(func() {
+		if nil != rf.stop {
+			close(rf.stop)
+		}
+		if nil != rf.irq {
+			_ = rf.irq.In(gpio.PullNoChange, gpio.NoEdge)
+		}
+		if nil != rf.stopped {
+			<-rf.stopped
+		}
+		if nil != rf.port {
+			_ = rf.port.Close()
+		}
+	})
+}
+
+// event passes the IRQ event to SendCommand, it is dropped if the transaction does not take them
+func event(rf *NRFTransmitter, m TranscieverModel.Message) {
+	select {
+	case rf.events <- m:
+	default:
+		log.Warn(fmt.Sprintf("nRFModel.event: nobody waits for %v, dropped", m))
 	}
 }
 
@@ -204,20 +281,24 @@ func receiveMessages(rf *NRFTransmitter) {
 	// 2) clear RX_DR IRQ
 	// 3) read FIFO_STATUS to check if there are more payloads available in RX FIFO
 	// 4) if there are more data in the RX FIFO, repeat from step 1)
-	defer func() {
-		// todo we need to distinguish what exactly panic happened
-		// here we should be catching getPipeNumberReceived panic only!
-		if r := recover(); nil != r {
-			log.Warn("receiveMessages panic", r)
-		}
-	}()
+	// RX_DR is cleared before the check, so the packet coming after the check raises it again
 	for {
+		writeByteRegister(rf, RStatus, BV(BRxDr))
+		pipe := getPipeNumberReceived(rf)
+		if 7 == pipe {
+			return
+		}
 		var m TranscieverModel.Message
 		m.Status = TranscieverModel.EMSDataPacket
-		m.Pipe = getPipeNumberReceived(rf) // if no messages that will panic
-		writeByteRegister(rf, RStatus, BV(BRxDr))
+		m.Pipe = pipe
 		// get payload
 		payloadLength := sendCommand(rf, CReadRxPayloadWidth, []byte{0})[0]
+		if 32 < payloadLength {
+			// corrupted packet, datasheet tells to flush
+			log.Warn(fmt.Sprintf("nRFModel.receiveMessages: payload width %v, RX FIFO is flushed", payloadLength))
+			sendCommand(rf, CFlushRx, []byte{})
+			continue
+		}
 		if 0 == payloadLength {
 			m.Payload = TranscieverModel.Payload{}
 		} else {
@@ -232,39 +313,42 @@ func receiveMessages(rf *NRFTransmitter) {
 				m.Address[len(m.Address)-1] = readRegister(rf, Register(RRxAddrP2-2+m.Pipe))[0]
 			}
 		}
-		if nil != rf.ReceiveMessage {
-			rf.ReceiveMessage <- m
-		}
-		// update rf status
-		sendCommand(rf, CNop, []byte{})
+		event(rf, m)
 	}
 }
 
-func run(rf *NRFTransmitter) {
-	// The IRQ pin is activated then TX_DS IRQ, RX_DR IRQ os MAX_RT IRQ are set high
-	// by the state machine in the STATUS register
-	for rf.irq.WaitForEdge(-1) {
-		log.Trace("IRQ happened before mutex lock")
-		rf.mutex.Lock()
-		log.Debug("IRQ happened")
-		setCE(rf, false)
+// handleIRQ takes the events of the status until all of its flags are cleared
+// IRQ stays low while any of them is set, the edge of the next one is not coming before that
+// polling mode calls it every poll period
+func handleIRQ(rf *NRFTransmitter) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	defer func() {
+		// spi and gpio failures, SendCommand gets its timeout then
+		if r := recover(); nil != r {
+			log.Error(fmt.Sprintf("nRFModel.handleIRQ: %v", r))
+		}
+	}()
+	for {
 		// update status register
 		sendCommand(rf, CNop, []byte{})
 		var m TranscieverModel.Message
-		if 0 != rf.status&BV(BTxDs) {
+		switch {
+		case 0 != rf.status&BV(BTxDs):
 			// Data Sent Tx FIFO interrupt. Asserted when the packet is transmitter on TX.
 			// If AUTO_ACK is activates, this bit is set high only when ACK is received.
+			// reset the flag
+			writeByteRegister(rf, RStatus, BV(BTxDs))
+			// listen to the response right away, RX_ADDR_P0 is set by Transmit
 			setPrimRx(rf, true)
+			setCE(rf, true)
 			copy(m.Address[:], readRegister(rf, RTxAddr))
 			m.Status = TranscieverModel.EMSAckPacket
-			// reset the flag
-			writeByteRegister(rf, RStatus, BV(BTxDs))
-			if nil != rf.SendMessageStatus {
-				rf.SendMessageStatus <- m
-			}
-		} else if 0 != rf.status&BV(BMaxRt) {
+			event(rf, m)
+		case 0 != rf.status&BV(BMaxRt):
 			// Maximum number of TX retransmits interrupt
 			// If MAX_RT is asserted, it must be cleared to enable further communication.
+			setCE(rf, false)
 			setPrimRx(rf, true)
 			copy(m.Address[:], readRegister(rf, RTxAddr))
 			m.Status = TranscieverModel.EMSAckTimeout
@@ -272,13 +356,67 @@ func run(rf *NRFTransmitter) {
 			sendCommand(rf, CFlushTx, []byte{})
 			// reset the flag
 			writeByteRegister(rf, RStatus, BV(BMaxRt))
-			if nil != rf.SendMessageStatus {
-				rf.SendMessageStatus <- m
-			}
-		} else if 0 != rf.status&BV(BRxDr) {
+			event(rf, m)
+		case 0 != rf.status&BV(BRxDr):
 			receiveMessages(rf)
+		default:
+			return
+		}
+	}
+}
+
+func run(rf *NRFTransmitter) {
+	defer close(rf.stopped)
+	if nil == rf.irq {
+		poll(rf)
+		return
+	}
+	// The IRQ pin is activated then TX_DS IRQ, RX_DR IRQ os MAX_RT IRQ are set high
+	// by the state machine in the STATUS register
+	for {
+		select {
+		case <-rf.stop:
+			return
+		default:
+		}
+		if rf.irq.WaitForEdge(irqCheckPeriod) {
+			log.Debug("IRQ happened")
+		}
+		handleIRQ(rf)
+	}
+}
+
+// poll reads the status instead of waiting for the IRQ, flags are set the same way without IRQ pin
+func poll(rf *NRFTransmitter) {
+	ticker := time.NewTicker(rf.pollPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rf.stop:
+			return
+		case <-ticker.C:
+			handleIRQ(rf)
+		}
+	}
+}
+
+// discardStale drops the packets, which came between transactions, so they are not taken for the response
+func discardStale(rf *NRFTransmitter) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	if 0 == readRegister(rf, RFifoStatus)[0]&BV(BRxEmpty) {
+		log.Debug("nRFModel.discardStale: RX FIFO is flushed")
+		sendCommand(rf, CFlushRx, []byte{})
+	}
+	writeByteRegister(rf, RStatus, BV(BRxDr))
+	// the IRQ handler passes events under the mutex, so all of them are here
+	for {
+		select {
+		case m := <-rf.events:
+			log.Debug(fmt.Sprintf("nRFModel.discardStale: %v", m))
+		default:
+			return
 		}
-		rf.mutex.Unlock()
 	}
 }
 
@@ -287,25 +425,26 @@ func Listen(rf *NRFTransmitter, address TranscieverModel.Address) {
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
 	log.Debug(fmt.Sprintf("Listen %v", address))
-	//_ = readRegister(rf, RFifoStatus)
 	writeRegister(rf, RRxAddrP0, address[:])
-	//writeByteRegister(rf, REnRxAddr, BV(BEnRxP0))
+	setPrimRx(rf, true)
 	setCE(rf, true)
 }
 
 // Transmit async request to a transciever, return immediately
+// the IRQ handler switches it to listen on the same address after TX_DS
 func Transmit(rf *NRFTransmitter, a TranscieverModel.Address, data TranscieverModel.Payload) {
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
-	log.Info("nRF model.Transmit")
+	log.Debug(fmt.Sprintf("nRF model.Transmit(%v, %v)", a, data))
 	if 32 < len(data) {
-		panic(errors.New("too big payload, " + string(len(data))))
+		panic(fmt.Errorf("too big payload, %v", len(data)))
 	}
 	// without a CE changing from low to high transmission won't start
 	setCE(rf, false)
 	time.Sleep(10 * time.Microsecond)
-	// clear interrupts
+	// clear interrupts and the packet of the failed transmission
 	writeByteRegister(rf, RStatus, BV(BTxDs)|BV(BMaxRt))
+	sendCommand(rf, CFlushTx, []byte{})
 	writeRegister(rf, RTxAddr, a[:])
 	writeRegister(rf, RRxAddrP0, a[:])
 	sendCommand(rf, CWriteTxPayload, data)
@@ -313,50 +452,83 @@ func Transmit(rf *NRFTransmitter, a TranscieverModel.Address, data TranscieverMo
 	setCE(rf, true)
 }
 
-// SendCommand — synchronous method: send request and wait response or timeout
-func (rf *NRFTransmitter) SendCommand(a TranscieverModel.Address, data TranscieverModel.Payload) (ret TranscieverModel.Message) {
+// SendCommand panics on transciever failures, see SendCommandContext
+func (rf *NRFTransmitter) SendCommand(a TranscieverModel.Address, data TranscieverModel.Payload) TranscieverModel.Message {
+	ret, err := rf.SendCommandContext(context.Background(), a, data)
+	if nil != err {
+		panic(err)
+	}
+	return ret
+}
+
+// nextEvent is the next event of the IRQ handler, ok is false if the timer fired
+func (rf *NRFTransmitter) nextEvent(ctx context.Context, timer *time.Timer) (m TranscieverModel.Message, ok bool, err error) {
+	select {
+	case m = <-rf.events:
+		return m, true, nil
+	case <-timer.C:
+		return m, false, nil
+	case <-ctx.Done():
+		return m, false, ctx.Err()
+	case <-rf.stopped:
+		return m, false, errors.New("transmitter is closed")
+	}
+}
+
+// SendCommandContext — synchronous method: send request and wait response or timeout
+// errors are transciever failures, device not responding is reported by the message status
+func (rf *NRFTransmitter) SendCommandContext(ctx context.Context, a TranscieverModel.Address, data TranscieverModel.Payload) (ret TranscieverModel.Message, err error) {
 	rf.sendCommandLock.Lock()
 	defer rf.sendCommandLock.Unlock()
+	defer func() {
+		// spi and gpio failures
+		if r := recover(); nil != r {
+			err = fmt.Errorf("nRFModel.SendCommand(%v, %v): %v", a, data, r)
+		}
+	}()
+	discardStale(rf)
 	Transmit(rf, a, data)
+	// stop listening, packets between transactions are not needed
+	defer GoIdle(rf)
 	// wait for transmission completes
-	<-rf.SendMessageStatus
-	/*select {
-	case <-time.After(2 * time.Millisecond):
-		panic(errors.New(fmt.Sprintf(
-			"callFunction.Transmit timeout, no irq TX_DS in 20ms after transmission. Address %v, payload %v",
-			a, fno, data,
-		)))
-	case <-rf.SendMessageStatus:
-		// ok
-	}//*/
-	Listen(rf, a)
-	timeoutChan := make(chan bool)
-	go func() {
-		<-time.After(10000 * time.Millisecond)
-		timeoutChan <- true
-	}()
-waitForResponse:
+	txTimer := time.NewTimer(rf.txTimeout)
+	defer txTimer.Stop()
+	for sent := false; !sent; {
+		m, ok, err := rf.nextEvent(ctx, txTimer)
+		switch {
+		case nil != err:
+			return ret, err
+		case !ok:
+			return ret, fmt.Errorf("nRFModel.SendCommand(%v, %v): no TX_DS or MAX_RT in %v", a, data, rf.txTimeout)
+		case TranscieverModel.EMSAckTimeout == m.Status:
+			log.Debug(fmt.Sprintf("nRFModel.SendCommand(%v, %v): MAX_RT", a, data))
+			return TranscieverModel.Message{Address: a, Status: TranscieverModel.EMSAckTimeout}, nil
+		case TranscieverModel.EMSAckPacket == m.Status:
+			sent = true
+		}
+	}
+	listenTimer := time.NewTimer(rf.listenTimeout)
+	defer listenTimer.Stop()
 	for {
-		select {
-		case message := <-rf.ReceiveMessage:
+		m, ok, err := rf.nextEvent(ctx, listenTimer)
+		switch {
+		case nil != err:
+			return ret, err
+		case !ok:
+			log.Debug(fmt.Sprintf("nRFModel.SendCommand(%v, %v): listen timeout", a, data))
+			return TranscieverModel.Message{Address: a, Status: TranscieverModel.EMSSlaveTimeout}, nil
+		case TranscieverModel.EMSDataPacket == m.Status:
 			// message received
-			return message
-		case <-timeoutChan:
-			log.Info("nRFModel.SendCommand: listen timeout")
-			break waitForResponse
+			return m, nil
 		}
 	}
-	return TranscieverModel.Message{
-		Status: TranscieverModel.EMSSlaveTimeout,
-	}
-	//panic(errors.New("SendCommand timeout"))
 }
 
 // GoIdle — just turn off CE
 func GoIdle(rf *NRFTransmitter) {
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
-	log.Info("nRF model.GoIdle")
+	log.Debug("nRF model.GoIdle")
 	setCE(rf, false)
 }
 
@@ -369,8 +541,19 @@ func SetRfChannel(rf *NRFTransmitter, channel byte) {
 	defer rf.mutex.Unlock()
 	log.Info("nRF model.SetRfChannel")
 	if !ValidateRfChannel(channel) {
-		panic(errors.New("incorrect channel " + string(channel)))
+		panic(fmt.Errorf("incorrect channel %v", channel))
 	}
 	rf.channel = channel
 	writeByteRegister(rf, RRFCh, channel)
 }
+
+// SetRFChannel switches the transciever to the channel, see SetRfChannel
+func (rf *NRFTransmitter) SetRFChannel(channel byte) (err error) {
+	defer func() {
+		if r := recover(); nil != r {
+			err = fmt.Errorf("nRFModel.SetRFChannel(%v): %v", channel, r)
+		}
+	}()
+	SetRfChannel(rf, channel)
+	return nil
+}
@@ -0,0 +1,385 @@
+package NRFTransciever
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"../TranscieverModel"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/spi"
+)
+
+var testAddress = TranscieverModel.Address{0xAA, 0xAA, 0xAA, 0xAA, 0x01}
+
+// fakeChip is nRF24L01 behind the fake spi.Conn, the air around it is reply
+type fakeChip struct {
+	spi.Conn
+	mutex     sync.Mutex
+	registers map[Register][]byte
+	flags     byte // RX_DR, TX_DS and MAX_RT of the status
+	rx        [][]byte
+	tx        [][]byte
+	ce        bool
+	irq       *fakeIRQ
+	// response of the device to the transmitted packet, nil if it does not answer
+	reply func(payload []byte) []byte
+	// no ack, transmissions end with MAX_RT
+	maxRt bool
+	// transmissions never end
+	broken bool
+	// writes of these registers are ignored, like nRF24L01 does with 250kbps
+	fixed map[Register]bool
+	// the response is waiting for the chip to listen on the address
+	pending        []byte
+	pendingAddress []byte
+	sent           int
+}
+
+type fakeCE struct {
+	gpio.PinOut
+	chip *fakeChip
+}
+
+func (p *fakeCE) Out(l gpio.Level) error {
+	p.chip.mutex.Lock()
+	defer p.chip.mutex.Unlock()
+	p.chip.ce = bool(l)
+	p.chip.step()
+	return nil
+}
+
+type fakeIRQ struct {
+	gpio.PinIn
+	edges chan struct{}
+}
+
+func (p *fakeIRQ) In(pull gpio.Pull, edge gpio.Edge) error {
+	return nil
+}
+
+func (p *fakeIRQ) WaitForEdge(timeout time.Duration) bool {
+	select {
+	case <-p.edges:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func newFakeChip() *fakeChip {
+	return &fakeChip{
+		registers: map[Register][]byte{},
+		irq:       &fakeIRQ{edges: make(chan struct{}, 1)},
+	}
+}
+
+func (c *fakeChip) register(r Register) []byte {
+	if _, ok := c.registers[r]; !ok {
+		c.registers[r] = make([]byte, registerLengths[r])
+	}
+	return c.registers[r]
+}
+
+// raise sets the flag, IRQ falls only if no flag was set before
+func (c *fakeChip) raise(b Bit) {
+	if 0 == c.flags {
+		select {
+		case c.irq.edges <- struct{}{}:
+		default:
+		}
+	}
+	c.flags |= BV(b)
+}
+
+// step transmits TX FIFO in TX mode and receives the pending response in RX mode
+func (c *fakeChip) step() {
+	config := c.register(RConfig)[0]
+	if !c.ce || 0 == config&BV(BPwrUp) {
+		return
+	}
+	if 0 == config&BV(BPrimRx) {
+		if 0 == len(c.tx) || c.broken {
+			return
+		}
+		if c.maxRt {
+			c.raise(BMaxRt)
+			return
+		}
+		payload := c.tx[0]
+		c.tx = c.tx[1:]
+		c.sent++
+		if nil != c.reply {
+			c.pending = c.reply(payload)
+			c.pendingAddress = append([]byte{}, c.register(RTxAddr)...)
+		}
+		c.raise(BTxDs)
+		return
+	}
+	if nil != c.pending && bytes.Equal(c.pendingAddress, c.register(RRxAddrP0)) {
+		c.rx = append(c.rx, c.pending)
+		c.pending = nil
+		c.raise(BRxDr)
+	}
+}
+
+func (c *fakeChip) status() byte {
+	ret := c.flags | 7<<BRxPNo
+	if 0 != len(c.rx) {
+		ret = c.flags
+	}
+	return ret
+}
+
+func (c *fakeChip) Tx(w, r []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	r[0] = c.status()
+	command := Command(w[0])
+	switch {
+	case byte(CWriteRegister) > w[0]:
+		register := Register(w[0] & 0x1F)
+		if RFifoStatus == register {
+			r[1] = BV(BTxEmpty)
+			if 0 == len(c.rx) {
+				r[1] |= BV(BRxEmpty)
+			}
+		} else if RStatus == register {
+			r[1] = c.status()
+		} else {
+			copy(r[1:], c.register(register))
+		}
+	case byte(CReadRxPayloadWidth) > w[0]:
+		register := Register(w[0] & 0x1F)
+		if RStatus == register {
+			c.flags &^= w[1]
+		} else if !c.fixed[register] {
+			copy(c.register(register), w[1:])
+		}
+		c.step()
+	case CReadRxPayloadWidth == command:
+		if 0 != len(c.rx) {
+			r[1] = byte(len(c.rx[0]))
+		}
+	case CReadRxPayload == command:
+		if 0 != len(c.rx) {
+			copy(r[1:], c.rx[0])
+			c.rx = c.rx[1:]
+		}
+	case CWriteTxPayload == command:
+		c.tx = append(c.tx, append([]byte{}, w[1:]...))
+		c.step()
+	case CFlushTx == command:
+		c.tx = nil
+	case CFlushRx == command:
+		c.rx = nil
+	}
+	return nil
+}
+
+// receive puts the packet into RX FIFO as if it came from the air
+func (c *fakeChip) receive(payload []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.rx = append(c.rx, payload)
+	c.raise(BRxDr)
+}
+
+func initTestTransmitter(t *testing.T, chip *fakeChip, settings TransmitterSettings) *NRFTransmitter {
+	var rf NRFTransmitter
+	start(&rf, settings, chip, &fakeCE{chip: chip}, chip.irq)
+	t.Cleanup(rf.Close)
+	return &rf
+}
+
+func TestSendCommand(t *testing.T) {
+	chip := newFakeChip()
+	chip.reply = func(payload []byte) []byte {
+		return append([]byte{0xEE}, payload...)
+	}
+	rf := initTestTransmitter(t, chip, TransmitterSettings{})
+	for i := 0; i < 3; i++ {
+		m, err := rf.SendCommandContext(context.Background(), testAddress, TranscieverModel.Payload{1, byte(i)})
+		if nil != err || TranscieverModel.EMSDataPacket != m.Status || testAddress != m.Address || !bytes.Equal([]byte{0xEE, 1, byte(i)}, m.Payload) {
+			t.Errorf("transaction %v: %+v, error %v", i, m, err)
+		}
+	}
+	if 3 != chip.sent {
+		t.Errorf("%v packets are sent", chip.sent)
+	}
+	if chip.ce {
+		t.Errorf("transmitter listens after the transaction")
+	}
+}
+
+func TestSendCommandStale(t *testing.T) {
+	chip := newFakeChip()
+	chip.reply = func(payload []byte) []byte {
+		return []byte{0xEE}
+	}
+	rf := initTestTransmitter(t, chip, TransmitterSettings{})
+	// more packets, than the events take, nobody waits for them
+	for i := 0; i < 20; i++ {
+		chip.receive([]byte{0xDD, byte(i)})
+	}
+	time.Sleep(50 * time.Millisecond)
+	chip.receive([]byte{0xDD})
+	m, err := rf.SendCommandContext(context.Background(), testAddress, TranscieverModel.Payload{1})
+	if nil != err || !bytes.Equal([]byte{0xEE}, m.Payload) {
+		t.Errorf("stale packet is taken: %+v, error %v", m, err)
+	}
+}
+
+func TestSendCommandTimeouts(t *testing.T) {
+	chip := newFakeChip()
+	rf := initTestTransmitter(t, chip, TransmitterSettings{TxTimeout: 20 * time.Millisecond, ListenTimeout: 20 * time.Millisecond})
+	m, err := rf.SendCommandContext(context.Background(), testAddress, TranscieverModel.Payload{1})
+	if nil != err || TranscieverModel.EMSSlaveTimeout != m.Status || testAddress != m.Address {
+		t.Errorf("silent device: %+v, error %v", m, err)
+	}
+	chip.mutex.Lock()
+	chip.maxRt = true
+	chip.mutex.Unlock()
+	m, err = rf.SendCommandContext(context.Background(), testAddress, TranscieverModel.Payload{1})
+	if nil != err || TranscieverModel.EMSAckTimeout != m.Status {
+		t.Errorf("MAX_RT: %+v, error %v", m, err)
+	}
+	chip.mutex.Lock()
+	chip.maxRt, chip.broken = false, true
+	chip.mutex.Unlock()
+	if _, err = rf.SendCommandContext(context.Background(), testAddress, TranscieverModel.Payload{1}); nil == err {
+		t.Errorf("broken chip transmits")
+	}
+	chip.mutex.Lock()
+	chip.broken = false
+	chip.mutex.Unlock()
+	rf.listenTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err = rf.SendCommandContext(ctx, testAddress, TranscieverModel.Payload{1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled transaction: %v", err)
+	}
+}
+
+func TestPolling(t *testing.T) {
+	chip := newFakeChip()
+	chip.reply = func(payload []byte) []byte {
+		return append([]byte{0xEE}, payload...)
+	}
+	var rf NRFTransmitter
+	start(&rf, TransmitterSettings{PollPeriod: time.Millisecond}, chip, &fakeCE{chip: chip}, nil)
+	defer rf.Close()
+	chip.receive([]byte{0xDD})
+	time.Sleep(10 * time.Millisecond)
+	m, err := rf.SendCommandContext(context.Background(), testAddress, TranscieverModel.Payload{1})
+	if nil != err || TranscieverModel.EMSDataPacket != m.Status || !bytes.Equal([]byte{0xEE, 1}, m.Payload) {
+		t.Errorf("polled transaction: %+v, error %v", m, err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	chip := newFakeChip()
+	rf := initTestTransmitter(t, chip, TransmitterSettings{})
+	rf.Close()
+	select {
+	case <-rf.stopped:
+	default:
+		t.Errorf("IRQ handler is running")
+	}
+	if _, err := rf.SendCommandContext(context.Background(), testAddress, TranscieverModel.Payload{1}); nil == err {
+		t.Errorf("closed transmitter transmits")
+	}
+}
+
+func TestRadioSettings(t *testing.T) {
+	chip := newFakeChip()
+	radio := RadioSettings{Channel: 76, DataRate: DR250Kbps, Power: -6, CRCLength: 1, AutoAck: true, RetransmitDelay: time.Millisecond, RetransmitCount: 15}
+	rf := initTestTransmitter(t, chip, TransmitterSettings{Radio: radio})
+	chip.mutex.Lock()
+	expected := map[Register]byte{RConfig: 0x0B, REnAA: 0x01, RSetupRetr: 0x3F, RRFCh: 76, RRFSetup: 0x24}
+	for r, value := range expected {
+		if value != chip.register(r)[0] {
+			t.Errorf("register 0x%02X is 0x%02X, expected 0x%02X", byte(r), chip.register(r)[0], value)
+		}
+	}
+	chip.mutex.Unlock()
+	if 76 != rf.channel {
+		t.Errorf("channel is %v", rf.channel)
+	}
+	for _, bad := range []RadioSettings{
+		{Channel: 128, DataRate: DR1Mbps, CRCLength: 2, RetransmitDelay: time.Millisecond},
+		{DataRate: 3, CRCLength: 2, RetransmitDelay: time.Millisecond},
+		{DataRate: DR1Mbps, Power: -3, CRCLength: 2, RetransmitDelay: time.Millisecond},
+		{DataRate: DR1Mbps, AutoAck: true, RetransmitDelay: time.Millisecond},
+		{DataRate: DR1Mbps, CRCLength: 2, RetransmitDelay: 300 * time.Microsecond},
+		{DataRate: DR1Mbps, CRCLength: 2, RetransmitDelay: time.Millisecond, RetransmitCount: 16},
+	} {
+		if nil == bad.Validate() {
+			t.Errorf("%+v is valid", bad)
+		}
+	}
+	if rate, err := ParseDataRate("2Mbps"); nil != err || DR2Mbps != rate {
+		t.Errorf("2Mbps is %v, error %v", rate, err)
+	}
+}
+
+func TestRadioSettingsReadBack(t *testing.T) {
+	chip := newFakeChip()
+	chip.fixed = map[Register]bool{RRFSetup: true}
+	defer func() {
+		if r := recover(); nil == r {
+			t.Errorf("chip, which did not take the data rate, is started")
+		}
+	}()
+	var rf NRFTransmitter
+	radio := DefaultRadioSettings()
+	radio.DataRate = DR250Kbps
+	start(&rf, TransmitterSettings{Radio: radio}, chip, &fakeCE{chip: chip}, chip.irq)
+	rf.Close()
+}
+
+func TestRegisters(t *testing.T) {
+	chip := newFakeChip()
+	rf := initTestTransmitter(t, chip, TransmitterSettings{})
+	registers, err := rf.Registers()
+	if nil != err || len(registerLengths) != len(registers) {
+		t.Fatalf("%v registers, error %v", len(registers), err)
+	}
+	expected := map[Register]map[string]byte{
+		RConfig:     {"EN_CRC": 1, "CRCO": 1, "PWR_UP": 1, "PRIM_RX": 1, "MASK_RX_DR": 0},
+		RRFSetup:    {"RF_PWR": 3, "RF_DR_LOW": 0, "RF_DR_HIGH": 0},
+		RRFCh:       {"RF_CH": 2},
+		RSetupRetr:  {"ARD": 0, "ARC": 3},
+		RStatus:     {"RX_P_NO": 7},
+		RFifoStatus: {"RX_EMPTY": 1, "TX_EMPTY": 1},
+		RDynPd:      {"DPL_P0": 1, "DPL_P1": 1, "DPL_P2": 0},
+	}
+	for _, register := range registers {
+		for name, value := range expected[register.Register] {
+			if v, ok := register.Field(name); !ok || value != v {
+				t.Errorf("%v: %v is %v, expected %v", register, name, v, value)
+			}
+		}
+	}
+	if "CONFIG      (0x00) = 0F: MASK_RX_DR=0 MASK_TX_DS=0 MASK_MAX_RT=0 EN_CRC=1 CRCO=1 PWR_UP=1 PRIM_RX=1" != registers[0].String() {
+		t.Errorf("CONFIG is <%v>", registers[0])
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	chip := newFakeChip()
+	chip.fixed = map[Register]bool{RTxAddr: true}
+	defer func() {
+		if r := recover(); nil == r || !strings.Contains(fmt.Sprint(r), "self-test failed") {
+			t.Errorf("missing chip is started: %v", r)
+		}
+	}()
+	var rf NRFTransmitter
+	start(&rf, TransmitterSettings{}, chip, &fakeCE{chip: chip}, chip.irq)
+	rf.Close()
+}
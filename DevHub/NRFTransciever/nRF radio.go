@@ -0,0 +1,181 @@
+package NRFTransciever
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"../TranscieverModel"
+)
+
+// DataRate of the air, devices have to use the same one
+type DataRate byte
+
+const (
+	DR1Mbps DataRate = iota
+	DR2Mbps
+	DR250Kbps
+)
+
+var dataRateNames = map[DataRate]string{
+	DR250Kbps: "250kbps",
+	DR1Mbps:   "1mbps",
+	DR2Mbps:   "2mbps",
+}
+
+func (r DataRate) String() string {
+	if name, ok := dataRateNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("DataRate(%v)", byte(r))
+}
+
+// ParseDataRate takes 250kbps, 1mbps and 2mbps
+func ParseDataRate(s string) (DataRate, error) {
+	for rate, name := range dataRateNames {
+		if strings.EqualFold(name, strings.TrimSpace(s)) {
+			return rate, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown data rate <%v>, it is one of 250kbps, 1mbps, 2mbps", s)
+}
+
+// power levels in dBm by RF_PWR
+var powerLevels = []int{-18, -12, -6, 0}
+
+// RadioSettings of the chip, they are written by initNRF and checked by reading them back
+type RadioSettings struct {
+	Channel  byte
+	DataRate DataRate
+	// dBm, one of -18, -12, -6, 0
+	Power int
+	// bytes, 0 disables CRC, auto ack needs it
+	CRCLength byte
+	AutoAck   bool
+	// auto retransmit of the packet, which is not acked, 250us..4ms by 250us
+	RetransmitDelay time.Duration
+	// 0..15, 0 disables retransmits
+	RetransmitCount byte
+}
+
+// DefaultRadioSettings are the ones the hub always used: 1mbps, max power, 2 bytes CRC and no acks
+func DefaultRadioSettings() RadioSettings {
+	return RadioSettings{
+		Channel:         TranscieverModel.DefaultRFChannel,
+		DataRate:        DR1Mbps,
+		Power:           0,
+		CRCLength:       2,
+		AutoAck:         false,
+		RetransmitDelay: 250 * time.Microsecond,
+		RetransmitCount: 3,
+	}
+}
+
+func (s RadioSettings) String() string {
+	ack := "off"
+	if s.AutoAck {
+		ack = fmt.Sprintf("%v retransmits in %v", s.RetransmitCount, s.RetransmitDelay)
+	}
+	return fmt.Sprintf("channel %v, %v, %vdBm, CRC %v bytes, auto ack %v", s.Channel, s.DataRate, s.Power, s.CRCLength, ack)
+}
+
+// Validate returns all problems of the settings
+func (s RadioSettings) Validate() error {
+	var problems []string
+	if !ValidateRfChannel(s.Channel) {
+		problems = append(problems, fmt.Sprintf("channel %v is not in 0..127", s.Channel))
+	}
+	if _, ok := dataRateNames[s.DataRate]; !ok {
+		problems = append(problems, fmt.Sprintf("unknown data rate %v", s.DataRate))
+	}
+	if _, ok := powerBits(s.Power); !ok {
+		problems = append(problems, fmt.Sprintf("power %vdBm is not one of %v", s.Power, powerLevels))
+	}
+	if 2 < s.CRCLength {
+		problems = append(problems, fmt.Sprintf("CRC length %v is not one of 0, 1, 2", s.CRCLength))
+	}
+	if s.AutoAck && 0 == s.CRCLength {
+		problems = append(problems, "auto ack needs CRC")
+	}
+	if s.RetransmitDelay < 250*time.Microsecond || s.RetransmitDelay > 4000*time.Microsecond || 0 != s.RetransmitDelay%(250*time.Microsecond) {
+		problems = append(problems, fmt.Sprintf("retransmit delay %v is not 250us..4ms by 250us", s.RetransmitDelay))
+	}
+	if 15 < s.RetransmitCount {
+		problems = append(problems, fmt.Sprintf("retransmit count %v is not in 0..15", s.RetransmitCount))
+	}
+	if 0 != len(problems) {
+		return fmt.Errorf("bad nRF radio settings: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func powerBits(dBm int) (byte, bool) {
+	for i, level := range powerLevels {
+		if level == dBm {
+			return byte(i), true
+		}
+	}
+	return 0, false
+}
+
+// registers of the settings, PWR_UP and PRIM_RX of CONFIG are not the settings
+func (s RadioSettings) registers() map[Register]byte {
+	config := byte(0)
+	if 0 != s.CRCLength {
+		config |= BV(BEnCrc)
+	}
+	if 2 == s.CRCLength {
+		config |= BV(BCrcO)
+	}
+	enAA := byte(0)
+	if s.AutoAck {
+		enAA = BV(BEnAAP0)
+	}
+	power, _ := powerBits(s.Power)
+	rfSetup := power << byte(BRfPwr)
+	switch s.DataRate {
+	case DR2Mbps:
+		rfSetup |= BV(BRfDrHigh)
+	case DR250Kbps:
+		rfSetup |= BV(BRfDrLow)
+	}
+	return map[Register]byte{
+		RConfig:    config,
+		REnAA:      enAA,
+		RSetupRetr: byte(s.RetransmitDelay/(250*time.Microsecond)-1)<<byte(BARD) | s.RetransmitCount<<byte(BARC),
+		RRFCh:      s.Channel,
+		RRFSetup:   rfSetup,
+	}
+}
+
+// settingsMasks are the bits of the registers, which are settings
+var settingsMasks = map[Register]byte{
+	RConfig:    BV(BEnCrc) | BV(BCrcO),
+	REnAA:      0x3F,
+	RSetupRetr: 0xFF,
+	RRFCh:      0x7F,
+	RRFSetup:   BV(BRfDrLow) | BV(BRfDrHigh) | 0x03<<byte(BRfPwr),
+}
+
+// applyRadio writes the settings and reads them back, nRF24L01 without + does not take 250kbps for example
+func applyRadio(rf *NRFTransmitter, s RadioSettings) {
+	if err := s.Validate(); nil != err {
+		panic(err)
+	}
+	values := s.registers()
+	writeByteRegister(rf, RConfig, values[RConfig]|BV(BPwrUp)|BV(BPrimRx))
+	for _, r := range []Register{REnAA, RSetupRetr, RRFCh, RRFSetup} {
+		writeByteRegister(rf, r, values[r])
+	}
+	var mismatches []string
+	for _, r := range []Register{RConfig, REnAA, RSetupRetr, RRFCh, RRFSetup} {
+		if read := readRegister(rf, r)[0] & settingsMasks[r]; read != values[r] {
+			mismatches = append(mismatches, fmt.Sprintf("register 0x%02X is 0x%02X, written 0x%02X", byte(r), read, values[r]))
+		}
+	}
+	if 0 != len(mismatches) {
+		panic(fmt.Errorf("nRF chip did not accept %v: %v", s, strings.Join(mismatches, "; ")))
+	}
+	rf.channel = s.Channel
+	log.Info(fmt.Sprintf("nRF radio: %v", s))
+}
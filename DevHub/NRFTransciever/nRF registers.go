@@ -0,0 +1,168 @@
+package NRFTransciever
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Field of the register, Value is shifted to the lowest bit
+type Field struct {
+	Name  string
+	Value byte
+}
+
+// RegisterDump is the register with its bit fields, address and payload width registers are just Value
+type RegisterDump struct {
+	Register Register
+	Name     string
+	Value    []byte
+	Fields   []Field
+}
+
+// Field returns the value of the named field
+func (d RegisterDump) Field(name string) (byte, bool) {
+	for _, f := range d.Fields {
+		if name == f.Name {
+			return f.Value, true
+		}
+	}
+	return 0, false
+}
+
+func (d RegisterDump) String() string {
+	ret := fmt.Sprintf("%-11v (0x%02X) = % X", d.Name, byte(d.Register), d.Value)
+	if 0 != len(d.Fields) {
+		fields := make([]string, len(d.Fields))
+		for i, f := range d.Fields {
+			fields[i] = fmt.Sprintf("%v=%v", f.Name, f.Value)
+		}
+		ret += ": " + strings.Join(fields, " ")
+	}
+	return ret
+}
+
+// fieldBits is the field of width bits from the bit
+type fieldBits struct {
+	name  string
+	bit   Bit
+	width byte
+}
+
+func flag(name string, bit Bit) fieldBits {
+	return fieldBits{name: name, bit: bit, width: 1}
+}
+
+// pipeFlags are the flags of pipes 5..0 named by the prefix
+func pipeFlags(prefix string) []fieldBits {
+	ret := make([]fieldBits, 0, 6)
+	for pipe := 5; 0 <= pipe; pipe-- {
+		ret = append(ret, flag(fmt.Sprintf("%vP%v", prefix, pipe), Bit(pipe)))
+	}
+	return ret
+}
+
+// registerNames are the datasheet names
+var registerNames = map[Register]string{
+	RConfig:     "CONFIG",
+	REnAA:       "EN_AA",
+	REnRxAddr:   "EN_RXADDR",
+	RSetupAW:    "SETUP_AW",
+	RSetupRetr:  "SETUP_RETR",
+	RRFCh:       "RF_CH",
+	RRFSetup:    "RF_SETUP",
+	RStatus:     "STATUS",
+	RObserveTx:  "OBSERVE_TX",
+	RRPD:        "RPD",
+	RRxAddrP0:   "RX_ADDR_P0",
+	RRxAddrP1:   "RX_ADDR_P1",
+	RRxAddrP2:   "RX_ADDR_P2",
+	RRxAddrP3:   "RX_ADDR_P3",
+	RRxAddrP4:   "RX_ADDR_P4",
+	RRxAddrP5:   "RX_ADDR_P5",
+	RTxAddr:     "TX_ADDR",
+	RRxPwP0:     "RX_PW_P0",
+	RRxPwP1:     "RX_PW_P1",
+	RRxPwP2:     "RX_PW_P2",
+	RRxPwP3:     "RX_PW_P3",
+	RRxPwP4:     "RX_PW_P4",
+	RRxPwP5:     "RX_PW_P5",
+	RFifoStatus: "FIFO_STATUS",
+	RDynPd:      "DYNPD",
+	RFeature:    "FEATURE",
+}
+
+var registerFields = map[Register][]fieldBits{
+	RConfig: {
+		flag("MASK_RX_DR", BMaskRxDr), flag("MASK_TX_DS", BMaskTxDs), flag("MASK_MAX_RT", BMaskMaxRt),
+		flag("EN_CRC", BEnCrc), flag("CRCO", BCrcO), flag("PWR_UP", BPwrUp), flag("PRIM_RX", BPrimRx),
+	},
+	REnAA:      pipeFlags("ENAA_"),
+	REnRxAddr:  pipeFlags("ERX_"),
+	RSetupAW:   {{name: "AW", bit: BAW, width: 2}},
+	RSetupRetr: {{name: "ARD", bit: BARD, width: 4}, {name: "ARC", bit: BARC, width: 4}},
+	RRFCh:      {{name: "RF_CH", bit: 0, width: 7}},
+	RRFSetup: {
+		flag("CONT_WAVE", BContWave), flag("RF_DR_LOW", BRfDrLow), flag("PLL_LOCK", BPllLock),
+		flag("RF_DR_HIGH", BRfDrHigh), {name: "RF_PWR", bit: BRfPwr, width: 2},
+	},
+	RStatus: {
+		flag("RX_DR", BRxDr), flag("TX_DS", BTxDs), flag("MAX_RT", BMaxRt),
+		{name: "RX_P_NO", bit: BRxPNo, width: 3}, flag("TX_FULL", BStatusTxFull),
+	},
+	RObserveTx: {{name: "PLOS_CNT", bit: BPLosCnt, width: 4}, {name: "ARC_CNT", bit: BArcCnt, width: 4}},
+	RRPD:       {flag("RPD", 0)},
+	RFifoStatus: {
+		flag("TX_REUSE", BTxReuse), flag("TX_FULL", BFifoTxFull), flag("TX_EMPTY", BTxEmpty),
+		flag("RX_FULL", BRxFull), flag("RX_EMPTY", BRxEmpty),
+	},
+	RDynPd:   pipeFlags("DPL_"),
+	RFeature: {flag("EN_DPL", BEnDpl), flag("EN_ACK_PAY", BEnAckPay), flag("EN_DYN_ACK", BEnDynAck)},
+}
+
+func decodeRegister(r Register, value []byte) RegisterDump {
+	ret := RegisterDump{Register: r, Name: registerNames[r], Value: value}
+	for _, f := range registerFields[r] {
+		ret.Fields = append(ret.Fields, Field{Name: f.name, Value: value[0] >> byte(f.bit) & (1<<f.width - 1)})
+	}
+	return ret
+}
+
+// Registers reads all registers and decodes their fields, it is for debugging like ModemStatusRegisters of UART modem
+func (rf *NRFTransmitter) Registers() (ret []RegisterDump, err error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	defer func() {
+		// spi failures
+		if r := recover(); nil != r {
+			ret, err = nil, fmt.Errorf("nRFModel.Registers: %v", r)
+		}
+	}()
+	return readRegisters(rf), nil
+}
+
+func readRegisters(rf *NRFTransmitter) []RegisterDump {
+	registers := make([]Register, 0, len(registerLengths))
+	for r := range registerLengths {
+		registers = append(registers, r)
+	}
+	sort.Slice(registers, func(i, j int) bool { return registers[i] < registers[j] })
+	ret := make([]RegisterDump, len(registers))
+	for i, r := range registers {
+		ret[i] = decodeRegister(r, readRegister(rf, r))
+	}
+	return ret
+}
+
+// selfTest writes patterns to TX_ADDR and reads them back
+// missing module or miswired SPI reads all zeros or ones instead of failing transactions later with timeouts
+func selfTest(rf *NRFTransmitter) {
+	for _, pattern := range [][]byte{{0xA5, 0x5A, 0xC3, 0x3C, 0x01}, {0x5A, 0xA5, 0x3C, 0xC3, 0xFE}} {
+		writeRegister(rf, RTxAddr, pattern)
+		if read := readRegister(rf, RTxAddr); !bytes.Equal(pattern, read) {
+			panic(fmt.Errorf("nRF self-test failed: TX_ADDR is % X after writing % X, "+
+				"the module is missing, not powered or its SPI (MOSI, MISO, SCK, CSN) is miswired", read, pattern))
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package Opcua
+
+import (
+	"strings"
+
+	"../OutsideInterface"
+)
+
+// componentPath is Device/Unit/Function names of the component
+// if devices.json names are unknown, they are taken from the key ("device address:unit|function")
+func componentPath(key string, info OutsideInterface.ComponentInfo) []string {
+	if "" != info.DeviceName && "" != info.UnitName && "" != info.FunctionName {
+		return []string{info.DeviceName, info.UnitName, info.FunctionName}
+	}
+	separator := strings.LastIndex(key, "|")
+	if 0 > separator {
+		return []string{key}
+	}
+	unitPart, function := key[:separator], key[separator+1:]
+	if separator := strings.LastIndex(unitPart, ":"); 0 <= separator {
+		return []string{unitPart[:separator], unitPart[separator+1:], function}
+	}
+	return []string{unitPart, function}
+}
+
+// joinPath makes string node id from the path
+func joinPath(path []string) string {
+	return strings.Join(path, "/")
+}
@@ -0,0 +1,41 @@
+package Opcua
+
+import (
+	"reflect"
+	"testing"
+
+	"../OutsideInterface"
+)
+
+func Test_componentPath(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		info OutsideInterface.ComponentInfo
+		want []string
+	}{
+		{
+			name: "devices.json names",
+			key:  "AA:AA:AA:AA:01:01|10",
+			info: OutsideInterface.ComponentInfo{DeviceName: "actuator", UnitName: "unit 1", FunctionName: "Out 1"},
+			want: []string{"actuator", "unit 1", "Out 1"},
+		},
+		{
+			name: "no names",
+			key:  "AA:AA:AA:AA:01:01|10",
+			want: []string{"AA:AA:AA:AA:01", "01", "10"},
+		},
+		{
+			name: "unexpected key",
+			key:  "something",
+			want: []string{"something"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := componentPath(tt.key, tt.info); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("componentPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
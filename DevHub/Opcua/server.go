@@ -1,5 +1,226 @@
+// Opcua exposes components as OPC UA variables in Device/Unit/Function address space
+// read and write keys of the same devices.json function share one variable node:
+// it shows the read value and client writes to it go to the write key
 package Opcua
 
-import "github.com/gopcua/opcua"
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
 
+	"../OutsideInterface"
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/server"
+	"github.com/gopcua/opcua/server/attrs"
+	"github.com/gopcua/opcua/ua"
+	"github.com/sirupsen/logrus"
+)
 
+var log = logrus.New()
+
+// NamespaceName of the hub components
+const NamespaceName = "DevHub"
+
+type Interface struct {
+	srv        *server.Server
+	ns         *server.NodeNameSpace
+	mutex      sync.Mutex
+	folders    map[string]*server.Node
+	components map[string]*component
+	// node id to the component which owns writes to it
+	writable map[string]*component
+	// by path, components without keys, their nodes are taken again for the same path
+	removed map[string]*component
+}
+
+type component struct {
+	node     *server.Node
+	path     string
+	writeKey string
+	channel  chan OutsideInterface.SubMessage
+	// closed when the write key is unregistered, nobody reads the channel after that
+	done chan struct{}
+}
+
+func Init(self *Interface, host string, port int) {
+	log.Formatter = new(logrus.TextFormatter)
+	log.Level = logrus.InfoLevel
+	log.Out = os.Stdout
+	self.folders = make(map[string]*server.Node)
+	self.components = make(map[string]*component)
+	self.writable = make(map[string]*component)
+	self.removed = make(map[string]*component)
+	self.srv = server.New(
+		server.EndPoint(host, port),
+		server.EnableSecurity("None", ua.MessageSecurityModeNone),
+		server.EnableAuthMode(ua.UserTokenTypeAnonymous),
+	)
+	self.ns = server.NewNodeNameSpace(self.srv, NamespaceName)
+	root, err := self.srv.Namespace(0)
+	if nil != err {
+		panic(fmt.Errorf("Opcua.Init: srv.Namespace(0): %v; ", err))
+	}
+	root.Objects().AddRef(self.ns.Objects(), id.HasComponent, true)
+	if err := self.srv.Start(context.Background()); nil != err {
+		panic(fmt.Errorf("Opcua.Init: srv.Start(%v:%v): %v; ", host, port, err))
+	}
+	log.Info(fmt.Sprintf("Opcua.Init: listening on %v:%v", host, port))
+	go self.writeLoop()
+}
+
+// Close stops the server
+func (i *Interface) Close() {
+	_ = i.srv.Close()
+}
+
+// DescribeComponent creates variable node for the key, devices.json names make the path
+func (i *Interface) DescribeComponent(key string, info OutsideInterface.ComponentInfo) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.ensureComponent(key, componentPath(key, info), info.Writeable)
+}
+
+func (i *Interface) UpdateComponent(key string, value string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	c := i.ensureComponent(key, componentPath(key, OutsideInterface.ComponentInfo{}), false)
+	if err := c.node.SetAttribute(ua.AttributeIDValue, server.DataValueFromValue(value)); nil != err {
+		log.Warning(fmt.Sprintf("Opcua.UpdateComponent(%s, %s): SetAttribute: %v", key, value, err))
+		return
+	}
+	i.ns.ChangeNotification(c.node.ID())
+}
+
+func (i *Interface) RegisterWritableComponent(key string) <-chan OutsideInterface.SubMessage {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	c := i.ensureComponent(key, componentPath(key, OutsideInterface.ComponentInfo{}), true)
+	c.writeKey = key
+	c.channel = make(chan OutsideInterface.SubMessage, 2)
+	c.done = make(chan struct{})
+	i.writable[c.node.ID().String()] = c
+	return c.channel
+}
+
+// UnregisterComponent forgets the key, writes to its node are not passed anymore
+// gopcua server does not remove nodes, so the node without keys is emptied and made not accessible
+// until the same devices.json function is registered again
+func (i *Interface) UnregisterComponent(key string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	c, ok := i.components[key]
+	if !ok {
+		return
+	}
+	delete(i.components, key)
+	if key == c.writeKey {
+		close(c.done)
+		delete(i.writable, c.node.ID().String())
+		c.writeKey, c.channel, c.done = "", nil, nil
+		setAccessLevel(c.node, ua.AccessLevelTypeCurrentRead)
+	}
+	for _, other := range i.components {
+		if c == other {
+			return
+		}
+	}
+	setAccessLevel(c.node, 0)
+	_ = c.node.SetAttribute(ua.AttributeIDValue, server.DataValueFromValue(""))
+	i.ns.ChangeNotification(c.node.ID())
+	i.removed[c.path] = c
+}
+
+func setAccessLevel(node *server.Node, level ua.AccessLevelType) {
+	access := server.DataValueFromValue(byte(level))
+	_ = node.SetAttribute(ua.AttributeIDAccessLevel, access)
+	_ = node.SetAttribute(ua.AttributeIDUserAccessLevel, access)
+}
+
+// ensureComponent finds or creates the variable node and its folders, mutex should be locked
+func (i *Interface) ensureComponent(key string, path []string, writeable bool) *component {
+	c, ok := i.components[key]
+	if !ok {
+		nodePath := joinPath(path)
+		// read and write keys of the same function end up here with the same path
+		for _, other := range i.components {
+			if nodePath == other.path {
+				c = other
+				break
+			}
+		}
+		if removed, ok := i.removed[nodePath]; nil == c && ok {
+			delete(i.removed, nodePath)
+			setAccessLevel(removed.node, ua.AccessLevelTypeCurrentRead)
+			c = removed
+		}
+		if nil == c {
+			c = &component{
+				node: server.NewNode(
+					ua.NewStringNodeID(i.ns.ID(), nodePath),
+					map[ua.AttributeID]*ua.DataValue{
+						ua.AttributeIDNodeClass:       server.DataValueFromValue(uint32(ua.NodeClassVariable)),
+						ua.AttributeIDBrowseName:      server.DataValueFromValue(attrs.BrowseName(path[len(path)-1])),
+						ua.AttributeIDDisplayName:     server.DataValueFromValue(attrs.DisplayName(path[len(path)-1], "")),
+						ua.AttributeIDDescription:     server.DataValueFromValue(attrs.DisplayName(key, "")),
+						ua.AttributeIDDataType:        server.DataValueFromValue(ua.NewNumericExpandedNodeID(0, id.String)),
+						ua.AttributeIDAccessLevel:     server.DataValueFromValue(byte(ua.AccessLevelTypeCurrentRead)),
+						ua.AttributeIDUserAccessLevel: server.DataValueFromValue(byte(ua.AccessLevelTypeCurrentRead)),
+						ua.AttributeIDValue:           server.DataValueFromValue(""),
+					},
+					nil,
+					nil,
+				),
+				path: nodePath,
+			}
+			i.ns.AddNode(c.node)
+			i.ensureFolder(path[:len(path)-1]).AddRef(c.node, id.HasComponent, true)
+		}
+		i.components[key] = c
+	}
+	if writeable {
+		setAccessLevel(c.node, ua.AccessLevelTypeCurrentRead|ua.AccessLevelTypeCurrentWrite)
+	}
+	return c
+}
+
+func (i *Interface) ensureFolder(path []string) *server.Node {
+	if 0 == len(path) {
+		return i.ns.Objects()
+	}
+	folderPath := joinPath(path)
+	if folder, ok := i.folders[folderPath]; ok {
+		return folder
+	}
+	folder := server.NewFolderNode(ua.NewStringNodeID(i.ns.ID(), folderPath), path[len(path)-1])
+	i.ns.AddNode(folder)
+	i.ensureFolder(path[:len(path)-1]).AddRef(folder, id.Organizes, true)
+	i.folders[folderPath] = folder
+	return folder
+}
+
+// writeLoop passes values written by clients into the writable component channels
+// the write, which is not taken by the time the key is unregistered, is dropped
+func (i *Interface) writeLoop() {
+	for nodeID := range i.ns.ExternalNotification {
+		i.mutex.Lock()
+		c, ok := i.writable[nodeID.String()]
+		var key string
+		var channel chan OutsideInterface.SubMessage
+		var done chan struct{}
+		if ok {
+			key, channel, done = c.writeKey, c.channel, c.done
+		}
+		i.mutex.Unlock()
+		if !ok {
+			log.Warning(fmt.Sprintf("Opcua.writeLoop: write to not writable node %v", nodeID))
+			continue
+		}
+		value := fmt.Sprintf("%v", c.node.Value().Value.Value())
+		log.Debug(fmt.Sprintf("Opcua.writeLoop: node %v, key %s, value <%s>", nodeID, key, value))
+		select {
+		case channel <- OutsideInterface.SubMessage{Value: value, Key: key}:
+		case <-done:
+		}
+	}
+}
@@ -3,9 +3,85 @@ package OutsideInterface
 type SubMessage struct {
 	Value string
 	Key   string
+	ID    string // commands only, passed back to CommandResult
 }
 
 type Interface interface {
 	UpdateComponent(key string, value string)
 	RegisterWritableComponent(key string) <-chan SubMessage
 }
+
+// ComponentInfo is what devices.json knows about the component
+type ComponentInfo struct {
+	DeviceAddress string
+	DeviceName    string
+	UnitName      string
+	FunctionName  string
+	Readable      bool
+	Writeable     bool
+	Command       bool // writes are queued and delivered once each, see Commander
+	// RFModel.DataTypeNames name, empty until it is known from devices.json or the device itself
+	DataType string
+}
+
+// Describer is optional for interfaces, which need human-readable names and not only the keys
+// DescribeComponent is called for every key before it is updated or registered as writable
+// and once again when its data type becomes known
+type Describer interface {
+	DescribeComponent(key string, info ComponentInfo)
+}
+
+// Device states for DeviceStateListener
+const (
+	DSOnline  = "online"
+	DSOffline = "offline"
+	DSError   = "error"
+)
+
+// DeviceStateListener is optional for interfaces, which need device availability
+// UpdateDeviceState is called on every device state change, device is its address
+type DeviceStateListener interface {
+	UpdateDeviceState(device string, state string)
+}
+
+// Command results for Commander
+const (
+	CRDelivered = "delivered"
+	CRFailed    = "failed"
+)
+
+// Commander is optional for interfaces, which have commands: events to be delivered each, unlike values of writes
+// RegisterCommandComponent is called instead of RegisterWritableComponent for devices.json functions with "command",
+// every message of the channel is queued and delivered once in order, CommandResult is called for each of them
+// reason is empty for delivered commands
+type Commander interface {
+	RegisterCommandComponent(key string) <-chan SubMessage
+	CommandResult(key string, id string, result string, reason string)
+}
+
+// Write states for WriteStateListener
+const (
+	WSPending = "pending"
+	WSWritten = "written"
+	WSFailed  = "failed"
+)
+
+// WriteStateListener is optional for interfaces, which need to know if their writes reached the device
+// UpdateWriteState is called on every write state change of the writable key, reason is the error of the failed one
+type WriteStateListener interface {
+	UpdateWriteState(key string, state string, reason string)
+}
+
+// ComponentErrorListener is optional for interfaces, which keep read errors apart from the values
+// UpdateComponentError is called instead of UpdateComponent when the read fails
+type ComponentErrorListener interface {
+	UpdateComponentError(key string, reason string)
+}
+
+// Unregisterer is optional for interfaces, which keep something of the components
+// UnregisterComponent is called when the key is removed from devices.json on reload,
+// or before it is registered once again, when its function becomes command or stops being one
+// the channel of the key is not read anymore after that
+type Unregisterer interface {
+	UnregisterComponent(key string)
+}
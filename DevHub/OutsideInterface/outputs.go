@@ -0,0 +1,106 @@
+package OutsideInterface
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Outputs passes components to several interfaces at once
+// optional interfaces are passed to those of them, which implement them
+type Outputs []Interface
+
+func (o Outputs) UpdateComponent(key string, value string) {
+	for _, output := range o {
+		output.UpdateComponent(key, value)
+	}
+}
+
+// RegisterWritableComponent merges writes of all the interfaces
+func (o Outputs) RegisterWritableComponent(key string) <-chan SubMessage {
+	ret := make(chan SubMessage, 2)
+	for _, output := range o {
+		go forward(output.RegisterWritableComponent(key), ret, "")
+	}
+	return ret
+}
+
+// RegisterCommandComponent merges commands of the interfaces, which have them
+// writes of the others are taken as commands without ids, as Cache does for an interface without commands
+// command ids are prefixed with the interface index to get CommandResult back to it
+func (o Outputs) RegisterCommandComponent(key string) <-chan SubMessage {
+	ret := make(chan SubMessage, 2)
+	for index, output := range o {
+		if commander, ok := output.(Commander); ok {
+			go forward(commander.RegisterCommandComponent(key), ret, strconv.Itoa(index)+"/")
+		} else {
+			go forward(output.RegisterWritableComponent(key), ret, "")
+		}
+	}
+	return ret
+}
+
+func (o Outputs) CommandResult(key string, id string, result string, reason string) {
+	separator := strings.Index(id, "/")
+	if 0 > separator {
+		return
+	}
+	index, err := strconv.Atoi(id[:separator])
+	if nil != err || 0 > index || len(o) <= index {
+		return
+	}
+	if commander, ok := o[index].(Commander); ok {
+		commander.CommandResult(key, id[separator+1:], result, reason)
+	}
+}
+
+func (o Outputs) DescribeComponent(key string, info ComponentInfo) {
+	for _, output := range o {
+		if describer, ok := output.(Describer); ok {
+			describer.DescribeComponent(key, info)
+		}
+	}
+}
+
+func (o Outputs) UpdateDeviceState(device string, state string) {
+	for _, output := range o {
+		if listener, ok := output.(DeviceStateListener); ok {
+			listener.UpdateDeviceState(device, state)
+		}
+	}
+}
+
+func (o Outputs) UpdateWriteState(key string, state string, reason string) {
+	for _, output := range o {
+		if listener, ok := output.(WriteStateListener); ok {
+			listener.UpdateWriteState(key, state, reason)
+		}
+	}
+}
+
+// UpdateComponentError gives the error text as the value to the interfaces, which do not keep errors apart
+func (o Outputs) UpdateComponentError(key string, reason string) {
+	for _, output := range o {
+		if listener, ok := output.(ComponentErrorListener); ok {
+			listener.UpdateComponentError(key, reason)
+		} else {
+			output.UpdateComponent(key, reason)
+		}
+	}
+}
+
+func (o Outputs) UnregisterComponent(key string) {
+	for _, output := range o {
+		if unregisterer, ok := output.(Unregisterer); ok {
+			unregisterer.UnregisterComponent(key)
+		}
+	}
+}
+
+func forward(from <-chan SubMessage, to chan<- SubMessage, idPrefix string) {
+	for m := range from {
+		if "" != m.ID {
+			m.ID = idPrefix + m.ID
+		}
+		to <- m
+	}
+}
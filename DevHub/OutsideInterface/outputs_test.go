@@ -0,0 +1,68 @@
+package OutsideInterface
+
+import "testing"
+
+type fakeOutput struct {
+	values  map[string]string
+	channel chan SubMessage // commands
+	writes  map[string]chan SubMessage
+	results []string
+}
+
+func (o *fakeOutput) UpdateComponent(key string, value string) { o.values[key] = value }
+func (o *fakeOutput) RegisterWritableComponent(key string) <-chan SubMessage {
+	return o.writable(key)
+}
+
+func (o *fakeOutput) writable(key string) chan SubMessage {
+	if nil == o.writes {
+		o.writes = map[string]chan SubMessage{}
+	}
+	if _, ok := o.writes[key]; !ok {
+		o.writes[key] = make(chan SubMessage, 1)
+	}
+	return o.writes[key]
+}
+
+type fakeCommander struct{ fakeOutput }
+
+func (o *fakeCommander) RegisterCommandComponent(key string) <-chan SubMessage {
+	return o.channel
+}
+func (o *fakeCommander) CommandResult(key string, id string, result string, reason string) {
+	o.results = append(o.results, id)
+}
+
+func TestOutputs(t *testing.T) {
+	plain := &fakeOutput{values: map[string]string{}, channel: make(chan SubMessage, 1)}
+	commander := &fakeCommander{fakeOutput{values: map[string]string{}, channel: make(chan SubMessage, 1)}}
+	outputs := Outputs{plain, commander}
+	outputs.UpdateComponentError("key", "timeout")
+	if "timeout" != plain.values["key"] {
+		t.Errorf("plain interface value is %v", plain.values["key"])
+	}
+	channel := outputs.RegisterCommandComponent("key")
+	commander.channel <- SubMessage{Key: "key", Value: "1", ID: "7"}
+	m := <-channel
+	if "1" != m.Value || "1/7" != m.ID {
+		t.Errorf("message is %v", m)
+	}
+	writes := outputs.RegisterWritableComponent("other key")
+	plain.writable("other key") <- SubMessage{Key: "other key", Value: "2"}
+	if m := <-writes; "2" != m.Value {
+		t.Errorf("write is %v", m)
+	}
+	outputs.CommandResult("key", m.ID, CRDelivered, "")
+	if 1 != len(commander.results) || "7" != commander.results[0] {
+		t.Errorf("command results are %v", commander.results)
+	}
+}
+
+func TestOutputsCommandsOfPlainOutput(t *testing.T) {
+	plain := &fakeOutput{values: map[string]string{}}
+	channel := Outputs{plain}.RegisterCommandComponent("key")
+	plain.writable("key") <- SubMessage{Key: "key", Value: "1"}
+	if m := <-channel; "1" != m.Value || "" != m.ID {
+		t.Errorf("write of the plain interface is %v", m)
+	}
+}
@@ -0,0 +1,168 @@
+// RFControl executes single function calls from the shell for rfctl,
+// either with the transmitter of its own or through the socket of the running hub, which holds the transmitter
+package RFControl
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"../RFModel"
+	"../TranscieverModel"
+)
+
+// Commands
+const (
+	CRead     = "read"
+	CWrite    = "write"
+	CRaw      = "raw"
+	CDescribe = "describe"
+)
+
+// Request is a single rfctl command, unit and function are not used by CDescribe
+type Request struct {
+	Command  string `json:"command"`
+	Address  string `json:"address"`
+	Unit     byte   `json:"unit"`
+	Function byte   `json:"function"`
+	// CWrite value in the format Cache uses, hex for byte arrays; CRaw payload in hex
+	Value string `json:"value,omitempty"`
+}
+
+// Execute performs the request with the model and returns the text to print
+// errors of bad response codes are named by their ERC constants
+func Execute(ctx context.Context, rf *RFModel.RFModel, r Request) (string, error) {
+	address, err := RFModel.TryParseAddress(r.Address)
+	if nil != err {
+		return "", err
+	}
+	uid := RFModel.UID{Address: address, Unit: r.Unit}
+	fno := RFModel.FuncNo(r.Function)
+	var ret string
+	switch r.Command {
+	case CRead:
+		ret, err = read(ctx, rf, uid, fno)
+	case CWrite:
+		ret, err = write(ctx, rf, uid, fno, r.Value)
+	case CRaw:
+		ret, err = raw(ctx, rf, uid, fno, r.Value)
+	case CDescribe:
+		ret, err = describe(ctx, rf, address)
+	default:
+		err = fmt.Errorf("RFControl.Execute: unknown command <%v>; ", r.Command)
+	}
+	return ret, explain(err)
+}
+
+// explain names the response code of EBadCode errors
+func explain(err error) error {
+	var callError *RFModel.CallError
+	if errors.Is(err, RFModel.ErrBadCode) && errors.As(err, &callError) {
+		return fmt.Errorf("device answered %v: %v", callError.Code, err)
+	}
+	return err
+}
+
+func read(ctx context.Context, rf *RFModel.RFModel, uid RFModel.UID, fno RFModel.FuncNo) (string, error) {
+	value, err := rf.ReadFunctionContext(ctx, uid, fno)
+	if nil != err {
+		return "", err
+	}
+	dataType, _, err := rf.DataTypes(ctx, uid, fno)
+	if nil != err {
+		return "", err
+	}
+	return FormatValue(dataType, value), nil
+}
+
+// FormatValue prints the value of the data type, strings are quoted and byte arrays are hex
+func FormatValue(dataType RFModel.EDataType, value RFModel.Variant) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("%q (%v)", v, dataType)
+	case []byte:
+		return fmt.Sprintf("%v (%v, %v bytes)", hex.EncodeToString(v), dataType, len(v))
+	case TranscieverModel.Payload:
+		return fmt.Sprintf("%v (%v, %v bytes)", hex.EncodeToString(v), dataType, len(v))
+	}
+	return fmt.Sprintf("%v (%v)", value, dataType)
+}
+
+func write(ctx context.Context, rf *RFModel.RFModel, uid RFModel.UID, fno RFModel.FuncNo, value string) (string, error) {
+	_, dataType, err := rf.DataTypes(ctx, uid, fno)
+	if nil != err {
+		return "", err
+	}
+	var v RFModel.Variant = value
+	if RFModel.EDByteArray == dataType {
+		if v, err = parseHex(value); nil != err {
+			return "", err
+		}
+	}
+	if err := rf.WriteFunctionContext(ctx, uid, fno, v); nil != err {
+		return "", err
+	}
+	return fmt.Sprintf("written (%v)", dataType), nil
+}
+
+func raw(ctx context.Context, rf *RFModel.RFModel, uid RFModel.UID, fno RFModel.FuncNo, payload string) (string, error) {
+	request, err := parseHex(payload)
+	if nil != err {
+		return "", err
+	}
+	response, err := rf.CallFunctionContext(ctx, uid, fno, request)
+	if nil != err {
+		return "", err
+	}
+	return fmt.Sprintf("%v (%v bytes)", strings.TrimSpace(RFModel.Dump(response)), len(response)), nil
+}
+
+// parseHex takes "0102", "01 02" and "01:02"
+func parseHex(s string) ([]byte, error) {
+	ret, err := hex.DecodeString(strings.NewReplacer(" ", "", ":", "").Replace(s))
+	if nil != err {
+		return nil, fmt.Errorf("RFControl.parseHex(%v): %v; ", s, err)
+	}
+	return ret, nil
+}
+
+// describe prints the unit/function/type table of the device
+func describe(ctx context.Context, rf *RFModel.RFModel, address RFModel.DeviceAddress) (string, error) {
+	unitCount, err := rf.UnitCount(ctx, address)
+	if nil != err {
+		return "", err
+	}
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "UNIT\tDESCRIPTION\tFUNCTION\tREAD\tWRITE")
+	for i := 1; i <= unitCount; i++ {
+		uid := RFModel.UID{Address: address, Unit: byte(i)}
+		description, err := rf.UnitDescription(ctx, uid)
+		if nil != err {
+			description = "-"
+		}
+		functions, err := rf.ListFunctions(ctx, uid)
+		if nil != err {
+			return "", err
+		}
+		for _, f := range functions {
+			fmt.Fprintf(w, "%v\t%v\t0x%02X\t%v\t%v\n", i, description, byte(f.FNo), typeName(f.Read), typeName(f.Write))
+		}
+		if 0 == len(functions) {
+			fmt.Fprintf(w, "%v\t%v\t-\t-\t-\n", i, description)
+		}
+	}
+	_ = w.Flush()
+	return fmt.Sprintf("%v: %v units\n%s", RFModel.AddressToString(address), unitCount, b.Bytes()), nil
+}
+
+func typeName(t RFModel.EDataType) string {
+	if RFModel.EDNone == t {
+		return "-"
+	}
+	return t.String()
+}
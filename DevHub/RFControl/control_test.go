@@ -0,0 +1,88 @@
+package RFControl
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"../RFModel"
+	"../SimTransciever"
+)
+
+const testDevices = `{
+	"relay": {
+		"address": "AA:AA:AA:AA:01",
+		"units": {
+			"unit 1": {
+				"address": 1,
+				"description": "relay",
+				"functions": {
+					"out": {"function": 16, "read": true, "write": true},
+					"name": {"function": 18, "read": true, "write": false, "type": "string", "value": "hall"},
+					"raw": {"function": 20, "read": true, "write": true, "type": "byte array", "value": [1, 2]},
+				},
+			},
+		},
+	},
+}`
+
+func initTestModel(t *testing.T) (*RFModel.RFModel, string) {
+	dir, err := ioutil.TempDir("", "rfcontrol")
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	name := filepath.Join(dir, "devices.json")
+	_ = ioutil.WriteFile(name, []byte(testDevices), 0644)
+	var tr SimTransciever.SimTransmitter
+	SimTransciever.Init(&tr, SimTransciever.TransmitterSettings{DevicesFile: name})
+	var rf RFModel.RFModel
+	RFModel.Init(&rf, &tr)
+	return &rf, dir
+}
+
+func TestExecute(t *testing.T) {
+	rf, _ := initTestModel(t)
+	ctx := context.Background()
+	check := func(r Request, expected string) {
+		t.Helper()
+		output, err := Execute(ctx, rf, r)
+		if nil != err || !strings.Contains(output, expected) {
+			t.Errorf("%v: <%v>, error %v", r, output, err)
+		}
+	}
+	check(Request{Command: CWrite, Address: "AA:AA:AA:AA:01", Unit: 1, Function: 0x11, Value: "true"}, "written (bool)")
+	check(Request{Command: CRead, Address: "AA:AA:AA:AA:01", Unit: 1, Function: 0x10}, "true (bool)")
+	check(Request{Command: CRead, Address: "AA:AA:AA:AA:01", Unit: 1, Function: 0x12}, `"hall" (string)`)
+	check(Request{Command: CWrite, Address: "AA:AA:AA:AA:01", Unit: 1, Function: 0x15, Value: "0A 0B"}, "written (byte array)")
+	check(Request{Command: CRead, Address: "AA:AA:AA:AA:01", Unit: 1, Function: 0x14}, "0a0b (byte array, 2 bytes)")
+	check(Request{Command: CRaw, Address: "AA:AA:AA:AA:01", Unit: 1, Function: 0x10}, "01 (1 bytes)")
+	check(Request{Command: CDescribe, Address: "AA:AA:AA:AA:01"}, "relay")
+	_, err := Execute(ctx, rf, Request{Command: CRead, Address: "AA:AA:AA:AA:01", Unit: 5, Function: 0x10})
+	if nil == err || !strings.Contains(err.Error(), "ERCBadUnitId (0xA0)") {
+		t.Errorf("bad unit error is %v", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	rf, dir := initTestModel(t)
+	socket := filepath.Join(dir, "devhub.sock")
+	if _, err := Call(socket, Request{Command: CDescribe, Address: "AA:AA:AA:AA:01"}, time.Second); !errors.Is(err, ErrNoHub) {
+		t.Errorf("call without the hub is %v", err)
+	}
+	var s Server
+	Init(&s, rf, socket)
+	defer s.Close()
+	output, err := Call(socket, Request{Command: CRead, Address: "AA:AA:AA:AA:01", Unit: 1, Function: 0x12}, time.Second)
+	if nil != err || `"hall" (string)` != output {
+		t.Errorf("hub answered <%v>, error %v", output, err)
+	}
+	if _, err := Call(socket, Request{Command: CRead, Address: "AA:AA:AA:AA:01", Unit: 1, Function: 0x40}, time.Second); nil == err || !strings.Contains(err.Error(), "ERCBadFunctionId") {
+		t.Errorf("bad function error is %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package RFControl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"../RFModel"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.New()
+
+// requestTimeout limits a single request in the hub, describe of a big device is the longest one
+const requestTimeout = time.Minute
+
+// ErrNoHub is returned by Call when nobody listens on the socket, rfctl uses the transmitter itself then
+var ErrNoHub = errors.New("hub is not running")
+
+type response struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Server executes rfctl requests in the hub, one request per connection
+// it uses the same model as the cache, calls of the model do not interfere
+type Server struct {
+	listener net.Listener
+	rf       *RFModel.RFModel
+}
+
+// Init listens on the unix socket, the socket left by the hub, which is not running anymore, is replaced
+func Init(self *Server, rf *RFModel.RFModel, socket string) {
+	log.Formatter = new(logrus.TextFormatter)
+	log.Level = logrus.InfoLevel
+	log.Out = os.Stdout
+	self.rf = rf
+	if connection, err := net.Dial("unix", socket); nil == err {
+		_ = connection.Close()
+		panic(fmt.Errorf("RFControl.Init: %v is served by another hub; ", socket))
+	}
+	_ = os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if nil != err {
+		panic(fmt.Errorf("RFControl.Init: net.Listen(%v): %v; ", socket, err))
+	}
+	self.listener = listener
+	go self.acceptLoop()
+	log.Info(fmt.Sprintf("RFControl.Init: rfctl requests are served on %v", socket))
+}
+
+// Close stops serving and removes the socket
+func (s *Server) Close() {
+	_ = s.listener.Close()
+}
+
+func (s *Server) acceptLoop() {
+	for {
+		connection, err := s.listener.Accept()
+		if nil != err {
+			return
+		}
+		go s.serve(connection)
+	}
+}
+
+func (s *Server) serve(connection net.Conn) {
+	defer connection.Close()
+	var r Request
+	if err := json.NewDecoder(connection).Decode(&r); nil != err {
+		log.Warning(fmt.Sprintf("RFControl.serve: %v", err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	output, err := Execute(ctx, s.rf, r)
+	ret := response{Output: output}
+	if nil != err {
+		ret.Error = err.Error()
+	}
+	log.Info(fmt.Sprintf("RFControl.serve: %v %v %v 0x%X: %v", r.Command, r.Address, r.Unit, r.Function, ret.Error))
+	_ = json.NewEncoder(connection).Encode(ret)
+}
+
+// Call executes the request in the hub, which serves the socket
+// it is ErrNoHub if nobody serves it
+func Call(socket string, r Request, timeout time.Duration) (string, error) {
+	connection, err := net.DialTimeout("unix", socket, timeout)
+	if nil != err {
+		return "", fmt.Errorf("%w: %v", ErrNoHub, err)
+	}
+	defer connection.Close()
+	_ = connection.SetDeadline(time.Now().Add(timeout))
+	if err := json.NewEncoder(connection).Encode(r); nil != err {
+		return "", err
+	}
+	var ret response
+	if err := json.NewDecoder(connection).Decode(&ret); nil != err {
+		return "", fmt.Errorf("RFControl.Call: hub response: %v", err)
+	}
+	if "" != ret.Error {
+		return ret.Output, errors.New(ret.Error)
+	}
+	return ret.Output, nil
+}
@@ -3,9 +3,12 @@ package RFModel
 import (
 	"../TranscieverModel"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
 )
 
 type DeviceAddress TranscieverModel.Address
@@ -62,6 +65,31 @@ const (
 	EDUnspecified           = 0xF
 )
 
+// DataTypeNames are EDataType names for json files
+var DataTypeNames = map[string]EDataType{
+	"none":       EDNone,
+	"bool":       EDBool,
+	"byte":       EDByte,
+	"int32":      EDInt32,
+	"string":     EDString,
+	"byte array": EDByteArray,
+}
+
+// ParseDataType by its name from DataTypeNames
+func ParseDataType(name string) (EDataType, bool) {
+	t, ok := DataTypeNames[name]
+	return t, ok
+}
+
+func (t EDataType) String() string {
+	for name, v := range DataTypeNames {
+		if v == t {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%X", byte(t))
+}
+
 // RF functions
 const (
 	// Unit 0, global device functions
@@ -97,43 +125,73 @@ const (
 	ERCBadRequestData              = 0xE0
 )
 
-var transactionID byte = 0
+// ResponseCodeNames are the names of the response codes for people
+var ResponseCodeNames = map[EResponseCode]string{
+	ERCOk:                          "ERCOk",
+	ERCAddressBadLength:            "ERCAddressBadLength",
+	ERCChBadChannels:               "ERCChBadChannels",
+	ERCChBadPermissions:            "ERCChBadPermissions",
+	ERCChValidationFailed:          "ERCChValidationFailed",
+	ERCNotImplemented:              "ERCNotImplemented",
+	ERCBadVersion:                  "ERCBadVersion",
+	ERCBadUnitId:                   "ERCBadUnitId",
+	ERCNotConsecutiveTransactionId: "ERCNotConsecutiveTransactionId",
+	ERCBadFunctionId:               "ERCBadFunctionId",
+	ERCResponseTooBig:              "ERCResponseTooBig",
+	ERCBadRequestData:              "ERCBadRequestData",
+}
 
+func (c EResponseCode) String() string {
+	if name, ok := ResponseCodeNames[c]; ok {
+		return fmt.Sprintf("%v (0x%02X)", name, byte(c))
+	}
+	return fmt.Sprintf("0x%02X", byte(c))
+}
+
+// serializeRequest panics on failure, see encodeRequest
 func serializeRequest(rq *request) TranscieverModel.Payload {
+	ret, err := encodeRequest(rq)
+	if nil != err {
+		panic(toPanic(err))
+	}
+	return ret
+}
+
+func encodeRequest(rq *request) (TranscieverModel.Payload, error) {
 	if MaxDataLengthRq < uint(rq.DataLength) {
-		panic(Error{
-			Error: fmt.Errorf("RFModel.serializeRequest: too big DataLength %v; ", rq.DataLength),
-			Type:  EBadParameter,
-		})
+		return nil, newError(EBadParameter, "RFModel.encodeRequest: too big DataLength %v; ", rq.DataLength)
 	}
 	buf := bytes.Buffer{}
 	if err := binary.Write(&buf, binary.LittleEndian, rq); err != nil {
-		panic(Error{
-			Error: fmt.Errorf("RFModel.serializeRequest: binary.Write: %v; ", err.Error()),
-			Type:  EGeneral,
-		})
+		return nil, newError(EGeneral, "RFModel.encodeRequest: binary.Write: %v; ", err.Error())
+	}
+	return buf.Bytes()[:PacketLength-MaxDataLengthRq+uint(rq.DataLength)], nil
+}
+
+// parseResponse panics on failure, see decodeResponse
+func parseResponse(r *TranscieverModel.Payload) response {
+	ret, err := decodeResponse(r)
+	if nil != err {
+		panic(toPanic(err))
 	}
-	return buf.Bytes()[:PacketLength-MaxDataLengthRq+uint(rq.DataLength)]
+	return ret
 }
 
-func parseResponse(r *TranscieverModel.Payload) (ret response) {
+func decodeResponse(r *TranscieverModel.Payload) (ret response, err error) {
 	if PacketLength < uint(len(*r)) {
-		panic(Error{
-			Error: fmt.Errorf("RFModel.parseResponse: too big packet of length %v; ", len(*r)),
-			Type:  EPacketValidation,
-		})
+		return ret, newError(EPacketValidation, "RFModel.decodeResponse: too big packet of length %v; ", len(*r))
+	}
+	if ResponseHeaderSize > uint(len(*r)) {
+		return ret, newError(EPacketValidation, "RFModel.decodeResponse: too short packet of length %v; ", len(*r))
 	}
 	buf := bytes.Buffer{}
 	buf.Write(*r)
 	buf.Write(make([]byte, int(PacketLength+1)-len(*r)))
 	if err := binary.Read(&buf, binary.LittleEndian, &ret); err != nil {
-		panic(Error{
-			Error: fmt.Errorf("RFModel.parseResponse: binary.Read: %v; ", err.Error()),
-			Type:  EPacketValidation,
-		})
+		return ret, newError(EPacketValidation, "RFModel.decodeResponse: binary.Read: %v; ", err.Error())
 	}
 	ret.DataLength = byte(len(*r) - int(ResponseHeaderSize))
-	return ret
+	return ret, nil
 }
 
 func (r request) Payload() []byte {
@@ -148,8 +206,7 @@ func (r response) Payload() (ret []byte) {
 	return ret
 }
 
-func createRequest(unitID byte, FunctionID byte, data []byte) request {
-	defer func() { transactionID += 1 }()
+func createRequest(transactionID byte, unitID byte, FunctionID byte, data []byte) request {
 	var structData [MaxDataLengthRq]byte
 	copy(structData[:], data)
 	return request{
@@ -166,79 +223,214 @@ func basicValidateResponse(r *response) bool {
 	if 0 != r.Version {
 		return false
 	}
-	if transactionID-1 != r.TransactionID {
-		return false
-	}
 	return true
 }
 
-func validateResponse(to *DeviceAddress, rq *request, rs *TranscieverModel.Message) (retResp response, retStatus bool) {
-	defer func() {
-		if r := recover(); r != nil {
-			if EPacketValidation == r.(Error).Type {
-				retStatus = false
-			}
-		}
-	}()
-	retResp = parseResponse(&rs.Payload)
+func validateResponse(to *DeviceAddress, rq *request, rs *TranscieverModel.Message) (response, error) {
+	retResp, err := decodeResponse(&rs.Payload)
+	if nil != err {
+		return retResp, err
+	}
 	if !basicValidateResponse(&retResp) {
-		panic(Error{
-			Error: errors.New("RFModel.validateResponse: basicValidateResponse; "),
-			Type:  EPacketValidation,
-		})
+		return retResp, newError(EPacketValidation, "RFModel.validateResponse: basicValidateResponse; ")
 	}
 	if TranscieverModel.Address(*to) != rs.Address {
 		// todo count that cases
-		panic(Error{
-			Error: errors.New("RFModel.validateResponse: unexpected packet from wrong Address; "),
-			Type:  EPacketValidation,
-		})
+		return retResp, newError(EPacketValidation, "RFModel.validateResponse: unexpected packet from wrong Address; ")
 	}
 	if rq.TransactionID != retResp.TransactionID {
-		panic(Error{
-			Error: errors.New("RFModel.validateResponse: bad transaction id; "),
-			Type:  EPacketValidation,
-		})
+		return retResp, newError(EPacketValidation, "RFModel.validateResponse: bad transaction id; ")
+	}
+	return retResp, nil
+}
+
+// sendCommand makes a transmitter transaction, transmitter failures are returned as ETransmitter errors
+func (rf *RFModel) sendCommand(ctx context.Context, a TranscieverModel.Address, data TranscieverModel.Payload) (message TranscieverModel.Message, err error) {
+	if transmitter, ok := rf.transmitter.(TranscieverModel.ContextTransmitter); ok {
+		message, err = transmitter.SendCommandContext(ctx, a, data)
+	} else {
+		func() {
+			defer func() {
+				if r := recover(); nil != r {
+					err = RecoveredError(r)
+				}
+			}()
+			message = rf.transmitter.SendCommand(a, data)
+		}()
+	}
+	if nil != err {
+		if ctxErr := contextError(ctx); nil != ctxErr {
+			return message, ctxErr
+		}
+		return message, &CallError{Type: ETransmitter, Err: err}
 	}
-	return retResp, true
+	return message, nil
 }
 
 // CallFunction is basic api for RFModel
-// TODO shall catch and handle all the transceiver panics
-// TODO retry 3 times on any error
-// may panic by its own
+// panics with Error, see CallFunctionContext
 func (rf *RFModel) CallFunction(uid UID, fno FuncNo, payload TranscieverModel.Payload) TranscieverModel.Payload {
-	rq := createRequest(uid.Unit, byte(fno), payload)
-	rqSerialized := serializeRequest(&rq)
-	for i := 3; 0 <= i; i-- {
+	ret, err := rf.CallFunctionContext(context.Background(), uid, fno, payload)
+	if nil != err {
+		panic(toPanic(err))
+	}
+	return ret
+}
+
+// CallFunctionContext sends request and waits for the response, retrying 3 times on timeouts and invalid responses
+// transaction id out of sync with the device is reset with F0ResetTransactionID and the request is repeated,
+// secure device session is made anew instead
+// returns *CallError: EBadCode with the response code, EDeviceTimeout, ETransmitter, ECancelled, ESecurity or EBadParameter
+func (rf *RFModel) CallFunctionContext(ctx context.Context, uid UID, fno FuncNo, payload TranscieverModel.Payload) (TranscieverModel.Payload, error) {
+	return rf.callFunction(ctx, uid, fno, payload, callTries)
+}
+
+// callFunction is CallFunctionContext sending the request up to tries times
+func (rf *RFModel) callFunction(ctx context.Context, uid UID, fno FuncNo, payload TranscieverModel.Payload, tries int) (TranscieverModel.Payload, error) {
+	d := getDevice(uid.Address)
+	// transaction ids of the device have to go one after another
+	d.transactionLock.Lock()
+	defer d.transactionLock.Unlock()
+	device := AddressToString(uid.Address)
+	defer func(start time.Time) {
+		callDuration.WithLabelValues(device).Observe(time.Now().Sub(start).Seconds())
+	}(time.Now())
+	pm, err := rf.transaction(ctx, d, uid, fno, payload, tries)
+	var callError *CallError
+	if errors.As(err, &callError) && ESecurity == callError.Type && ERCOk != callError.Code {
+		// device has lost the session, transaction makes a new one
+		atomic.AddUint32(&d.resyncs, 1)
+		pm, err = rf.transaction(ctx, d, uid, fno, payload, tries)
+	} else if nil == err && ERCNotConsecutiveTransactionId == EResponseCode(pm.Code) && !(0 == uid.Unit && F0ResetTransactionID == fno) {
+		if err = rf.resyncTransactionID(ctx, d); nil == err {
+			pm, err = rf.transaction(ctx, d, uid, fno, payload, tries)
+		}
+	}
+	if nil != err {
+		if errors.Is(err, ErrDeviceTimeout) {
+			callTimeouts.WithLabelValues(device).Inc()
+		}
+		return nil, err
+	}
+	// now we have received, parsed and validated message from the device
+	if 0 != pm.Code {
+		badCodeResponses.WithLabelValues(device, fmt.Sprintf("0x%02X", pm.Code)).Inc()
+		return nil, &CallError{
+			Type: EBadCode,
+			Code: EResponseCode(pm.Code),
+			Err: fmt.Errorf(
+				"RFModel.CallFunction(uid %X, fno 0x%X, payload %s) bad error code 0x%X response %s; ",
+				uid, fno, Dump(payload), pm.Code, Dump(pm.Payload()),
+			),
+		}
+	}
+	log.Debug(fmt.Sprintf("RFModel.CallFunction uid %X, FNo 0x%X, payload %s, response %s", uid, fno, Dump(payload), Dump(pm.Payload())))
+	return pm.Payload(), nil
+}
+
+// resyncTransactionID makes the device accept our transaction id, device transactionLock should be locked
+func (rf *RFModel) resyncTransactionID(ctx context.Context, d *Device) error {
+	log.Info(fmt.Sprintf("RFModel.resyncTransactionID: device %v, transaction id 0x%X", AddressToString(d.Address), byte(d.transactionCounter)))
+	pm, err := rf.transaction(ctx, d, UID{Address: d.Address, Unit: 0}, F0ResetTransactionID, []byte{}, callTries)
+	if nil != err {
+		return err
+	}
+	if 0 != pm.Code {
+		return &CallError{
+			Type: EBadCode,
+			Code: EResponseCode(pm.Code),
+			Err:  fmt.Errorf("RFModel.resyncTransactionID(%v) bad error code 0x%X; ", AddressToString(d.Address), pm.Code),
+		}
+	}
+	atomic.AddUint32(&d.resyncs, 1)
+	return nil
+}
+
+// callTries is how many times a request is sent until the device responds
+const callTries = 4
+
+// transaction sends a single request up to tries times and returns the validated response of any code
+// retries repeat the same transaction id, it is advanced once the device has responded
+// request and response data of the secure device are sealed here, making a session first if needed
+// device transactionLock should be locked
+func (rf *RFModel) transaction(ctx context.Context, d *Device, uid UID, fno FuncNo, payload TranscieverModel.Payload, tries int) (response, error) {
+	secure := nil != d.key && !(0 == uid.Unit && F0SetNewSessionKey == fno)
+	if secure && (nil == d.sessionKey || maxSessionCounter <= d.transactionCounter) {
+		if err := rf.establishSession(ctx, d); nil != err {
+			return response{}, err
+		}
+	}
+	counter := d.transactionCounter
+	data := payload
+	if secure {
+		if MaxSecureDataLengthRq < uint(len(payload)) {
+			return response{}, newError(EBadParameter, "RFModel.CallFunction: too big payload of %v bytes for the secure device; ", len(payload))
+		}
+		data = sealPayload(d.sessionKey, DirectionRequest, counter, []byte{0, byte(counter), uid.Unit, byte(fno)}, payload)
+	}
+	rq := createRequest(byte(counter), uid.Unit, byte(fno), data)
+	rqSerialized, err := encodeRequest(&rq)
+	if nil != err {
+		return response{}, err
+	}
+	var lastError error
+	for i := tries - 1; 0 <= i; i-- {
+		if err := contextError(ctx); nil != err {
+			return response{}, err
+		}
 		log.Debug(fmt.Sprintf("RFModel.CallFunction try %v", i))
-		message := rf.transmitter.SendCommand(TranscieverModel.Address(uid.Address), rqSerialized)
-		if TranscieverModel.EMSDataPacket == message.Status {
-			// message received
-			if pm, ok := validateResponse(&uid.Address, &rq, &message); ok {
-				// now we have received, parsed and validated message from the device
-				if 0 != pm.Code {
-					panic(Error{
-						Error: fmt.Errorf(
-							"RFModel.CallFunction(uid %X, fno 0x%X, payload %s) bad error code 0x%X response %s; ",
-							uid, fno, Dump(payload), pm.Code, Dump(pm.Payload()),
-						),
-						Type: EBadCode,
-						Code: pm.Code,
-					})
-				}
-				log.Debug(fmt.Sprintf("RFModel.CallFunction uid %X, FNo 0x%X, payload %s, response %s", uid, fno, Dump(payload), Dump(pm.Payload())))
-				return pm.Payload()
+		callAttempts.WithLabelValues(AddressToString(d.Address)).Inc()
+		if tries-1 != i {
+			callRetries.WithLabelValues(AddressToString(d.Address)).Inc()
+		}
+		message, err := rf.sendCommand(ctx, TranscieverModel.Address(uid.Address), rqSerialized)
+		if nil != err {
+			if errors.Is(err, ErrCancelled) {
+				return response{}, err
 			}
-		} else {
+			log.Debug(fmt.Sprintf("RFModel.CallFunction: %v", err))
+			lastError = err
+			continue
+		}
+		if TranscieverModel.EMSDataPacket != message.Status {
 			log.Debug("RFModel.Protocol.CallFunction: listen timeout")
+			lastError = nil
+			continue
+		}
+		// message received
+		pm, err := validateResponse(&uid.Address, &rq, &message)
+		if nil != err {
+			log.Debug(fmt.Sprintf("RFModel.CallFunction: %v", err))
+			lastError = nil
+			continue
 		}
+		if secure {
+			plain, ok := openPayload(d.sessionKey, DirectionResponse, counter, []byte{pm.Version, pm.TransactionID, pm.Code}, pm.Payload())
+			if !ok {
+				if 0 == pm.DataLength && isSessionFailure(EResponseCode(pm.Code)) {
+					d.sessionKey = nil
+					return response{}, &CallError{
+						Type: ESecurity,
+						Code: EResponseCode(pm.Code),
+						Err:  fmt.Errorf("RFModel.CallFunction: device %v has no session; ", AddressToString(d.Address)),
+					}
+				}
+				log.Warning(fmt.Sprintf("RFModel.CallFunction: response of uid %X, FNo 0x%X failed MAC check", uid, fno))
+				lastError = nil
+				continue
+			}
+			pm.DataLength = byte(copy(pm.Data[:], plain))
+		}
+		d.transactionCounter = counter + 1
+		return pm, nil
+	}
+	if nil != lastError {
+		// the last try has failed in the transmitter, so it is not known if the device is there
+		return response{}, lastError
 	}
-	panic(Error{
-		Error: fmt.Errorf(
-			"RFModel.CallFunction.Listen: response timeout 3 times in a row for uid %X, FNo 0x%X, payload %s. Packet is %s",
-			uid, fno, Dump(payload), Dump(rqSerialized),
-		),
-		Type: EDeviceTimeout,
-	})
+	return response{}, newError(
+		EDeviceTimeout,
+		"RFModel.CallFunction.Listen: response timeout %v times in a row for uid %X, FNo 0x%X, payload %s. Packet is %s",
+		tries, uid, fno, Dump(payload), Dump(rqSerialized),
+	)
 }
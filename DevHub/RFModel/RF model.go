@@ -1,8 +1,11 @@
 package RFModel
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 
 	"../TranscieverModel"
@@ -34,26 +37,40 @@ func (rf *RFModel) Close() {
 	rf.transmitter.Close()
 }
 
-func checkPayload(payload TranscieverModel.Payload, length int, uid UID, fno FuncNo) {
+func checkPayload(payload TranscieverModel.Payload, length int, uid UID, fno FuncNo) error {
 	if len(payload) != length {
-		panic(Error{
-			Error: fmt.Errorf(
-				"RFModel.checkPayload(payload %s, length %v, uid %X FNo 0x%X) length does not correspond data type; ",
-				Dump(payload), length, uid, fno,
-			),
-			Type: EBadResponse,
-		})
+		return newError(
+			EBadResponse,
+			"RFModel.checkPayload(payload %s, length %v, uid %X FNo 0x%X) length does not correspond data type; ",
+			Dump(payload), length, uid, fno,
+		)
 	}
+	return nil
 }
 
 // ReadFunction read from the unit
-// call given function with empty payload and parse result according to the function output type (fn 0 of a given unit)
+// panics with Error, see ReadFunctionContext
 func (rf *RFModel) ReadFunction(uid UID, fno FuncNo) Variant {
+	ret, err := rf.ReadFunctionContext(context.Background(), uid, fno)
+	if nil != err {
+		panic(toPanic(err))
+	}
+	return ret
+}
+
+// ReadFunctionContext read from the unit
+// call given function with empty payload and parse result according to the function output type (fn 0 of a given unit)
+func (rf *RFModel) ReadFunctionContext(ctx context.Context, uid UID, fno FuncNo) (Variant, error) {
 	rfLock.Lock()
 	defer rfLock.Unlock()
 	// check all device units and functions data types to cast
-	checkDeviceUnits(rf, uid)
-	payload := rf.CallFunction(uid, fno, []byte{})
+	if err := checkDeviceUnits(ctx, rf, uid); nil != err {
+		return nil, err
+	}
+	payload, err := rf.CallFunctionContext(ctx, uid, fno, []byte{})
+	if nil != err {
+		return nil, err
+	}
 	dataType := UnitFunctions[UnitFunctionKey{
 		UID: uid,
 		FNo: fno,
@@ -61,98 +78,176 @@ func (rf *RFModel) ReadFunction(uid UID, fno FuncNo) Variant {
 	switch dataType {
 	case EDNone:
 		{
-			return 0
+			return 0, nil
 		}
 	case EDBool:
 		{
-			checkPayload(payload, 1, uid, fno)
+			if err := checkPayload(payload, 1, uid, fno); nil != err {
+				return nil, err
+			}
 			if 0 == payload[0] {
-				return false
+				return false, nil
 			}
-			return true
+			return true, nil
 		}
 	case EDByte:
 		{
-			checkPayload(payload, 1, uid, fno)
-			return uint8(payload[0])
+			if err := checkPayload(payload, 1, uid, fno); nil != err {
+				return nil, err
+			}
+			return uint8(payload[0]), nil
 		}
 	case EDInt32:
 		{
-			checkPayload(payload, 4, uid, fno)
+			if err := checkPayload(payload, 4, uid, fno); nil != err {
+				return nil, err
+			}
 			// todo test against negative values
-			return int32(payload[0]) + int32(payload[1])<<8 + int32(payload[2])<<16 + int32(payload[3])<<24
+			return int32(payload[0]) + int32(payload[1])<<8 + int32(payload[2])<<16 + int32(payload[3])<<24, nil
 		}
 	case EDString:
 		{
 			// any length is valid
-			return string(payload)
+			return string(payload), nil
 		}
 	case EDByteArray:
 		{
 			// any length is valid
-			return payload
+			return payload, nil
 		}
 	}
-	panic(Error{
-		Error: fmt.Errorf(
-			"RFModel.ReadFunction(uid %X FNo 0x%X payload %s) unexpected data type %v; ",
-			uid, fno, Dump(payload), dataType,
-		),
-		Type: EGeneral,
-	})
+	return nil, newError(
+		EGeneral,
+		"RFModel.ReadFunction(uid %X FNo 0x%X payload %s) unexpected data type %v; ",
+		uid, fno, Dump(payload), dataType,
+	)
 }
 
 // WriteFunction write to the unit
-// call given function with serialized value as a payload according to the function input type
+// panics with Error, see WriteFunctionContext
 func (rf *RFModel) WriteFunction(uid UID, fno FuncNo, value Variant) {
+	if err := rf.WriteFunctionContext(context.Background(), uid, fno, value); nil != err {
+		panic(toPanic(err))
+	}
+}
+
+// WriteFunctionContext write to the unit
+// call given function with serialized value as a payload according to the function input type
+// besides native go types, value can be a string in the format Cache uses
+func (rf *RFModel) WriteFunctionContext(ctx context.Context, uid UID, fno FuncNo, value Variant) error {
+	return rf.writeFunction(ctx, uid, fno, value, callTries)
+}
+
+// WriteFunctionOnceContext is WriteFunctionContext sending the request once instead of retrying
+// plaintext device executes the repeated request again, when its response was lost, so events like pulses are sent once
+// the request or the response lost is EDeviceTimeout, the request could have been executed then
+func (rf *RFModel) WriteFunctionOnceContext(ctx context.Context, uid UID, fno FuncNo, value Variant) error {
+	return rf.writeFunction(ctx, uid, fno, value, 1)
+}
+
+func (rf *RFModel) writeFunction(ctx context.Context, uid UID, fno FuncNo, value Variant, tries int) error {
 	rfLock.Lock()
 	defer rfLock.Unlock()
-	checkDeviceUnits(rf, uid)
-	var payload TranscieverModel.Payload
+	if err := checkDeviceUnits(ctx, rf, uid); nil != err {
+		return err
+	}
 	ufKey := UnitFunctionKey{
 		UID: uid,
 		FNo: fno,
 	}
 	dataType := UnitFunctions[ufKey].write
+	payload, err := serializeValue(dataType, value)
+	if nil != err {
+		return newError(EBadParameter, "RFModel.WriteFunction(uid %X FNo 0x%X value %v): %v", uid, fno, value, err)
+	}
+	_, err = rf.callFunction(ctx, uid, fno, payload, tries)
+	return err
+}
+
+func serializeValue(dataType EDataType, value Variant) (TranscieverModel.Payload, error) {
 	switch dataType {
 	case EDBool:
 		{
-			if true == value || "true" == value {
-				payload = TranscieverModel.Payload{1}
-			} else {
-				payload = TranscieverModel.Payload{0}
+			// anything, which is not true, is false as it always was, "on" or the empty key of the new database too
+			b, _ := value.(bool)
+			if v, isString := value.(string); isString {
+				b, _ = strconv.ParseBool(strings.ToLower(v))
 			}
+			if b {
+				return TranscieverModel.Payload{1}, nil
+			}
+			return TranscieverModel.Payload{0}, nil
 		}
 	case EDByte:
 		{
-			payload = TranscieverModel.Payload{byte(value.(uint8))}
+			switch v := value.(type) {
+			case uint8:
+				return TranscieverModel.Payload{v}, nil
+			case string:
+				b, err := strconv.ParseUint(v, 0, 8)
+				if nil != err {
+					return nil, err
+				}
+				return TranscieverModel.Payload{byte(b)}, nil
+			}
 		}
 	case EDInt32:
 		{
-			i := int32(value.(int))
-			payload = TranscieverModel.Payload{
+			var i int32
+			switch v := value.(type) {
+			case int:
+				i = int32(v)
+			case int32:
+				i = v
+			case string:
+				parsed, err := strconv.ParseInt(v, 0, 32)
+				if nil != err {
+					return nil, err
+				}
+				i = int32(parsed)
+			default:
+				return nil, fmt.Errorf("unexpected value type %T for %v", value, dataType)
+			}
+			return TranscieverModel.Payload{
 				byte(i & 0xFF),
 				byte((i >> 8) & 0xFF),
 				byte((i >> 16) & 0xFF),
 				byte((i >> 24) & 0xFF),
-			}
+			}, nil
 		}
 	case EDString:
 		{
-			payload = TranscieverModel.Payload(value.(string))
+			if v, ok := value.(string); ok {
+				return TranscieverModel.Payload(v), nil
+			}
 		}
 	case EDByteArray:
 		{
-			payload = value.([]byte)
+			switch v := value.(type) {
+			case []byte:
+				return v, nil
+			case TranscieverModel.Payload:
+				return v, nil
+			case string:
+				return TranscieverModel.Payload(v), nil
+			}
 		}
 	default:
-		panic(Error{
-			Error: fmt.Errorf(
-				"RFModel.WriteFunction(uid %X FNo 0x%X value %v) unexpected input data format %v; ",
-				uid, fno, value, dataType,
-			),
-			Type: EGeneral,
-		})
+		return nil, fmt.Errorf("unexpected input data format %v", dataType)
+	}
+	return nil, fmt.Errorf("unexpected value type %T for %v", value, dataType)
+}
+
+// DataTypes returns read and write data types of the function, as the device has reported them
+func (rf *RFModel) DataTypes(ctx context.Context, uid UID, fno FuncNo) (read EDataType, write EDataType, err error) {
+	rfLock.Lock()
+	defer rfLock.Unlock()
+	if err := checkDeviceUnits(ctx, rf, uid); nil != err {
+		return EDUnspecified, EDUnspecified, err
+	}
+	function, ok := UnitFunctions[UnitFunctionKey{UID: uid, FNo: fno}]
+	if !ok {
+		return EDUnspecified, EDUnspecified, newError(EBadParameter, "RFModel.DataTypes: device does not report uid %X FNo 0x%X; ", uid, fno)
 	}
-	rf.CallFunction(uid, fno, payload)
+	return function.read, function.write, nil
 }
@@ -0,0 +1,20 @@
+package RFModel
+
+import (
+	"bytes"
+	"testing"
+
+	"../TranscieverModel"
+)
+
+func TestSerializeBool(t *testing.T) {
+	for value, expected := range map[Variant]byte{
+		"": 0, "false": 0, "0": 0, "on": 0, "yes": 0, "maybe": 0, 1: 0, false: 0,
+		"true": 1, "TRUE": 1, "True": 1, "1": 1, "t": 1, "T": 1, true: 1,
+	} {
+		payload, err := serializeValue(EDBool, value)
+		if nil != err || !bytes.Equal(TranscieverModel.Payload{expected}, payload) {
+			t.Errorf("bool <%v> is serialized to %v, %v instead of %v", value, payload, err, expected)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package RFModel
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,6 +15,16 @@ type Device struct {
 	UnitCount    uint
 	BuildNumber  uint32
 	AllFunctions []UnitFunctionKey
+	Statistics   DeviceStatistics
+	// transaction id of the next request to the device is its low byte
+	transactionCounter uint32
+	transactionLock    sync.Mutex
+	// how many times transaction id or secure session was reset, atomic
+	resyncs uint32
+	// pre-shared key, nil for plaintext devices
+	key []byte
+	// nil until established
+	sessionKey []byte
 }
 
 // UnitFunctionKey ...
@@ -28,71 +41,73 @@ type UnitFunction struct {
 
 // Devices all known devices
 var Devices = map[DeviceAddress]*Device{}
+var devicesLock sync.Mutex
 
 // UnitFunctions all known functions of all known devices
 var UnitFunctions = map[UnitFunctionKey]UnitFunction{}
 
 // checkDeviceUnits make sure cache has actual information about requested device units, functions and data types
-func checkDeviceUnits(rf *RFModel, uid UID) {
-	if v, ok := Devices[uid.Address]; ok {
-		if 1*time.Hour > time.Now().Sub(v.LastUpdate) {
-			return
+func checkDeviceUnits(ctx context.Context, rf *RFModel, uid UID) error {
+	if 1*time.Hour > time.Now().Sub(getDevice(uid.Address).LastUpdate) {
+		return nil
+	}
+	return updateDeviceUnits(ctx, rf, uid.Address)
+}
+
+// getDevice returns the known device, adding it if it is not known yet
+// units and functions of the added device are not known until checkDeviceUnits
+func getDevice(address DeviceAddress) *Device {
+	devicesLock.Lock()
+	defer devicesLock.Unlock()
+	d, ok := Devices[address]
+	if !ok {
+		d = &Device{
+			Address:      address,
+			AllFunctions: []UnitFunctionKey{},
 		}
+		Devices[address] = d
 	}
-	updateDeviceUnits(rf, uid.Address)
+	return d
 }
 
-func updateDeviceUnits(rf *RFModel, address DeviceAddress) {
-	unitsCountResponse := rf.CallFunction(UID{Address: address, Unit: 0}, FGetListOfUnitFunctions, []byte{})
-	// validation of the request. Don't like that huge chunk here it has to go somewhere else(
-	if 5 != len(unitsCountResponse) {
-		panic(Error{
-			Error: fmt.Errorf(
-				"incorrect response %v from the device %v Unit 0 function get number of internal units %v",
-				unitsCountResponse,
-				address,
-				FGetListOfUnitFunctions,
-			),
-			Type: EBadResponse,
-		})
+// TransactionResyncs returns how many times transaction id or secure session of the device was out of sync and reset
+func (rf *RFModel) TransactionResyncs(address DeviceAddress) uint {
+	return uint(atomic.LoadUint32(&getDevice(address).resyncs))
+}
+
+func updateDeviceUnits(ctx context.Context, rf *RFModel, address DeviceAddress) error {
+	unitCount, err := rf.UnitCount(ctx, address)
+	if nil != err {
+		return err
 	}
-	// todo get device statistics here too
 	// delete all Unit functions before re-population
-	if _, ok := Devices[address]; ok {
-		for _, v := range Devices[address].AllFunctions {
-			delete(UnitFunctions, v)
-		}
+	device := getDevice(address)
+	if err := updateDeviceStatistics(ctx, rf, device); nil != err {
+		// not every firmware has statistics
+		log.Debug(fmt.Sprintf("RFModel.updateDeviceUnits(%v): statistics: %v", AddressToString(address), err))
 	}
-	Devices[address] = &Device{
-		Address:      address,
-		LastUpdate:   time.Now(),
-		UnitCount:    uint(unitsCountResponse[0]),
-		AllFunctions: []UnitFunctionKey{},
+	for _, v := range device.AllFunctions {
+		delete(UnitFunctions, v)
 	}
-	for i := 1; i <= int(unitsCountResponse[0]); i++ {
+	device.LastUpdate = time.Now()
+	device.UnitCount = uint(unitCount)
+	device.AllFunctions = []UnitFunctionKey{}
+	for i := 1; i <= unitCount; i++ {
 		uid := UID{Address: address, Unit: byte(i)}
-		functionListResponse := rf.CallFunction(uid, FGetListOfUnitFunctions, []byte{})
-		// fucking validation, it should go somewhere else(
-		if 0 != len(functionListResponse)%2 {
-			panic(Error{
-				Error: fmt.Errorf(
-					"incorect rsponse %v from the Unit %v function get list of Unit functions %v",
-					functionListResponse,
-					uid,
-					FGetListOfUnitFunctions,
-				),
-				Type: EBadResponse,
-			})
+		functions, err := rf.ListFunctions(ctx, uid)
+		if nil != err {
+			// do not leave half populated device for an hour
+			device.LastUpdate = time.Time{}
+			return err
 		}
-		// now parse the function list from the slave
-		for f := 0; f < len(functionListResponse); f += 2 {
-			key := UnitFunctionKey{UID: uid, FNo: FuncNo(functionListResponse[f])}
+		for _, f := range functions {
+			key := UnitFunctionKey{UID: uid, FNo: f.FNo}
 			UnitFunctions[key] = UnitFunction{
-				read:  EDataType(functionListResponse[f+1] >> 4),
-				write: EDataType(functionListResponse[f+1] & 0x0F),
+				read:  f.Read,
+				write: f.Write,
 			}
-			Devices[address].AllFunctions = append(Devices[address].AllFunctions, key)
+			device.AllFunctions = append(device.AllFunctions, key)
 		}
 	}
-
+	return nil
 }
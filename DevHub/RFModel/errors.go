@@ -1,6 +1,8 @@
 package RFModel
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -15,14 +17,91 @@ const (
 	EPacketValidation           = "packet validation"
 	EDeviceTimeout              = "device did not respond 3 times in a row"
 	EBadCode                    = "function return code is not 0"
+	ETransmitter                = "transmitter failure"
+	ECancelled                  = "cancelled"
+	ESecurity                   = "secure session failure"
 )
 
+// Error is a panic value of the panicking api
 type Error struct {
 	Error error
 	Type  ErrorType
 	Code  byte
 }
 
+// CallError is returned by the error-returning api
+// errors.Is matches it against Err* sentinels by type, errors.As gives the response code
+type CallError struct {
+	Type ErrorType
+	Code EResponseCode
+	Err  error
+}
+
+// sentinels for errors.Is
+var (
+	ErrGeneral          = &CallError{Type: EGeneral}
+	ErrBadParameter     = &CallError{Type: EBadParameter}
+	ErrBadResponse      = &CallError{Type: EBadResponse}
+	ErrPacketValidation = &CallError{Type: EPacketValidation}
+	ErrDeviceTimeout    = &CallError{Type: EDeviceTimeout}
+	ErrBadCode          = &CallError{Type: EBadCode}
+	ErrTransmitter      = &CallError{Type: ETransmitter}
+	ErrCancelled        = &CallError{Type: ECancelled}
+	ErrSecurity         = &CallError{Type: ESecurity}
+)
+
+func (e *CallError) Error() string {
+	if nil == e.Err {
+		return string(e.Type)
+	}
+	return fmt.Sprintf("%v: %v", e.Type, e.Err)
+}
+
+func (e *CallError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches errors of the same type, and the same code if target has it
+func (e *CallError) Is(target error) bool {
+	t, ok := target.(*CallError)
+	if !ok {
+		return false
+	}
+	return t.Type == e.Type && (ERCOk == t.Code || t.Code == e.Code)
+}
+
+func newError(t ErrorType, format string, a ...interface{}) *CallError {
+	return &CallError{Type: t, Err: fmt.Errorf(format, a...)}
+}
+
+// contextError wraps context.Canceled or context.DeadlineExceeded, so both ErrCancelled and them are matched
+func contextError(ctx context.Context) *CallError {
+	if nil == ctx.Err() {
+		return nil
+	}
+	return &CallError{Type: ECancelled, Err: ctx.Err()}
+}
+
+// toPanic converts an error of the error-returning api into the panic value of the panicking one
+func toPanic(err error) Error {
+	var callError *CallError
+	if errors.As(err, &callError) {
+		return Error{Error: err, Type: callError.Type, Code: byte(callError.Code)}
+	}
+	return Error{Error: err, Type: EGeneral}
+}
+
+// RecoveredError converts the recovered panic of any origin into an error
+func RecoveredError(r interface{}) error {
+	switch v := r.(type) {
+	case Error:
+		return &CallError{Type: v.Type, Code: EResponseCode(v.Code), Err: v.Error}
+	case error:
+		return &CallError{Type: EGeneral, Err: v}
+	}
+	return &CallError{Type: EGeneral, Err: fmt.Errorf("%v", r)}
+}
+
 func Dump(b []byte) string {
 	var ret string
 	for i := range b {
@@ -35,24 +114,29 @@ func Dump(b []byte) string {
 	return ret
 }
 
-func ParseAddress(s string) (ret DeviceAddress) {
-	for index, bStr := range strings.Split(s, ":") {
-		if index >= len(ret) {
-			panic(Error{
-				Error: fmt.Errorf("RFModel.ParseAddress: too long address; "),
-				Type:  EGeneral,
-			})
-		}
+// ParseAddress panics on malformed address, see TryParseAddress
+func ParseAddress(s string) DeviceAddress {
+	ret, err := TryParseAddress(s)
+	if nil != err {
+		panic(toPanic(err))
+	}
+	return ret
+}
+
+// TryParseAddress parses "AA:AA:AA:AA:01" address
+func TryParseAddress(s string) (ret DeviceAddress, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != len(ret) {
+		return ret, newError(EBadParameter, "RFModel.TryParseAddress(%v): address has to be of %v bytes; ", s, len(ret))
+	}
+	for index, bStr := range parts {
 		b, err := strconv.ParseUint(bStr, 16, 8)
 		if nil != err {
-			panic(Error{
-				Error: fmt.Errorf("RFModel.ParseAddress: strconv.ParseUInt: %v; ", err.Error()),
-				Type:  EGeneral,
-			})
+			return ret, newError(EBadParameter, "RFModel.TryParseAddress(%v): strconv.ParseUInt: %v; ", s, err.Error())
 		}
 		ret[index] = byte(b)
 	}
-	return ret
+	return ret, nil
 }
 
 func AddressToString(a DeviceAddress) (ret string) {
@@ -0,0 +1,102 @@
+package RFModel
+
+import (
+	"context"
+
+	"../TranscieverModel"
+)
+
+// FunctionInfo is the unit function as the device reports it
+type FunctionInfo struct {
+	FNo   FuncNo
+	Read  EDataType
+	Write EDataType
+}
+
+// SetDeviceAddress moves the device to the new address with F0SetMACAddress
+// the device answers from the old address and works on the new one after that
+func (rf *RFModel) SetDeviceAddress(ctx context.Context, address DeviceAddress, newAddress DeviceAddress) error {
+	if _, err := rf.CallFunctionContext(ctx, UID{Address: address, Unit: 0}, F0SetMACAddress, newAddress[:]); nil != err {
+		return err
+	}
+	// the device keeps counting transactions
+	old := getDevice(address)
+	moved := getDevice(newAddress)
+	old.transactionLock.Lock()
+	counter := old.transactionCounter
+	old.transactionLock.Unlock()
+	moved.transactionLock.Lock()
+	moved.transactionCounter = counter
+	moved.transactionLock.Unlock()
+	return nil
+}
+
+// SetDeviceRFChannel switches the device to the channel with F0SetRFChannel
+// the device answers on the old channel and works on the new one after that
+func (rf *RFModel) SetDeviceRFChannel(ctx context.Context, address DeviceAddress, channel byte) error {
+	_, err := rf.CallFunctionContext(ctx, UID{Address: address, Unit: 0}, F0SetRFChannel, []byte{channel})
+	return err
+}
+
+// UnitCount asks the device how many units it has, unit 0 excluded
+func (rf *RFModel) UnitCount(ctx context.Context, address DeviceAddress) (int, error) {
+	response, err := rf.CallFunctionContext(ctx, UID{Address: address, Unit: 0}, FGetListOfUnitFunctions, []byte{})
+	if nil != err {
+		return 0, err
+	}
+	return parseUnitCount(response, address)
+}
+
+// ListFunctions asks the unit for its functions and their data types
+func (rf *RFModel) ListFunctions(ctx context.Context, uid UID) ([]FunctionInfo, error) {
+	response, err := rf.CallFunctionContext(ctx, uid, FGetListOfUnitFunctions, []byte{})
+	if nil != err {
+		return nil, err
+	}
+	return parseFunctionList(response, uid)
+}
+
+// UnitDescription asks the unit for its text description
+func (rf *RFModel) UnitDescription(ctx context.Context, uid UID) (string, error) {
+	response, err := rf.CallFunctionContext(ctx, uid, FGetTextDescription, []byte{})
+	if nil != err {
+		return "", err
+	}
+	return string(response), nil
+}
+
+func parseUnitCount(response TranscieverModel.Payload, address DeviceAddress) (int, error) {
+	// validation of the request. Don't like that huge chunk here it has to go somewhere else(
+	if 5 != len(response) {
+		return 0, newError(
+			EBadResponse,
+			"incorrect response %v from the device %v Unit 0 function get number of internal units %v",
+			response,
+			address,
+			FGetListOfUnitFunctions,
+		)
+	}
+	return int(response[0]), nil
+}
+
+func parseFunctionList(response TranscieverModel.Payload, uid UID) ([]FunctionInfo, error) {
+	// fucking validation, it should go somewhere else(
+	if 0 != len(response)%2 {
+		return nil, newError(
+			EBadResponse,
+			"incorect rsponse %v from the Unit %v function get list of Unit functions %v",
+			response,
+			uid,
+			FGetListOfUnitFunctions,
+		)
+	}
+	ret := make([]FunctionInfo, 0, len(response)/2)
+	for f := 0; f < len(response); f += 2 {
+		ret = append(ret, FunctionInfo{
+			FNo:   FuncNo(response[f]),
+			Read:  EDataType(response[f+1] >> 4),
+			Write: EDataType(response[f+1] & 0x0F),
+		})
+	}
+	return ret, nil
+}
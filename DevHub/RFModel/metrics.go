@@ -0,0 +1,31 @@
+package RFModel
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// prometheus metrics of CallFunction, device label is its address
+var (
+	callAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "devhub", Subsystem: "rf", Name: "call_attempts_total",
+		Help: "Requests sent to the device, retries included.",
+	}, []string{"device"})
+	callRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "devhub", Subsystem: "rf", Name: "call_retries_total",
+		Help: "Requests repeated after no or invalid response.",
+	}, []string{"device"})
+	callTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "devhub", Subsystem: "rf", Name: "call_timeouts_total",
+		Help: "Calls failed without a response after all the retries.",
+	}, []string{"device"})
+	badCodeResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "devhub", Subsystem: "rf", Name: "bad_code_responses_total",
+		Help: "Calls answered with not ok response code.",
+	}, []string{"device", "code"})
+	callDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "devhub", Subsystem: "rf", Name: "call_duration_seconds",
+		Help:    "Duration of the calls, retries and resyncs included.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"device"})
+)
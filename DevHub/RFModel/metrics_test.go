@@ -0,0 +1,48 @@
+package RFModel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"../TranscieverModel"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// silentTransmitter never gets a response
+type silentTransmitter struct{}
+
+func (t silentTransmitter) Close() {}
+func (t silentTransmitter) SendCommand(a TranscieverModel.Address, data TranscieverModel.Payload) TranscieverModel.Message {
+	return TranscieverModel.Message{Address: a, Status: TranscieverModel.EMSSlaveTimeout}
+}
+
+func TestCallMetrics(t *testing.T) {
+	var rf RFModel
+	Init(&rf, silentTransmitter{})
+	uid := UID{Address: DeviceAddress{0xAA, 0xAA, 0xAA, 0xAA, 0x7F}, Unit: 1}
+	device := AddressToString(uid.Address)
+	if _, err := rf.CallFunctionContext(context.Background(), uid, 0x10, nil); !errors.Is(err, ErrDeviceTimeout) {
+		t.Fatalf("call error is %v", err)
+	}
+	if v := testutil.ToFloat64(callAttempts.WithLabelValues(device)); 4 != v {
+		t.Errorf("attempts are %v", v)
+	}
+	if v := testutil.ToFloat64(callRetries.WithLabelValues(device)); 3 != v {
+		t.Errorf("retries are %v", v)
+	}
+	if v := testutil.ToFloat64(callTimeouts.WithLabelValues(device)); 1 != v {
+		t.Errorf("timeouts are %v", v)
+	}
+	if 1 != testutil.CollectAndCount(callDuration) {
+		t.Errorf("no call duration")
+	}
+	// single try
+	uid.Address[4]++
+	if _, err := rf.callFunction(context.Background(), uid, 0x10, nil, 1); !errors.Is(err, ErrDeviceTimeout) {
+		t.Fatalf("single try call error is %v", err)
+	}
+	if v := testutil.ToFloat64(callAttempts.WithLabelValues(AddressToString(uid.Address))); 1 != v {
+		t.Errorf("attempts of the single try call are %v", v)
+	}
+}
@@ -0,0 +1,171 @@
+package RFModel
+
+// Secure mode of the device is enabled by a pre-shared key, other devices talk plaintext.
+//
+// Session is established with unit 0 F0SetNewSessionKey, the only plaintext request the secure device accepts:
+//   request data is host nonce, response data is device nonce and device proof
+//   proof = HMAC-SHA256(psk, "proof" | host nonce | device nonce | transaction id)[:NonceLength]
+//   session key = HMAC-SHA256(psk, "session" | host nonce | device nonce)[:KeyLength]
+//   session counter starts at the transaction id of the handshake request
+// Every other request and response data is AES-128-CTR ciphertext followed by MAC:
+//   iv = direction | counter (LE) | zeros
+//   MAC = HMAC-SHA256(session key, direction | counter (LE) | packet header | ciphertext)[:MACLength]
+// Transaction id in the header is the low byte of the 32-bit session counter, which both sides keep.
+// Device accepts the next counter or the last one, answering the last one with the cached response,
+// so any recorded packet fails MAC check with all the other counters and replay does not reach the unit.
+// Failed MAC, plaintext request and unexpected counter are answered in plaintext with
+// ERCChValidationFailed, ERCChBadPermissions and ERCNotConsecutiveTransactionId, and make a new session.
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	KeyLength   = 16
+	NonceLength = 8
+	MACLength   = 4
+	// data length limits of the secure packets
+	MaxSecureDataLengthRq = MaxDataLengthRq - MACLength
+	MaxSecureDataLengthRs = MaxDataLengthRs - MACLength
+	// new session is made before the counter gets anywhere close to wrapping
+	maxSessionCounter = 1 << 24
+)
+
+// Direction of the packet, to make request and response key streams different
+const (
+	DirectionRequest  byte = 1
+	DirectionResponse byte = 2
+)
+
+// ParseKey parses pre-shared key of hex format
+func ParseKey(s string) ([]byte, error) {
+	ret, err := hex.DecodeString(s)
+	if nil != err {
+		return nil, newError(EBadParameter, "RFModel.ParseKey: %v; ", err)
+	}
+	if KeyLength != len(ret) {
+		return nil, newError(EBadParameter, "RFModel.ParseKey: key has to be of %v bytes, got %v; ", KeyLength, len(ret))
+	}
+	return ret, nil
+}
+
+// SetDeviceKey enables secure mode for the device, nil key disables it
+func (rf *RFModel) SetDeviceKey(address DeviceAddress, key []byte) error {
+	if nil != key && KeyLength != len(key) {
+		return newError(EBadParameter, "RFModel.SetDeviceKey(%v): key has to be of %v bytes; ", AddressToString(address), KeyLength)
+	}
+	d := getDevice(address)
+	d.transactionLock.Lock()
+	defer d.transactionLock.Unlock()
+	d.key = nil
+	if nil != key {
+		d.key = append([]byte{}, key...)
+	}
+	d.sessionKey = nil
+	return nil
+}
+
+func mac(key []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+func deriveSessionKey(psk []byte, hostNonce []byte, deviceNonce []byte) []byte {
+	return mac(psk, []byte("session"), hostNonce, deviceNonce)[:KeyLength]
+}
+
+func deviceProof(psk []byte, hostNonce []byte, deviceNonce []byte, transactionID byte) []byte {
+	return mac(psk, []byte("proof"), hostNonce, deviceNonce, []byte{transactionID})[:NonceLength]
+}
+
+func counterBytes(direction byte, counter uint32) []byte {
+	ret := []byte{direction, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(ret[1:], counter)
+	return ret
+}
+
+func xorKeyStream(sessionKey []byte, direction byte, counter uint32, data []byte) []byte {
+	block, err := aes.NewCipher(sessionKey)
+	if nil != err {
+		// key length is always checked before
+		panic(fmt.Errorf("RFModel.xorKeyStream: aes.NewCipher: %v; ", err))
+	}
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, counterBytes(direction, counter))
+	ret := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(ret, data)
+	return ret
+}
+
+// sealPayload encrypts data and appends MAC over the packet header and the ciphertext
+func sealPayload(sessionKey []byte, direction byte, counter uint32, header []byte, data []byte) []byte {
+	ret := xorKeyStream(sessionKey, direction, counter, data)
+	return append(ret, mac(sessionKey, counterBytes(direction, counter), header, ret)[:MACLength]...)
+}
+
+// openPayload checks MAC and decrypts data sealed by sealPayload
+func openPayload(sessionKey []byte, direction byte, counter uint32, header []byte, sealed []byte) ([]byte, bool) {
+	if MACLength > len(sealed) {
+		return nil, false
+	}
+	ciphertext := sealed[:len(sealed)-MACLength]
+	expected := mac(sessionKey, counterBytes(direction, counter), header, ciphertext)[:MACLength]
+	if !hmac.Equal(expected, sealed[len(ciphertext):]) {
+		return nil, false
+	}
+	return xorKeyStream(sessionKey, direction, counter, ciphertext), true
+}
+
+// isSessionFailure tells if the plaintext response of the secure device means it has no session with us
+func isSessionFailure(code EResponseCode) bool {
+	switch code {
+	case ERCChValidationFailed, ERCChBadPermissions, ERCNotConsecutiveTransactionId:
+		return true
+	}
+	return false
+}
+
+// establishSession makes a new session key with the device, device transactionLock should be locked
+func (rf *RFModel) establishSession(ctx context.Context, d *Device) error {
+	log.Info(fmt.Sprintf("RFModel.establishSession: device %v", AddressToString(d.Address)))
+	d.sessionKey = nil
+	hostNonce := make([]byte, NonceLength)
+	if _, err := rand.Read(hostNonce); nil != err {
+		return newError(EGeneral, "RFModel.establishSession: rand.Read: %v; ", err)
+	}
+	transactionID := byte(d.transactionCounter)
+	pm, err := rf.transaction(ctx, d, UID{Address: d.Address, Unit: 0}, F0SetNewSessionKey, hostNonce, callTries)
+	if nil != err {
+		return err
+	}
+	if 0 != pm.Code {
+		return &CallError{
+			Type: EBadCode,
+			Code: EResponseCode(pm.Code),
+			Err:  fmt.Errorf("RFModel.establishSession(%v) bad error code 0x%X; ", AddressToString(d.Address), pm.Code),
+		}
+	}
+	data := pm.Payload()
+	if 2*NonceLength != len(data) {
+		return newError(ESecurity, "RFModel.establishSession(%v): bad response length %v; ", AddressToString(d.Address), len(data))
+	}
+	deviceNonce := data[:NonceLength]
+	if !hmac.Equal(deviceProof(d.key, hostNonce, deviceNonce, transactionID), data[NonceLength:]) {
+		return newError(ESecurity, "RFModel.establishSession(%v): device proof does not match the key; ", AddressToString(d.Address))
+	}
+	d.sessionKey = deriveSessionKey(d.key, hostNonce, deviceNonce)
+	// device knows only the low byte of the counter
+	d.transactionCounter = uint32(transactionID) + 1
+	return nil
+}
@@ -0,0 +1,33 @@
+package RFModel
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealOpenPayload(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeyLength)
+	header := []byte{0, 5, 1, 0x11}
+	data := []byte{1, 2, 3}
+	sealed := sealPayload(key, DirectionRequest, 0x105, header, data)
+	Assert(t, len(data)+MACLength == len(sealed), "sealed payload length is wrong")
+	Assert(t, !bytes.Equal(data, sealed[:len(data)]), "payload is not encrypted")
+	opened, ok := openPayload(key, DirectionRequest, 0x105, header, sealed)
+	Assert(t, ok && bytes.Equal(data, opened), "payload does not open")
+	_, ok = openPayload(key, DirectionRequest, 0x5, header, sealed)
+	Assert(t, !ok, "payload opens with another counter of the same transaction id")
+	_, ok = openPayload(key, DirectionResponse, 0x105, header, sealed)
+	Assert(t, !ok, "payload opens in another direction")
+	_, ok = openPayload(key, DirectionRequest, 0x105, []byte{0, 5, 1, 0x13}, sealed)
+	Assert(t, !ok, "payload opens with another header")
+	sealed[0] ^= 1
+	_, ok = openPayload(key, DirectionRequest, 0x105, header, sealed)
+	Assert(t, !ok, "tampered payload opens")
+}
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey("000102030405060708090a0b0c0d0e0f")
+	Assert(t, nil == err && KeyLength == len(key) && 0x0F == key[15], "key is not parsed")
+	_, err = ParseKey("0001")
+	Assert(t, nil != err, "short key is parsed")
+}
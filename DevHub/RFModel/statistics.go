@@ -0,0 +1,98 @@
+package RFModel
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"../TranscieverModel"
+)
+
+// DeviceStatistics is unit 0 F0GetDeviceStatistics response
+// response is little endian fields in the order of the struct, firmware may return only a beginning of it
+type DeviceStatistics struct {
+	BuildNumber uint32
+	Uptime      time.Duration // seconds on the wire
+	// packets addressed to the device and its responses
+	RxPackets uint32
+	TxPackets uint32
+	// responses not acknowledged by the master
+	TxFailures uint16
+	// requests failed validation
+	RxErrors uint16
+	// requests with not consecutive transaction id
+	TransactionErrors uint16
+	// boots since flashing
+	Resets uint16
+	// when it was received
+	Time time.Time
+}
+
+// StatisticsLength is the length of the complete F0GetDeviceStatistics response
+const StatisticsLength = 24
+
+// EncodeDeviceStatistics makes F0GetDeviceStatistics response out of the statistics
+func EncodeDeviceStatistics(s DeviceStatistics) []byte {
+	ret := make([]byte, StatisticsLength)
+	binary.LittleEndian.PutUint32(ret[0:], s.BuildNumber)
+	binary.LittleEndian.PutUint32(ret[4:], uint32(s.Uptime/time.Second))
+	binary.LittleEndian.PutUint32(ret[8:], s.RxPackets)
+	binary.LittleEndian.PutUint32(ret[12:], s.TxPackets)
+	binary.LittleEndian.PutUint16(ret[16:], s.TxFailures)
+	binary.LittleEndian.PutUint16(ret[18:], s.RxErrors)
+	binary.LittleEndian.PutUint16(ret[20:], s.TransactionErrors)
+	binary.LittleEndian.PutUint16(ret[22:], s.Resets)
+	return ret
+}
+
+func parseDeviceStatistics(response TranscieverModel.Payload, address DeviceAddress) (ret DeviceStatistics, err error) {
+	// build number is the least any firmware reports
+	if 4 > len(response) || StatisticsLength < len(response) {
+		return ret, newError(
+			EBadResponse,
+			"incorrect response %v from the device %v Unit 0 function get device statistics %v",
+			response, AddressToString(address), F0GetDeviceStatistics,
+		)
+	}
+	data := make([]byte, StatisticsLength)
+	copy(data, response)
+	ret.BuildNumber = binary.LittleEndian.Uint32(data[0:])
+	ret.Uptime = time.Duration(binary.LittleEndian.Uint32(data[4:])) * time.Second
+	ret.RxPackets = binary.LittleEndian.Uint32(data[8:])
+	ret.TxPackets = binary.LittleEndian.Uint32(data[12:])
+	ret.TxFailures = binary.LittleEndian.Uint16(data[16:])
+	ret.RxErrors = binary.LittleEndian.Uint16(data[18:])
+	ret.TransactionErrors = binary.LittleEndian.Uint16(data[20:])
+	ret.Resets = binary.LittleEndian.Uint16(data[22:])
+	ret.Time = time.Now()
+	return ret, nil
+}
+
+// DeviceStatistics returns statistics of the device, asking the device if known ones are older than maxAge
+func (rf *RFModel) DeviceStatistics(ctx context.Context, address DeviceAddress, maxAge time.Duration) (DeviceStatistics, error) {
+	rfLock.Lock()
+	defer rfLock.Unlock()
+	device := getDevice(address)
+	if maxAge > time.Now().Sub(device.Statistics.Time) {
+		return device.Statistics, nil
+	}
+	if err := updateDeviceStatistics(ctx, rf, device); nil != err {
+		return device.Statistics, err
+	}
+	return device.Statistics, nil
+}
+
+// updateDeviceStatistics asks the device for statistics, rfLock should be locked
+func updateDeviceStatistics(ctx context.Context, rf *RFModel, device *Device) error {
+	response, err := rf.CallFunctionContext(ctx, UID{Address: device.Address, Unit: 0}, F0GetDeviceStatistics, []byte{})
+	if nil != err {
+		return err
+	}
+	statistics, err := parseDeviceStatistics(response, device.Address)
+	if nil != err {
+		return err
+	}
+	device.Statistics = statistics
+	device.BuildNumber = statistics.BuildNumber
+	return nil
+}
@@ -0,0 +1,206 @@
+// Radio opens the transmitter of settings.ini for the hub and the tools, which talk to the devices
+package Radio
+
+import (
+	"fmt"
+	"strings"
+
+	"../NRFTransciever"
+	"../RFModel"
+	"../SimTransciever"
+	"../TranscieverModel"
+	"../UartTransciever"
+	"gopkg.in/ini.v1"
+)
+
+func wrapErrPanic(value RFModel.Variant, err error) RFModel.Variant {
+	if nil == err {
+		return value
+	}
+	panic(err)
+}
+
+// Open opens the transmitter of settings, puts it under the model and switches it to the configured RF channel
+func Open(settings *ini.File, model *RFModel.RFModel) (ret TranscieverModel.Transmitter) {
+	switch settings.Section("").Key("rf model").In("nrf", []string{"nrf", "uart master", "sim"}) {
+	case "nrf":
+		var transmitter NRFTransciever.NRFTransmitter
+		NRFTransciever.Init(&transmitter, NRFTransciever.TransmitterSettings{
+			PortName:      settings.Section("nrf").Key("port").String(),
+			IrqName:       settings.Section("nrf").Key("irq").String(),
+			CEName:        settings.Section("nrf").Key("ce").String(),
+			Speed:         float32(wrapErrPanic(settings.Section("nrf").Key("speed").Float64()).(float64)),
+			PollPeriod:    settings.Section("nrf").Key("poll period").MustDuration(0),
+			TxTimeout:     settings.Section("nrf").Key("tx timeout").MustDuration(0),
+			ListenTimeout: settings.Section("nrf").Key("listen timeout").MustDuration(0),
+			Radio:         nrfRadio(settings),
+		})
+		RFModel.Init(model, &transmitter)
+		ret = &transmitter
+	case "uart master":
+		var transmitter UartTransciever.UMTransmitter
+		UartTransciever.Init(&transmitter, UartTransciever.TransmitterSettings{
+			PortName: settings.Section("uart master").Key("port").String(),
+			Speed:    wrapErrPanic(settings.Section("uart master").Key("speed").Int()).(int),
+		})
+		if err := configureModem(settings, &transmitter); nil != err {
+			transmitter.Close()
+			panic(err)
+		}
+		RFModel.Init(model, &transmitter)
+		ret = &transmitter
+	case "sim":
+		var transmitter SimTransciever.SimTransmitter
+		SimTransciever.Init(&transmitter, SimTransciever.TransmitterSettings{
+			DevicesFile: settings.Section("sim").Key("devices").String(),
+			Latency:     settings.Section("sim").Key("latency").MustDuration(0),
+		})
+		RFModel.Init(model, &transmitter)
+		ret = &transmitter
+	}
+	// transmitters start on the default channel, only the configured one is set
+	if settings.Section("").HasKey("rf channel") {
+		if err := SetChannel(ret, byte(settings.Section("").Key("rf channel").MustInt(0))); nil != err {
+			panic(err)
+		}
+	}
+	return ret
+}
+
+// nrfRadio takes the radio settings of [nrf], missing keys are defaults, bad ones panic before the port is opened
+// channel is the one of [nrf] or "rf channel" of the devhub
+func nrfRadio(settings *ini.File) NRFTransciever.RadioSettings {
+	section := settings.Section("nrf")
+	ret := NRFTransciever.DefaultRadioSettings()
+	var problems []string
+	check := func(key string, err error) {
+		if nil != err {
+			problems = append(problems, fmt.Sprintf("%v: %v", key, err))
+		}
+	}
+	byteNumber := func(key *ini.Key, value *byte) {
+		n, err := key.Uint()
+		if nil == err && 255 < n {
+			err = fmt.Errorf("%v is not a byte", n)
+		}
+		check(key.Name(), err)
+		*value = byte(n)
+	}
+	if settings.Section("").HasKey("rf channel") {
+		byteNumber(settings.Section("").Key("rf channel"), &ret.Channel)
+	}
+	if section.HasKey("channel") {
+		channel := ret.Channel
+		byteNumber(section.Key("channel"), &ret.Channel)
+		if settings.Section("").HasKey("rf channel") && channel != ret.Channel {
+			problems = append(problems, fmt.Sprintf("channel %v differs from \"rf channel\" %v", ret.Channel, channel))
+		}
+	}
+	if section.HasKey("data rate") {
+		var err error
+		ret.DataRate, err = NRFTransciever.ParseDataRate(section.Key("data rate").String())
+		check("data rate", err)
+	}
+	if section.HasKey("power") {
+		var err error
+		ret.Power, err = section.Key("power").Int()
+		check("power", err)
+	}
+	if section.HasKey("crc") {
+		byteNumber(section.Key("crc"), &ret.CRCLength)
+	}
+	if section.HasKey("auto ack") {
+		var err error
+		ret.AutoAck, err = section.Key("auto ack").Bool()
+		check("auto ack", err)
+	}
+	if section.HasKey("retransmit delay") {
+		var err error
+		ret.RetransmitDelay, err = section.Key("retransmit delay").Duration()
+		check("retransmit delay", err)
+	}
+	if section.HasKey("retransmit count") {
+		byteNumber(section.Key("retransmit count"), &ret.RetransmitCount)
+	}
+	if 0 == len(problems) {
+		if err := ret.Validate(); nil != err {
+			problems = append(problems, err.Error())
+		}
+	}
+	if 0 != len(problems) {
+		panic(fmt.Errorf("Radio.Open: [nrf] settings: %v", strings.Join(problems, "; ")))
+	}
+	return ret
+}
+
+// configureModem sets the radio of [uart master] keys, the modem keeps its own settings for missing ones
+// channel is the one of [uart master] or "rf channel" of the devhub, which is set by Open
+func configureModem(settings *ini.File, tr *UartTransciever.UMTransmitter) error {
+	section := settings.Section("uart master")
+	if section.HasKey("channel") {
+		channel, err := section.Key("channel").Uint()
+		if nil == err && 255 < channel {
+			err = fmt.Errorf("%v is not a byte", channel)
+		}
+		if nil == err && settings.Section("").HasKey("rf channel") && settings.Section("").Key("rf channel").MustUint(0) != channel {
+			err = fmt.Errorf("%v differs from \"rf channel\"", channel)
+		}
+		if nil == err {
+			err = tr.SetRFChannel(byte(channel))
+		}
+		if nil != err {
+			return fmt.Errorf("Radio.Open: [uart master] channel: %v", err)
+		}
+	}
+	if section.HasKey("power") {
+		power, err := section.Key("power").Int()
+		if nil == err {
+			err = tr.SetTxPower(power)
+		}
+		if nil != err {
+			return fmt.Errorf("Radio.Open: [uart master] power: %v", err)
+		}
+	}
+	if section.HasKey("bit rate") {
+		rate, err := UartTransciever.ParseBitRate(section.Key("bit rate").String())
+		if nil == err {
+			err = tr.SetBitRate(rate)
+		}
+		if nil != err {
+			return fmt.Errorf("Radio.Open: [uart master] bit rate: %v", err)
+		}
+	}
+	if section.HasKey("retransmit delay") {
+		delay, err := section.Key("retransmit delay").Duration()
+		if nil == err {
+			err = tr.SetAutoRetransmitDelay(delay)
+		}
+		if nil != err {
+			return fmt.Errorf("Radio.Open: [uart master] retransmit delay: %v", err)
+		}
+	}
+	if section.HasKey("retransmit count") {
+		count, err := section.Key("retransmit count").Uint()
+		if nil == err && 255 < count {
+			err = fmt.Errorf("%v is not a byte", count)
+		}
+		if nil == err {
+			err = tr.SetAutoRetransmitCount(byte(count))
+		}
+		if nil != err {
+			return fmt.Errorf("Radio.Open: [uart master] retransmit count: %v", err)
+		}
+	}
+	return nil
+}
+
+// SetChannel switches the transmitter channel, transmitters without channels work on the default one
+func SetChannel(transmitter TranscieverModel.Transmitter, channel byte) error {
+	if setter, ok := transmitter.(TranscieverModel.ChannelSetter); ok {
+		return setter.SetRFChannel(channel)
+	}
+	if TranscieverModel.DefaultRFChannel != channel {
+		return fmt.Errorf("the transmitter can not switch to RF channel %v", channel)
+	}
+	return nil
+}
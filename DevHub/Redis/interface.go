@@ -1,5 +1,7 @@
 // Redis translates components to redis database
-// we're using database 0, key is stringified UID+FNo, value is plain value for now, no json yet
+// key is stringified UID+FNo, value is plain value
+// or json of value, typed value, device state, update time and read error in json value format
+// optionally every changed value is appended to "key|history" stream with its time and device state
 package Redis
 
 import (
@@ -8,29 +10,179 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/sirupsen/logrus"
 	"os"
+	"sync"
+	"time"
 	//"github.com/flynn/json5"
 	"../OutsideInterface"
 )
 
 var log = logrus.New()
 
+// Value formats
+const (
+	VFPlain = "plain"
+	VFJson  = "json"
+)
+
+type Settings struct {
+	Server      string
+	DB          int
+	ValueFormat string // VFPlain or VFJson
+	// entries of the history stream of every component, 0 disables history
+	HistoryLength int64
+}
+
 type Interface struct {
-	db          *redis.Client
-	ctx         context.Context
-	databaseNum int
+	db            *redis.Client
+	ctx           context.Context
+	databaseNum   int
+	valueFormat   string
+	historyLength int64
+	// json value format and history only
+	mutex        sync.Mutex
+	components   map[string]*component
+	deviceStates map[string]string
+	// subscriptions of the writable keys
+	subscriptions map[string]*subscription
+}
+
+type subscription struct {
+	pubsub *redis.PubSub
+	// closed on unregister, the goroutine does not wait for its channel to be read then
+	done chan struct{}
 }
 
-func Init(self *Interface, address string, db int) {
+func (s *subscription) close() {
+	_ = s.pubsub.Close()
+	close(s.done)
+}
+
+func Init(self *Interface, settings Settings) {
 	log.Formatter = new(logrus.TextFormatter)
 	log.Level = logrus.DebugLevel
 	log.Out = os.Stdout
-	self.db = redis.NewClient(&redis.Options{Addr: address})
+	initClient(self, redis.NewClient(&redis.Options{Addr: settings.Server, DB: settings.DB}), settings)
+}
+
+func initClient(self *Interface, client *redis.Client, settings Settings) {
+	self.db = client
 	self.ctx = context.Background()
-	self.databaseNum = db
+	self.databaseNum = settings.DB
+	self.valueFormat = settings.ValueFormat
+	self.historyLength = settings.HistoryLength
+	if "" == self.valueFormat {
+		self.valueFormat = VFPlain
+	}
+	if VFPlain != self.valueFormat && VFJson != self.valueFormat {
+		panic(fmt.Errorf("Redis.Init: unknown value format <%v>; ", settings.ValueFormat))
+	}
+	self.components = make(map[string]*component)
+	self.deviceStates = make(map[string]string)
+	self.subscriptions = make(map[string]*subscription)
 }
 
 func (i *Interface) UpdateComponent(key string, value string) {
-	i.db.Set(i.ctx, key, value, 0)
+	if VFPlain == i.valueFormat && 0 == i.historyLength {
+		i.db.Set(i.ctx, key, value, 0)
+		return
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	c := i.component(key)
+	changed := c.updated.IsZero() || value != c.value
+	c.value = value
+	c.err = ""
+	c.updated = time.Now()
+	if VFPlain == i.valueFormat {
+		i.db.Set(i.ctx, key, value, 0)
+	} else {
+		i.setJson(key, c)
+	}
+	if changed && 0 < i.historyLength {
+		i.appendHistory(key, c)
+	}
+}
+
+// UpdateComponentError keeps the last value in json value format, plain one gets the error text instead
+func (i *Interface) UpdateComponentError(key string, reason string) {
+	if VFPlain == i.valueFormat {
+		i.db.Set(i.ctx, key, reason, 0)
+		return
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	c := i.component(key)
+	c.err = reason
+	i.setJson(key, c)
+}
+
+// DescribeComponent remembers the data type for the typed value
+func (i *Interface) DescribeComponent(key string, info OutsideInterface.ComponentInfo) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if "" != info.DataType {
+		i.component(key).dataType = info.DataType
+	}
+}
+
+// UpdateDeviceState rewrites json values of the device with its new state
+func (i *Interface) UpdateDeviceState(device string, state string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.deviceStates[device] = state
+	if VFPlain == i.valueFormat {
+		return
+	}
+	for key, c := range i.components {
+		// only the keys, which were written already
+		if device == deviceAddress(key) && (!c.updated.IsZero() || "" != c.err) {
+			i.setJson(key, c)
+		}
+	}
+}
+
+// component finds or creates json value format component, mutex should be locked
+func (i *Interface) component(key string) *component {
+	c, ok := i.components[key]
+	if !ok {
+		c = &component{}
+		i.components[key] = c
+	}
+	return c
+}
+
+// appendHistory adds the component value to its history stream, trimming it to the history length
+// mutex should be locked
+func (i *Interface) appendHistory(key string, c *component) {
+	state := i.deviceStates[deviceAddress(key)]
+	if "" == state {
+		state = OutsideInterface.DSOffline
+	}
+	err := i.db.XAdd(i.ctx, &redis.XAddArgs{
+		Stream: key + "|history",
+		MaxLen: i.historyLength,
+		Values: map[string]interface{}{
+			"value": c.value,
+			"state": state,
+			"time":  c.updated.Format(time.RFC3339Nano),
+		},
+	}).Err()
+	if nil != err {
+		log.Warning(fmt.Sprintf("Redis.appendHistory(%s): %v", key, err))
+	}
+}
+
+// setJson writes json value of the component, mutex should be locked
+func (i *Interface) setJson(key string, c *component) {
+	i.db.Set(i.ctx, key, c.encode(i.deviceStates[deviceAddress(key)]), 0)
+}
+
+// UpdateWriteState sets "key|status" to the write state of the writable key, with the reason if it is failed
+func (i *Interface) UpdateWriteState(key string, state string, reason string) {
+	if "" != reason {
+		state += ": " + reason
+	}
+	i.db.Set(i.ctx, key+"|status", state, 0)
 }
 
 func (i *Interface) RegisterWritableComponent(key string) <-chan OutsideInterface.SubMessage {
@@ -42,6 +194,13 @@ func (i *Interface) RegisterWritableComponent(key string) <-chan OutsideInterfac
 	if err != nil {
 		panic(err)
 	}
+	done := make(chan struct{})
+	i.mutex.Lock()
+	if previous, ok := i.subscriptions[key]; ok {
+		previous.close()
+	}
+	i.subscriptions[key] = &subscription{pubsub: pubsub, done: done}
+	i.mutex.Unlock()
 	// Go channel which receives messages.
 	ch := pubsub.Channel()
 	// buffered since we might push initial data into it before returning it
@@ -55,9 +214,10 @@ func (i *Interface) RegisterWritableComponent(key string) <-chan OutsideInterfac
 					panic(err)
 				}
 				log.Debug(fmt.Sprintf("Redis.RegisterWritableComponent(%s) goroutine: value is <%s>", key, value))
-				ret <- OutsideInterface.SubMessage{
-					Value: value,
-					Key:   key,
+				select {
+				case ret <- OutsideInterface.SubMessage{Value: value, Key: key}:
+				case <-done:
+					return
 				}
 			}
 		}
@@ -77,3 +237,17 @@ func (i *Interface) RegisterWritableComponent(key string) <-chan OutsideInterfac
 	}
 	return ret
 }
+
+// UnregisterComponent closes the subscription of the writable key and forgets the component
+// values are left in the database as they are
+func (i *Interface) UnregisterComponent(key string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if s, ok := i.subscriptions[key]; ok {
+		// its channel is closed and the goroutine returns, even if it waits for the write to be read
+		s.close()
+		delete(i.subscriptions, key)
+	}
+	delete(i.components, key)
+	i.db.Del(i.ctx, key+"|status")
+}
@@ -0,0 +1,76 @@
+package Redis
+
+import (
+	"context"
+	"testing"
+
+	"../OutsideInterface"
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+)
+
+func initTestInterface(t *testing.T, settings Settings) (*Interface, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	var i Interface
+	initClient(&i, redis.NewClient(&redis.Options{Addr: server.Addr()}), settings)
+	return &i, server
+}
+
+func TestPlainValue(t *testing.T) {
+	i, server := initTestInterface(t, Settings{})
+	i.UpdateComponent("AA:AA:AA:AA:01:01|10", "true")
+	i.UpdateWriteState("AA:AA:AA:AA:01:01|11", OutsideInterface.WSFailed, "timeout")
+	server.CheckGet(t, "AA:AA:AA:AA:01:01|10", "true")
+	server.CheckGet(t, "AA:AA:AA:AA:01:01|11|status", "failed: timeout")
+	if server.Exists("AA:AA:AA:AA:01:01|10|history") {
+		t.Error("history is written while disabled")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	i, _ := initTestInterface(t, Settings{HistoryLength: 2})
+	key := "AA:AA:AA:AA:01:01|14"
+	i.UpdateDeviceState("AA:AA:AA:AA:01", OutsideInterface.DSOnline)
+	i.UpdateComponent(key, "false")
+	// not changed
+	i.UpdateComponent(key, "false")
+	i.UpdateComponent(key, "true")
+	entries, err := i.db.XRange(context.Background(), key+"|history", "-", "+").Result()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(entries) || "false" != entries[0].Values["value"] || "true" != entries[1].Values["value"] {
+		t.Fatalf("history is %v", entries)
+	}
+	if OutsideInterface.DSOnline != entries[1].Values["state"] || "" == entries[1].Values["time"] {
+		t.Errorf("history entry is %v", entries[1])
+	}
+	// trimmed to the history length
+	i.UpdateComponent(key, "false")
+	entries, err = i.db.XRange(context.Background(), key+"|history", "-", "+").Result()
+	if nil != err || 2 != len(entries) || "true" != entries[0].Values["value"] {
+		t.Errorf("history after trimming is %v, error %v", entries, err)
+	}
+	if v, _ := i.db.Get(context.Background(), key).Result(); "false" != v {
+		t.Errorf("value is <%v>", v)
+	}
+}
+
+func TestJsonValueState(t *testing.T) {
+	i, _ := initTestInterface(t, Settings{ValueFormat: VFJson})
+	key := "AA:AA:AA:AA:02:01|12"
+	i.DescribeComponent(key, OutsideInterface.ComponentInfo{DataType: "string"})
+	i.UpdateComponent(key, "")
+	i.UpdateDeviceState("AA:AA:AA:AA:02", OutsideInterface.DSOnline)
+	v, err := i.db.Get(context.Background(), key).Result()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected := `{"value":"","typed":"","type":"string","state":"online","updated":"`; expected != v[:len(expected)] {
+		t.Errorf("json value is %v", v)
+	}
+}
@@ -0,0 +1,92 @@
+package Redis
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"../OutsideInterface"
+)
+
+// component is what json value mode knows about the key
+type component struct {
+	value    string
+	dataType string // RFModel.DataTypeNames name, empty while unknown
+	err      string
+	updated  time.Time
+}
+
+// jsonValue is the value of the key in json value mode
+type jsonValue struct {
+	Value string `json:"value"`
+	// value of json type according to the function data type, null if it does not parse
+	Typed   interface{} `json:"typed"`
+	Type    string      `json:"type,omitempty"`
+	State   string      `json:"state"`
+	Updated string      `json:"updated,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// deviceAddress cuts "device address:unit|function" key to the device address
+func deviceAddress(key string) string {
+	unitPart := key
+	if separator := strings.LastIndex(key, "|"); 0 <= separator {
+		unitPart = key[:separator]
+	}
+	if separator := strings.LastIndex(unitPart, ":"); 0 <= separator {
+		return unitPart[:separator]
+	}
+	return unitPart
+}
+
+// typedValue converts the value as Cache formats it to the json type of the data type
+func typedValue(dataType string, value string) interface{} {
+	switch dataType {
+	case "bool":
+		if v, err := strconv.ParseBool(value); nil == err {
+			return v
+		}
+	case "byte", "int32":
+		if v, err := strconv.ParseInt(value, 10, 64); nil == err {
+			return v
+		}
+	case "string":
+		return value
+	case "byte array":
+		// "[1 2 3]"
+		if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+			return nil
+		}
+		// numbers, []byte would be marshalled as base64 string
+		ret := []int{}
+		for _, b := range strings.Fields(value[1 : len(value)-1]) {
+			v, err := strconv.ParseUint(b, 10, 8)
+			if nil != err {
+				return nil
+			}
+			ret = append(ret, int(v))
+		}
+		return ret
+	}
+	return nil
+}
+
+// encode makes json value of the component, device state is OutsideInterface.DSOffline until known
+func (c *component) encode(state string) string {
+	if "" == state {
+		state = OutsideInterface.DSOffline
+	}
+	v := jsonValue{
+		Value: c.value,
+		Typed: typedValue(c.dataType, c.value),
+		Type:  c.dataType,
+		State: state,
+		Error: c.err,
+	}
+	if !c.updated.IsZero() {
+		v.Updated = c.updated.Format(time.RFC3339Nano)
+	}
+	ret, _ := json.Marshal(v)
+	return string(ret)
+}
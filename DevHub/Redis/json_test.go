@@ -0,0 +1,51 @@
+package Redis
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"../OutsideInterface"
+)
+
+func Test_typedValue(t *testing.T) {
+	tests := []struct {
+		dataType string
+		value    string
+		want     interface{}
+	}{
+		{"bool", "true", true},
+		{"int32", "-23", int64(-23)},
+		{"byte", "255", int64(255)},
+		{"string", "", ""},
+		{"byte array", "[1 2 255]", []int{1, 2, 255}},
+		{"byte array", "[]", []int{}},
+		{"int32", "Cache.performRead: return code is: 3; ", nil},
+		{"", "true", nil},
+	}
+	for _, tt := range tests {
+		if got := typedValue(tt.dataType, tt.value); !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("typedValue(%v, %v) = %#v", tt.dataType, tt.value, got)
+		}
+	}
+}
+
+func Test_componentEncode(t *testing.T) {
+	c := component{value: "23", dataType: "int32", updated: time.Now()}
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(c.encode("")), &v); nil != err {
+		t.Fatal(err)
+	}
+	if "23" != v["value"] || 23.0 != v["typed"] || OutsideInterface.DSOffline != v["state"] || nil != v["error"] || nil == v["updated"] {
+		t.Errorf("unexpected json value %v", v)
+	}
+	// empty string of the online device is not the same as the offline device any more
+	c = component{value: "", dataType: "string", err: "timeout"}
+	if got := c.encode(OutsideInterface.DSOnline); `{"value":"","typed":"","type":"string","state":"online","error":"timeout"}` != got {
+		t.Errorf("json value is %v", got)
+	}
+	if "AA:AA:AA:AA:01" != deviceAddress("AA:AA:AA:AA:01:01|10") {
+		t.Errorf("device address is %v", deviceAddress("AA:AA:AA:AA:01:01|10"))
+	}
+}
@@ -0,0 +1,236 @@
+package Rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"../Cache"
+)
+
+type deviceJson struct {
+	Address     string                              `json:"address"`
+	State       string                              `json:"state"`
+	Diagnostics json.RawMessage                     `json:"diagnostics,omitempty"`
+	Units       map[string]map[string]*functionJson `json:"units"`
+}
+
+type functionJson struct {
+	Type         string `json:"type,omitempty"`
+	Value        string `json:"value,omitempty"`
+	State        string `json:"state,omitempty"`
+	Updated      string `json:"updated,omitempty"`
+	AccessPeriod string `json:"access period,omitempty"`
+	Readable     bool   `json:"readable"`
+	Writeable    bool   `json:"writeable"`
+	WriteState   string `json:"write state,omitempty"`
+	WriteError   string `json:"write error,omitempty"`
+}
+
+// listDevices answers GET /devices with the tree by devices.json names
+func (i *Interface) listDevices(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method {
+		http.Error(w, "only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// cache calls the interface under its locks, so it is not called with the mutex locked
+	i.mutex.Lock()
+	cache := i.cache
+	ret := make(map[string]deviceJson)
+	readKeys := make(map[string]*functionJson)
+	for address, d := range i.devices {
+		dj := deviceJson{Address: address, State: d.state, Units: make(map[string]map[string]*functionJson)}
+		if json.Valid([]byte(d.diagnostics)) {
+			dj.Diagnostics = json.RawMessage(d.diagnostics)
+		}
+		for unitName, unit := range d.units {
+			dj.Units[unitName] = make(map[string]*functionJson)
+			for functionName, f := range unit {
+				fj := newFunctionJson(f)
+				if "" != f.readKey {
+					readKeys[f.readKey] = fj
+				}
+				dj.Units[unitName][functionName] = fj
+			}
+		}
+		name := d.name
+		if "" == name {
+			name = address
+		}
+		ret[name] = dj
+	}
+	i.mutex.Unlock()
+	if nil == cache {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+		return
+	}
+	periods := cache.AccessPeriods()
+	for key, fj := range readKeys {
+		uid, fno, err := Cache.ParseOutputKey(key)
+		if nil != err {
+			continue
+		}
+		if period, ok := periods[key]; ok {
+			fj.AccessPeriod = period.String()
+		}
+		// listing is not a read of every function
+		fj.setCached(cache.PeekCached(uid, fno))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ret)
+}
+
+// newFunctionJson is the function without cached values, mutex should be locked
+func newFunctionJson(f *function) *functionJson {
+	return &functionJson{
+		Type:       f.dataType,
+		Readable:   "" != f.readKey,
+		Writeable:  "" != f.writeKey,
+		WriteState: f.writeState,
+		WriteError: f.writeError,
+	}
+}
+
+func (fj *functionJson) setCached(value string, state Cache.State, timestamp time.Time) {
+	fj.Value, fj.State = value, state.String()
+	if !timestamp.IsZero() {
+		fj.Updated = timestamp.Format(time.RFC3339Nano)
+	}
+}
+
+// function answers GET and PUT of /devices/device/unit/function
+func (i *Interface) function(w http.ResponseWriter, r *http.Request) {
+	path := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/devices/"), "/", 3)
+	if 3 != len(path) {
+		http.Error(w, "path is /devices/device/unit/function", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		i.readFunction(w, path)
+	case http.MethodPut:
+		i.writeFunction(w, r, path)
+	default:
+		http.Error(w, "only GET and PUT are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readFunction answers GET /devices/device/unit/function with the function of the tree
+// it is a read of the value, the cache updates the function more often while it is polled
+func (i *Interface) readFunction(w http.ResponseWriter, path []string) {
+	i.mutex.Lock()
+	cache := i.cache
+	var fj *functionJson
+	var readKey string
+	f := i.findFunction(path[0], path[1], path[2])
+	if nil != f {
+		readKey = f.readKey
+		fj = newFunctionJson(f)
+	}
+	i.mutex.Unlock()
+	if nil == cache {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+		return
+	}
+	if nil == f {
+		http.Error(w, fmt.Sprintf("no function %v", strings.Join(path, "/")), http.StatusNotFound)
+		return
+	}
+	if uid, fno, err := Cache.ParseOutputKey(readKey); nil == err {
+		fj.setCached(cache.GetCached(uid, fno))
+		if period, ok := cache.AccessPeriods()[readKey]; ok {
+			fj.AccessPeriod = period.String()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(fj)
+}
+
+// writeFunction answers PUT /devices/device/unit/function, body is the value
+func (i *Interface) writeFunction(w http.ResponseWriter, r *http.Request, path []string) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyLength))
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	i.mutex.Lock()
+	cache := i.cache
+	var writeKey string
+	var command bool
+	f := i.findFunction(path[0], path[1], path[2])
+	if nil != f {
+		writeKey, command = f.writeKey, f.command
+	}
+	i.mutex.Unlock()
+	if nil == cache {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+		return
+	}
+	if nil == f {
+		http.Error(w, fmt.Sprintf("no function %v", strings.Join(path, "/")), http.StatusNotFound)
+		return
+	}
+	if "" == writeKey {
+		http.Error(w, "function is not writeable", http.StatusMethodNotAllowed)
+		return
+	}
+	uid, fno, err := Cache.ParseOutputKey(writeKey)
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if command {
+		// every PUT is delivered once, the write state of the function tells the result
+		cache.Command(uid, fno, string(body))
+	} else {
+		cache.SetCached(uid, fno, string(body))
+	}
+	// it is written at the next update cycle, write state tells when
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// findFunction by devices.json names, mutex should be locked
+func (i *Interface) findFunction(deviceName string, unitName string, functionName string) *function {
+	for address, d := range i.devices {
+		if deviceName == d.name || deviceName == address {
+			return d.units[unitName][functionName]
+		}
+	}
+	return nil
+}
+
+// events answers GET /events with Server-Sent Events of component updates
+func (i *Interface) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	subscriber := make(chan []byte, 16)
+	i.mutex.Lock()
+	i.subscribers[subscriber] = struct{}{}
+	i.mutex.Unlock()
+	defer func() {
+		i.mutex.Lock()
+		delete(i.subscribers, subscriber)
+		i.mutex.Unlock()
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-subscriber:
+			if _, err := fmt.Fprintf(w, "event: update\ndata: %s\n\n", data); nil != err {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Rest serves components over HTTP:
+// GET /devices is devices.json tree of devices, units and functions with their cached values, states and update periods
+// GET /devices/device/unit/function is the function, polling it makes the cache update it more often, polling the tree does not
+// PUT /devices/device/unit/function writes the request body to the function, command functions queue it as a command
+// GET /events is Server-Sent Events stream of component updates
+package Rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"../Cache"
+	"../OutsideInterface"
+	"../RFModel"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.New()
+
+// maxBodyLength is more than any function takes
+const maxBodyLength = 1024
+
+type Settings struct {
+	Listen string
+}
+
+// CacheAccess is what the api needs of Cache.Cache
+type CacheAccess interface {
+	GetCached(uid RFModel.UID, fno RFModel.FuncNo) (value string, state Cache.State, timestamp time.Time)
+	PeekCached(uid RFModel.UID, fno RFModel.FuncNo) (value string, state Cache.State, timestamp time.Time)
+	SetCached(uid RFModel.UID, fno RFModel.FuncNo, value string)
+	Command(uid RFModel.UID, fno RFModel.FuncNo, value string) <-chan error
+	AccessPeriods() map[string]time.Duration
+}
+
+type Interface struct {
+	server *http.Server
+	mutex  sync.Mutex
+	cache  CacheAccess
+	// by device address
+	devices map[string]*device
+	// by read and write keys
+	functions   map[string]*function
+	subscribers map[chan []byte]struct{}
+}
+
+type device struct {
+	name        string
+	state       string
+	diagnostics string // the last diagnostics value, json
+	// by unit and function names
+	units map[string]map[string]*function
+}
+
+type function struct {
+	device     string
+	unit       string
+	name       string
+	dataType   string
+	readKey    string
+	writeKey   string
+	command    bool // writes are Cache.Command
+	writeState string
+	writeError string
+}
+
+// event is the data of the update event
+type event struct {
+	Key      string `json:"key"`
+	Device   string `json:"device,omitempty"`
+	Unit     string `json:"unit,omitempty"`
+	Function string `json:"function,omitempty"`
+	Value    string `json:"value"`
+}
+
+func Init(self *Interface, settings Settings) {
+	log.Formatter = new(logrus.TextFormatter)
+	log.Level = logrus.InfoLevel
+	log.Out = os.Stdout
+	initInterface(self)
+	self.server = &http.Server{Addr: settings.Listen, Handler: self.handler()}
+	go func() {
+		if err := self.server.ListenAndServe(); nil != err && http.ErrServerClosed != err {
+			log.Error(fmt.Sprintf("Rest.Init: ListenAndServe(%v): %v", settings.Listen, err))
+		}
+	}()
+	log.Info(fmt.Sprintf("Rest.Init: listening on %v", settings.Listen))
+}
+
+func initInterface(self *Interface) {
+	self.devices = make(map[string]*device)
+	self.functions = make(map[string]*function)
+	self.subscribers = make(map[chan []byte]struct{})
+}
+
+// Attach gives the api the cache, requests are answered with 503 until then
+func (i *Interface) Attach(cache CacheAccess) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.cache = cache
+}
+
+// Close stops the server
+func (i *Interface) Close() {
+	_ = i.server.Close()
+}
+
+func (i *Interface) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/devices", i.listDevices)
+	mux.HandleFunc("/devices/", i.function)
+	mux.HandleFunc("/events", i.events)
+	return mux
+}
+
+func (i *Interface) UpdateComponent(key string, value string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	e := event{Key: key, Value: value}
+	if f, ok := i.functions[key]; ok {
+		e.Device, e.Unit, e.Function = f.device, f.unit, f.name
+	} else if uid, _, err := Cache.ParseOutputKey(key); nil == err && 0 == uid.Unit {
+		// unit 0 is the device itself, its only component is diagnostics
+		d := i.device(RFModel.AddressToString(uid.Address))
+		d.diagnostics = value
+		e.Device = d.name
+	}
+	data, _ := json.Marshal(e)
+	for subscriber := range i.subscribers {
+		select {
+		case subscriber <- data:
+		default:
+			// slow client misses updates, it should not block the cache
+		}
+	}
+}
+
+// RegisterWritableComponent returns the channel, which is never written, PUT requests go to the cache directly
+func (i *Interface) RegisterWritableComponent(key string) <-chan OutsideInterface.SubMessage {
+	return make(chan OutsideInterface.SubMessage)
+}
+
+// DescribeComponent puts devices.json function of the key to the tree
+func (i *Interface) DescribeComponent(key string, info OutsideInterface.ComponentInfo) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	d := i.device(info.DeviceAddress)
+	d.name = info.DeviceName
+	if uid, _, err := Cache.ParseOutputKey(key); nil != err || 0 == uid.Unit {
+		return
+	}
+	if f, ok := i.functions[key]; ok && (info.DeviceName != f.device || info.UnitName != f.unit || info.FunctionName != f.name) {
+		// renamed in devices.json
+		i.removeFunction(key)
+	}
+	unit, ok := d.units[info.UnitName]
+	if !ok {
+		unit = make(map[string]*function)
+		d.units[info.UnitName] = unit
+	}
+	f, ok := unit[info.FunctionName]
+	if !ok {
+		f = &function{device: info.DeviceName, unit: info.UnitName, name: info.FunctionName}
+		unit[info.FunctionName] = f
+	}
+	if info.Readable {
+		f.readKey = key
+	}
+	if info.Writeable {
+		f.writeKey = key
+		f.command = info.Command
+	}
+	if "" != info.DataType {
+		f.dataType = info.DataType
+	}
+	i.functions[key] = f
+}
+
+func (i *Interface) UpdateDeviceState(address string, state string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.device(address).state = state
+}
+
+func (i *Interface) UpdateWriteState(key string, state string, reason string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if f, ok := i.functions[key]; ok {
+		f.writeState = state
+		f.writeError = reason
+	}
+}
+
+// UnregisterComponent takes the key out of the tree, diagnostics key takes the whole device
+func (i *Interface) UnregisterComponent(key string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if uid, _, err := Cache.ParseOutputKey(key); nil == err && 0 == uid.Unit {
+		delete(i.devices, RFModel.AddressToString(uid.Address))
+		return
+	}
+	i.removeFunction(key)
+}
+
+// removeFunction takes the key out of its function and the function without keys out of the tree
+// mutex should be locked
+func (i *Interface) removeFunction(key string) {
+	f, ok := i.functions[key]
+	if !ok {
+		return
+	}
+	delete(i.functions, key)
+	if key == f.readKey {
+		f.readKey = ""
+	}
+	if key == f.writeKey {
+		f.writeKey = ""
+		f.writeState, f.writeError = "", ""
+	}
+	if "" != f.readKey || "" != f.writeKey {
+		return
+	}
+	for _, d := range i.devices {
+		if unit, ok := d.units[f.unit]; ok && f == unit[f.name] {
+			delete(unit, f.name)
+			if 0 == len(unit) {
+				delete(d.units, f.unit)
+			}
+		}
+	}
+}
+
+// device finds or creates the device, mutex should be locked
+func (i *Interface) device(address string) *device {
+	d, ok := i.devices[address]
+	if !ok {
+		d = &device{state: OutsideInterface.DSOffline, units: make(map[string]map[string]*function)}
+		i.devices[address] = d
+	}
+	return d
+}
@@ -0,0 +1,149 @@
+package Rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"../Cache"
+	"../OutsideInterface"
+	"../RFModel"
+)
+
+type fakeCache struct {
+	written  map[RFModel.UnitFunctionKey]string
+	commands []string
+	reads    int
+}
+
+func (c *fakeCache) GetCached(uid RFModel.UID, fno RFModel.FuncNo) (string, Cache.State, time.Time) {
+	c.reads++
+	return c.PeekCached(uid, fno)
+}
+
+func (c *fakeCache) PeekCached(uid RFModel.UID, fno RFModel.FuncNo) (string, Cache.State, time.Time) {
+	return "true", Cache.SOnline, time.Now()
+}
+
+func (c *fakeCache) AccessPeriods() map[string]time.Duration {
+	return map[string]time.Duration{"AA:AA:AA:AA:01:01|10": 2 * time.Second}
+}
+
+func (c *fakeCache) SetCached(uid RFModel.UID, fno RFModel.FuncNo, value string) {
+	c.written[RFModel.UnitFunctionKey{UID: uid, FNo: fno}] = value
+}
+
+func (c *fakeCache) Command(uid RFModel.UID, fno RFModel.FuncNo, value string) <-chan error {
+	c.commands = append(c.commands, fmt.Sprintf("%X %v", fno, value))
+	return make(chan error, 1)
+}
+
+func initTestInterface() (*Interface, *fakeCache, *httptest.Server) {
+	var i Interface
+	initInterface(&i)
+	info := OutsideInterface.ComponentInfo{DeviceAddress: "AA:AA:AA:AA:01", DeviceName: "relay", UnitName: "unit 1", FunctionName: "Out 1", DataType: "bool"}
+	info.Readable = true
+	i.DescribeComponent("AA:AA:AA:AA:01:01|10", info)
+	info.Readable, info.Writeable = false, true
+	i.DescribeComponent("AA:AA:AA:AA:01:01|11", info)
+	info.FunctionName, info.Command = "pulse", true
+	i.DescribeComponent("AA:AA:AA:AA:01:01|13", info)
+	i.DescribeComponent("AA:AA:AA:AA:01:00|D", OutsideInterface.ComponentInfo{DeviceAddress: "AA:AA:AA:AA:01", DeviceName: "relay", UnitName: "device", FunctionName: "diagnostics", Readable: true})
+	i.UpdateDeviceState("AA:AA:AA:AA:01", OutsideInterface.DSOnline)
+	cache := &fakeCache{written: map[RFModel.UnitFunctionKey]string{}}
+	i.Attach(cache)
+	return &i, cache, httptest.NewServer(i.handler())
+}
+
+func TestDevices(t *testing.T) {
+	i, cache, server := initTestInterface()
+	defer server.Close()
+	i.UpdateComponent("AA:AA:AA:AA:01:00|D", `{"build number":7}`)
+	response, err := http.Get(server.URL + "/devices")
+	if nil != err {
+		t.Fatal(err)
+	}
+	var devices map[string]deviceJson
+	err = json.NewDecoder(response.Body).Decode(&devices)
+	_ = response.Body.Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+	relay := devices["relay"]
+	f := relay.Units["unit 1"]["Out 1"]
+	if OutsideInterface.DSOnline != relay.State || nil == f || "true" != f.Value || !f.Readable || !f.Writeable || "bool" != f.Type || "2s" != f.AccessPeriod {
+		t.Errorf("unexpected devices %v", devices)
+	}
+	if `{"build number":7}` != string(relay.Diagnostics) || 1 != len(relay.Units) {
+		t.Errorf("diagnostics %s, units %v", relay.Diagnostics, relay.Units)
+	}
+	if 0 != cache.reads {
+		t.Errorf("listing is %v reads", cache.reads)
+	}
+	// single function is read
+	response, err = http.Get(server.URL + "/devices/relay/unit%201/Out%201")
+	if nil != err {
+		t.Fatal(err)
+	}
+	var fj functionJson
+	err = json.NewDecoder(response.Body).Decode(&fj)
+	_ = response.Body.Close()
+	if nil != err || "true" != fj.Value || "2s" != fj.AccessPeriod || !fj.Writeable || 1 != cache.reads {
+		t.Errorf("function %+v, error %v, reads %v", fj, err, cache.reads)
+	}
+	request, _ := http.NewRequest(http.MethodPut, server.URL+"/devices/relay/unit%201/Out%201", strings.NewReader("false"))
+	response, err = http.DefaultClient.Do(request)
+	if nil != err {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	uid := RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}
+	if http.StatusAccepted != response.StatusCode || "false" != cache.written[RFModel.UnitFunctionKey{UID: uid, FNo: 0x11}] {
+		t.Errorf("write status %v, written %v", response.Status, cache.written)
+	}
+	// command functions take every PUT as a command
+	for j := 0; 2 > j; j++ {
+		request, _ = http.NewRequest(http.MethodPut, server.URL+"/devices/relay/unit%201/pulse", strings.NewReader("true"))
+		if response, err = http.DefaultClient.Do(request); nil != err || http.StatusAccepted != response.StatusCode {
+			t.Errorf("command response %v, error %v", response, err)
+		}
+	}
+	if "[13 true 13 true]" != fmt.Sprint(cache.commands) || 1 != len(cache.written) {
+		t.Errorf("commands %v, written %v", cache.commands, cache.written)
+	}
+	request, _ = http.NewRequest(http.MethodPut, server.URL+"/devices/relay/unit%201/Out%202", strings.NewReader("false"))
+	if response, err = http.DefaultClient.Do(request); nil != err || http.StatusNotFound != response.StatusCode {
+		t.Errorf("unknown function response %v, error %v", response, err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	i, _, server := initTestInterface()
+	defer server.Close()
+	response, err := http.Get(server.URL + "/events")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	// the handler subscribes before the headers are sent
+	i.UpdateComponent("AA:AA:AA:AA:01:01|10", "false")
+	reader := bufio.NewReader(response.Body)
+	var data string
+	for !strings.HasPrefix(data, "data: ") {
+		if data, err = reader.ReadString('\n'); nil != err {
+			t.Fatal(err)
+		}
+	}
+	var e event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &e); nil != err {
+		t.Fatal(err)
+	}
+	if "relay" != e.Device || "Out 1" != e.Function || "false" != e.Value {
+		t.Errorf("unexpected event %v", e)
+	}
+}
@@ -0,0 +1,137 @@
+// SimTransciever is an in-memory virtual radio network
+// scripted slave devices answer RFModel requests, so the whole stack can be run without any hardware
+package SimTransciever
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"../RFModel"
+	"../TranscieverModel"
+	"github.com/flynn/json5"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.New()
+
+// SimTransmitter handle
+type SimTransmitter struct {
+	devices         map[RFModel.DeviceAddress]*simDevice
+	latency         time.Duration
+	channel         byte
+	sendCommandLock sync.Mutex
+}
+
+// TransmitterSettings ...
+type TransmitterSettings struct {
+	DevicesFile string
+	// delay before every response, to make timing closer to the real radio
+	Latency time.Duration
+}
+
+// Init ...
+func Init(tr *SimTransmitter, settings TransmitterSettings) {
+	log.Formatter = new(logrus.TextFormatter)
+	log.Level = logrus.InfoLevel
+	log.Out = os.Stdout
+	log.Info(fmt.Sprintf("OpenTransmitter begin, simulated devices from %v", settings.DevicesFile))
+	jsonData, err := ioutil.ReadFile(settings.DevicesFile)
+	if nil != err {
+		panic(fmt.Errorf("SimTransciever.Init: ioutil.ReadFile: %v; ", err.Error()))
+	}
+	var data map[string]interface{}
+	if err := json5.Unmarshal(jsonData, &data); nil != err {
+		panic(fmt.Errorf("SimTransciever.Init: json5.Unmarshal: %v; ", err.Error()))
+	}
+	tr.devices = parseDevices(data)
+	tr.latency = settings.Latency
+	tr.channel = TranscieverModel.DefaultRFChannel
+}
+
+// Close does nothing, there is nothing to release
+func (tr *SimTransmitter) Close() {
+}
+
+// SendCommand panics on cancellation only, which never happens here, see SendCommandContext
+func (tr *SimTransmitter) SendCommand(a TranscieverModel.Address, data TranscieverModel.Payload) TranscieverModel.Message {
+	ret, err := tr.SendCommandContext(context.Background(), a, data)
+	if nil != err {
+		panic(err)
+	}
+	return ret
+}
+
+// SendCommandContext passes request to the simulated device and returns its response
+// unknown address or lost packet is reported as slave timeout, same as the modem does
+func (tr *SimTransmitter) SendCommandContext(ctx context.Context, a TranscieverModel.Address, data TranscieverModel.Payload) (TranscieverModel.Message, error) {
+	tr.sendCommandLock.Lock()
+	defer tr.sendCommandLock.Unlock()
+	select {
+	case <-time.After(tr.latency):
+	case <-ctx.Done():
+		return TranscieverModel.Message{}, ctx.Err()
+	}
+	return tr.respond(a, data), nil
+}
+
+// respond makes the simulated device response to the request
+func (tr *SimTransmitter) respond(a TranscieverModel.Address, data TranscieverModel.Payload) (ret TranscieverModel.Message) {
+	ret = TranscieverModel.Message{
+		Address: a,
+		Status:  TranscieverModel.EMSSlaveTimeout,
+	}
+	device, ok := tr.devices[RFModel.DeviceAddress(a)]
+	if !ok || tr.channel != device.channel {
+		log.Debug(fmt.Sprintf("Sim.SendCommand(%v, %v): no such device on channel %v", a, data, tr.channel))
+		return ret
+	}
+	if 0 < device.loss && rand.Float64() < device.loss {
+		log.Debug(fmt.Sprintf("Sim.SendCommand(%v, %v): packet lost", a, data))
+		return ret
+	}
+	device.statistics.RxPackets++
+	if int(RFModel.RequestHeaderSize) > len(data) || int(RFModel.PacketLength) < len(data) {
+		log.Warning(fmt.Sprintf("Sim.SendCommand(%v, %v): malformed request ignored", a, data))
+		device.statistics.RxErrors++
+		return ret
+	}
+	// request is version, transaction id, unit, function, data
+	// response is version, transaction id, code, data
+	var code RFModel.EResponseCode
+	var payload []byte
+	if 0 != data[0] {
+		device.statistics.RxErrors++
+		code, payload = RFModel.ERCBadVersion, []byte{}
+	} else {
+		code, payload = device.request(data[1], data[2], RFModel.FuncNo(data[3]), data[RFModel.RequestHeaderSize:])
+	}
+	if int(RFModel.MaxDataLengthRs) < len(payload) {
+		code, payload = RFModel.ERCResponseTooBig, []byte{}
+	}
+	device.statistics.TxPackets++
+	ret.Status = TranscieverModel.EMSDataPacket
+	ret.Payload = append(TranscieverModel.Payload{0, data[1], byte(code)}, payload...)
+	log.Debug(fmt.Sprintf("Sim.SendCommand(%v, %v): response %v", a, data, ret.Payload))
+	// new address given by F0SetMACAddress works after the response
+	if RFModel.DeviceAddress(a) != device.address {
+		delete(tr.devices, RFModel.DeviceAddress(a))
+		tr.devices[device.address] = device
+	}
+	return ret
+}
+
+// SetRFChannel switches the channel, devices on other channels do not hear the transmitter
+func (tr *SimTransmitter) SetRFChannel(channel byte) error {
+	if 128 <= channel {
+		return fmt.Errorf("Sim.SetRFChannel: incorrect channel %v", channel)
+	}
+	tr.sendCommandLock.Lock()
+	defer tr.sendCommandLock.Unlock()
+	tr.channel = channel
+	return nil
+}
@@ -0,0 +1,234 @@
+package SimTransciever
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../RFModel"
+	"../TranscieverModel"
+)
+
+const testDevices = `{
+	"relay": {
+		"address": "AA:AA:AA:AA:01",
+		"build number": 7,
+		"units": {
+			"unit 1": {
+				"address": 1,
+				"functions": {
+					"out": {"function": 16, "read": true, "write": true},
+					"counter": {"function": 18, "read": true, "write": false, "read type": "int32", "value": -2}
+				}
+			}
+		}
+	},
+	"secure relay": {
+		"address": "AA:AA:AA:AA:02",
+		"key": "000102030405060708090a0b0c0d0e0f",
+		"units": {
+			"unit 1": {
+				"address": 1,
+				"functions": {
+					"out": {"function": 16, "read": true, "write": true},
+					"name": {"function": 18, "read": true, "write": true, "type": "string", "value": "secure"}
+				}
+			}
+		}
+	}
+}`
+
+func initTestTransmitter(t *testing.T) *SimTransmitter {
+	dir, err := ioutil.TempDir("", "sim")
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	name := filepath.Join(dir, "devices.json")
+	if err := ioutil.WriteFile(name, []byte(testDevices), 0644); nil != err {
+		t.Fatal(err)
+	}
+	var tr SimTransmitter
+	Init(&tr, TransmitterSettings{DevicesFile: name})
+	return &tr
+}
+
+func TestSendCommand(t *testing.T) {
+	tr := initTestTransmitter(t)
+	address := TranscieverModel.Address(RFModel.ParseAddress("AA:AA:AA:AA:01"))
+	// unit 0 reports number of units
+	m := tr.SendCommand(address, TranscieverModel.Payload{0, 7, 0, RFModel.FGetListOfUnitFunctions})
+	if TranscieverModel.EMSDataPacket != m.Status || 8 != len(m.Payload) || 7 != m.Payload[1] || 1 != m.Payload[3] {
+		t.Errorf("unexpected unit 0 response %v", m)
+	}
+	// unknown unit
+	m = tr.SendCommand(address, TranscieverModel.Payload{0, 8, 5, 0x10})
+	if RFModel.ERCBadUnitId != m.Payload[2] {
+		t.Errorf("expected bad unit id, got %v", m)
+	}
+	// unknown device
+	m = tr.SendCommand(TranscieverModel.Address{1, 2, 3, 4, 5}, TranscieverModel.Payload{0, 9, 1, 0x10})
+	if TranscieverModel.EMSSlaveTimeout != m.Status {
+		t.Errorf("expected slave timeout, got %v", m)
+	}
+}
+
+func TestRFModelOverSim(t *testing.T) {
+	tr := initTestTransmitter(t)
+	var model RFModel.RFModel
+	RFModel.Init(&model, tr)
+	uid := RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}
+	if v := model.ReadFunction(uid, 0x10); false != v {
+		t.Errorf("initial out value is %v", v)
+	}
+	model.WriteFunction(uid, 0x11, true)
+	if v := model.ReadFunction(uid, 0x10); true != v {
+		t.Errorf("out value after write is %v", v)
+	}
+	if v := model.ReadFunction(uid, 0x12); int32(-2) != v {
+		t.Errorf("counter value is %v", v)
+	}
+}
+
+func TestRFModelErrors(t *testing.T) {
+	tr := initTestTransmitter(t)
+	var model RFModel.RFModel
+	RFModel.Init(&model, tr)
+	ctx := context.Background()
+	uid := RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}
+	_, err := model.ReadFunctionContext(ctx, uid, 0x30)
+	var callError *RFModel.CallError
+	if !errors.Is(err, RFModel.ErrBadCode) || !errors.As(err, &callError) || RFModel.ERCBadFunctionId != callError.Code {
+		t.Errorf("unknown function error is %v", err)
+	}
+	if err := model.WriteFunctionContext(ctx, uid, 0x11, "true"); nil != err {
+		t.Errorf("write error %v", err)
+	}
+	if err := model.WriteFunctionContext(ctx, uid, 0x15, "1"); !errors.Is(err, RFModel.ErrBadParameter) {
+		t.Errorf("write of the function, which the device does not have, error is %v", err)
+	}
+	absent := RFModel.UID{Address: RFModel.ParseAddress("01:02:03:04:05"), Unit: 1}
+	if _, err := model.ReadFunctionContext(ctx, absent, 0x10); !errors.Is(err, RFModel.ErrDeviceTimeout) {
+		t.Errorf("absent device error is %v", err)
+	}
+	tr.latency = time.Second
+	cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := model.CallFunctionContext(cancelled, uid, 0x10, nil); !errors.Is(err, RFModel.ErrCancelled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled call error is %v", err)
+	}
+}
+
+func TestDeviceStatistics(t *testing.T) {
+	tr := initTestTransmitter(t)
+	var model RFModel.RFModel
+	RFModel.Init(&model, tr)
+	ctx := context.Background()
+	address := RFModel.ParseAddress("AA:AA:AA:AA:01")
+	if _, err := model.CallFunctionContext(ctx, RFModel.UID{Address: address, Unit: 1}, 0x10, nil); nil != err {
+		t.Fatal(err)
+	}
+	statistics, err := model.DeviceStatistics(ctx, address, 0)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 7 != statistics.BuildNumber {
+		t.Errorf("build number is %v", statistics.BuildNumber)
+	}
+	// the statistics request itself is counted before the response is made
+	if 2 > statistics.RxPackets || statistics.RxPackets != statistics.TxPackets+1 {
+		t.Errorf("packet counters are rx %v, tx %v", statistics.RxPackets, statistics.TxPackets)
+	}
+	cached, err := model.DeviceStatistics(ctx, address, time.Minute)
+	if nil != err || cached != statistics {
+		t.Errorf("cached statistics are %v, error %v", cached, err)
+	}
+}
+
+func TestTransactionResync(t *testing.T) {
+	tr := initTestTransmitter(t)
+	var model RFModel.RFModel
+	RFModel.Init(&model, tr)
+	uid := RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:01"), Unit: 1}
+	resyncs := model.TransactionResyncs(uid.Address)
+	model.ReadFunction(uid, 0x10)
+	model.ReadFunction(uid, 0x12)
+	if model.TransactionResyncs(uid.Address) != resyncs {
+		t.Errorf("unexpected resync of the consecutive transactions")
+	}
+	// device reboot or another master talking to it
+	tr.devices[uid.Address].transactionID += 100
+	if v := model.ReadFunction(uid, 0x12); int32(-2) != v {
+		t.Errorf("counter value after resync is %v", v)
+	}
+	if model.TransactionResyncs(uid.Address) != resyncs+1 {
+		t.Errorf("resync count is %v, want %v", model.TransactionResyncs(uid.Address), resyncs+1)
+	}
+}
+
+// recorder keeps requests, as anyone listening to the air would
+type recorder struct {
+	*SimTransmitter
+	requests []TranscieverModel.Payload
+}
+
+func (r *recorder) SendCommandContext(ctx context.Context, a TranscieverModel.Address, data TranscieverModel.Payload) (TranscieverModel.Message, error) {
+	r.requests = append(r.requests, data)
+	return r.SimTransmitter.SendCommandContext(ctx, a, data)
+}
+
+func TestSecureSession(t *testing.T) {
+	tr := initTestTransmitter(t)
+	air := &recorder{SimTransmitter: tr}
+	var model RFModel.RFModel
+	RFModel.Init(&model, air)
+	ctx := context.Background()
+	uid := RFModel.UID{Address: RFModel.ParseAddress("AA:AA:AA:AA:02"), Unit: 1}
+	device := tr.devices[uid.Address]
+	// plaintext requests are refused
+	if _, err := model.ReadFunctionContext(ctx, uid, 0x10); !errors.Is(err, RFModel.ErrBadCode) {
+		t.Errorf("plaintext read error is %v", err)
+	}
+	key, _ := RFModel.ParseKey("000102030405060708090a0b0c0d0e0f")
+	if err := model.SetDeviceKey(uid.Address, key); nil != err {
+		t.Fatal(err)
+	}
+	if err := model.WriteFunctionContext(ctx, uid, 0x11, true); nil != err {
+		t.Fatalf("secure write error %v", err)
+	}
+	recorded := air.requests[len(air.requests)-1]
+	if v, err := model.ReadFunctionContext(ctx, uid, 0x10); nil != err || true != v {
+		t.Errorf("secure read is %v, error %v", v, err)
+	}
+	if v, err := model.ReadFunctionContext(ctx, uid, 0x12); nil != err || "secure" != v {
+		t.Errorf("secure string read is %v, error %v", v, err)
+	}
+	if err := model.WriteFunctionContext(ctx, uid, 0x11, false); nil != err {
+		t.Fatalf("secure write error %v", err)
+	}
+	// replay of the recorded write does not reach the unit
+	if m := tr.SendCommand(TranscieverModel.Address(uid.Address), recorded); RFModel.ERCOk == RFModel.EResponseCode(m.Payload[2]) {
+		t.Errorf("replayed request is accepted: %v", m)
+	}
+	if v, err := model.ReadFunctionContext(ctx, uid, 0x10); nil != err || false != v {
+		t.Errorf("read after replay is %v, error %v", v, err)
+	}
+	// device reboot loses the session, it is made anew
+	resyncs := model.TransactionResyncs(uid.Address)
+	device.session = nil
+	if v, err := model.ReadFunctionContext(ctx, uid, 0x10); nil != err || false != v {
+		t.Errorf("read after reboot is %v, error %v", v, err)
+	}
+	if model.TransactionResyncs(uid.Address) != resyncs+1 {
+		t.Errorf("session reset is not counted")
+	}
+	// wrong key
+	_ = model.SetDeviceKey(uid.Address, make([]byte, RFModel.KeyLength))
+	if _, err := model.CallFunctionContext(ctx, uid, 0x10, nil); !errors.Is(err, RFModel.ErrSecurity) {
+		t.Errorf("wrong key error is %v", err)
+	}
+}
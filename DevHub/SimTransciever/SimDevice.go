@@ -0,0 +1,273 @@
+package SimTransciever
+
+import (
+	"fmt"
+	"time"
+
+	"../RFModel"
+	"../TranscieverModel"
+)
+
+// simFunction is a single function of a simulated unit
+// read and write functions of the same value share the storage, like the firmware does
+type simFunction struct {
+	read  RFModel.EDataType
+	write RFModel.EDataType
+	value *simValue
+}
+
+// simValue is raw memory behind one or two functions, stored already serialized
+type simValue struct {
+	data         []byte
+	togglePeriod time.Duration // bool only: flip value every period, imitating a movement sensor
+	toggleStart  time.Time
+}
+
+type simUnit struct {
+	description string
+	functions   map[RFModel.FuncNo]*simFunction
+}
+
+type simDevice struct {
+	address RFModel.DeviceAddress
+	// index 0 is unused, unit 0 functions are hardcoded
+	units []*simUnit
+	// packet loss probability, 0..1
+	loss    float64
+	channel byte
+	// transaction id of the last accepted request, any id is accepted after boot
+	transactionID byte
+	synchronised  bool
+	// pre-shared key, nil for plaintext devices
+	key     []byte
+	session *simSession
+	// counters and build number for F0GetDeviceStatistics
+	statistics RFModel.DeviceStatistics
+	boot       time.Time
+}
+
+func parseDataType(name string) RFModel.EDataType {
+	if t, ok := RFModel.ParseDataType(name); ok {
+		return t
+	}
+	panic(fmt.Errorf("SimTransciever.parseDataType: unknown data type <%v>; ", name))
+}
+
+// serializeValue converts json value into the payload of a given type
+func serializeValue(t RFModel.EDataType, v interface{}) []byte {
+	if nil == v {
+		switch t {
+		case RFModel.EDBool, RFModel.EDByte:
+			return []byte{0}
+		case RFModel.EDInt32:
+			return []byte{0, 0, 0, 0}
+		default:
+			return []byte{}
+		}
+	}
+	switch t {
+	case RFModel.EDNone:
+		return []byte{}
+	case RFModel.EDBool:
+		if true == v {
+			return []byte{1}
+		}
+		return []byte{0}
+	case RFModel.EDByte:
+		return []byte{byte(v.(float64))}
+	case RFModel.EDInt32:
+		i := int32(v.(float64))
+		return []byte{byte(i & 0xFF), byte((i >> 8) & 0xFF), byte((i >> 16) & 0xFF), byte((i >> 24) & 0xFF)}
+	case RFModel.EDString:
+		return []byte(v.(string))
+	case RFModel.EDByteArray:
+		ret := []byte{}
+		for _, b := range v.([]interface{}) {
+			ret = append(ret, byte(b.(float64)))
+		}
+		return ret
+	}
+	panic(fmt.Errorf("SimTransciever.serializeValue: unexpected data type %v; ", t))
+}
+
+// validatePayload checks that written data has length of a given type
+func validatePayload(t RFModel.EDataType, data []byte) bool {
+	switch t {
+	case RFModel.EDBool, RFModel.EDByte:
+		return 1 == len(data)
+	case RFModel.EDInt32:
+		return 4 == len(data)
+	case RFModel.EDString, RFModel.EDByteArray:
+		return int(RFModel.MaxDataLengthRs) >= len(data)
+	}
+	return false
+}
+
+func (v *simValue) get() []byte {
+	if 0 != v.togglePeriod && 1 == len(v.data) {
+		if 1 == (time.Now().Sub(v.toggleStart)/v.togglePeriod)%2 {
+			return []byte{1 - v.data[0]&1}
+		}
+	}
+	return v.data
+}
+
+func (v *simValue) set(data []byte) {
+	v.data = append([]byte{}, data...)
+	v.toggleStart = time.Now()
+}
+
+// parseDevices builds simulated devices from the file of devices.json format
+// with optional "type" (or separate "read type" and "write type"), "value", "toggle period" function keys,
+// "description" unit key, "loss", "key", "channel" and "build number" device keys
+func parseDevices(data map[string]interface{}) map[RFModel.DeviceAddress]*simDevice {
+	ret := map[RFModel.DeviceAddress]*simDevice{}
+	for _, deviceInterface := range data {
+		device := deviceInterface.(map[string]interface{})
+		d := simDevice{
+			address: RFModel.ParseAddress(device["address"].(string)),
+			units:   []*simUnit{nil},
+			channel: TranscieverModel.DefaultRFChannel,
+			boot:    time.Now(),
+		}
+		d.statistics.BuildNumber = 1
+		if buildNumber, ok := device["build number"]; ok {
+			d.statistics.BuildNumber = uint32(buildNumber.(float64))
+		}
+		if channel, ok := device["channel"]; ok {
+			d.channel = byte(channel.(float64))
+		}
+		if loss, ok := device["loss"]; ok {
+			d.loss = loss.(float64)
+		}
+		if key, ok := device["key"]; ok {
+			var err error
+			if d.key, err = RFModel.ParseKey(key.(string)); nil != err {
+				panic(err)
+			}
+		}
+		units := device["units"].(map[string]interface{})
+		for unitName, unitInterface := range units {
+			unit := unitInterface.(map[string]interface{})
+			unitNo := int(unit["address"].(float64))
+			for len(d.units) <= unitNo {
+				d.units = append(d.units, &simUnit{functions: map[RFModel.FuncNo]*simFunction{}})
+			}
+			u := d.units[unitNo]
+			u.description = unitName
+			if description, ok := unit["description"]; ok {
+				u.description = description.(string)
+			}
+			functions := unit["functions"].(map[string]interface{})
+			for _, functionInterface := range functions {
+				function := functionInterface.(map[string]interface{})
+				fno := RFModel.FuncNo(byte(function["function"].(float64)))
+				readType := RFModel.EDataType(RFModel.EDBool)
+				if t, ok := function["type"]; ok {
+					readType = parseDataType(t.(string))
+				}
+				if t, ok := function["read type"]; ok {
+					readType = parseDataType(t.(string))
+				}
+				writeType := readType
+				if t, ok := function["write type"]; ok {
+					writeType = parseDataType(t.(string))
+				}
+				value := simValue{data: serializeValue(readType, function["value"]), toggleStart: time.Now()}
+				if tp, ok := function["toggle period"]; ok {
+					value.togglePeriod = time.Duration(tp.(float64) * float64(time.Second))
+				}
+				if function["read"].(bool) {
+					u.functions[fno] = &simFunction{read: readType, value: &value}
+				}
+				if function["write"].(bool) {
+					// the same convention as Cache uses: write function number is read one + 1
+					u.functions[fno+1] = &simFunction{write: writeType, value: &value}
+				}
+			}
+		}
+		ret[d.address] = &d
+	}
+	return ret
+}
+
+// checkTransaction imitates the firmware transaction id check:
+// request id has to be the next one after the last accepted or the same one for retransmission
+// F0ResetTransactionID is accepted with any id and makes it the last accepted
+func (d *simDevice) checkTransaction(id byte, unitID byte, fno RFModel.FuncNo) bool {
+	reset := 0 == unitID && RFModel.F0ResetTransactionID == fno
+	if d.synchronised && !reset && id != d.transactionID && id != d.transactionID+1 {
+		return false
+	}
+	d.transactionID = id
+	d.synchronised = true
+	return true
+}
+
+// request passes the request through transaction id and secure mode checks to the unit
+func (d *simDevice) request(id byte, unitID byte, fno RFModel.FuncNo, data []byte) (RFModel.EResponseCode, []byte) {
+	if nil != d.key {
+		return d.secureRequest(id, unitID, fno, data)
+	}
+	if !d.checkTransaction(id, unitID, fno) {
+		d.statistics.TransactionErrors++
+		return RFModel.ERCNotConsecutiveTransactionId, []byte{}
+	}
+	return d.call(unitID, fno, data)
+}
+
+// call executes the request as a slave firmware would and returns response code and data
+func (d *simDevice) call(unitID byte, fno RFModel.FuncNo, data []byte) (RFModel.EResponseCode, []byte) {
+	if 0 == unitID {
+		switch fno {
+		case RFModel.FGetListOfUnitFunctions:
+			// number of units, the rest is reserved
+			return RFModel.ERCOk, []byte{byte(len(d.units) - 1), 0, 0, 0, 0}
+		case RFModel.F0NOP, RFModel.F0ResetTransactionID:
+			return RFModel.ERCOk, []byte{}
+		case RFModel.F0GetDeviceStatistics:
+			statistics := d.statistics
+			statistics.Uptime = time.Now().Sub(d.boot)
+			return RFModel.ERCOk, RFModel.EncodeDeviceStatistics(statistics)
+		case RFModel.F0SetMACAddress:
+			if len(d.address) != len(data) {
+				return RFModel.ERCAddressBadLength, []byte{}
+			}
+			copy(d.address[:], data)
+			return RFModel.ERCOk, []byte{}
+		case RFModel.F0SetRFChannel:
+			if 1 != len(data) || 128 <= data[0] {
+				return RFModel.ERCChBadChannels, []byte{}
+			}
+			d.channel = data[0]
+			return RFModel.ERCOk, []byte{}
+		}
+		return RFModel.ERCNotImplemented, []byte{}
+	}
+	if int(unitID) >= len(d.units) {
+		return RFModel.ERCBadUnitId, []byte{}
+	}
+	u := d.units[unitID]
+	switch fno {
+	case RFModel.FGetListOfUnitFunctions:
+		ret := []byte{}
+		for f, function := range u.functions {
+			ret = append(ret, byte(f), byte(function.read<<4)|byte(function.write&0x0F))
+		}
+		return RFModel.ERCOk, ret
+	case RFModel.FGetTextDescription:
+		return RFModel.ERCOk, []byte(u.description)
+	}
+	function, ok := u.functions[fno]
+	if !ok {
+		return RFModel.ERCBadFunctionId, []byte{}
+	}
+	if RFModel.EDNone != function.write {
+		if !validatePayload(function.write, data) {
+			return RFModel.ERCBadRequestData, []byte{}
+		}
+		function.value.set(data)
+		return RFModel.ERCOk, []byte{}
+	}
+	return RFModel.ERCOk, function.value.get()
+}
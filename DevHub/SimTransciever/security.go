@@ -0,0 +1,103 @@
+package SimTransciever
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"../RFModel"
+)
+
+// simSession is the firmware side of the secure mode, the reference for the RFModel host side
+// it is written after the protocol description in RFModel/security.go and shares no code with it
+type simSession struct {
+	key []byte
+	// counter of the last accepted request
+	counter uint32
+	// response to the last request, repeated for its retransmission
+	lastCode     RFModel.EResponseCode
+	lastResponse []byte
+}
+
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// simCrypt is AES-128-CTR with iv of direction and counter, and MAC over direction, counter, header and ciphertext
+func simCrypt(key []byte, direction byte, counter uint32, data []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if nil != err {
+		panic(err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	iv[0] = direction
+	binary.LittleEndian.PutUint32(iv[1:], counter)
+	ret := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(ret, data)
+	return ret
+}
+
+func simMAC(key []byte, direction byte, counter uint32, header []byte, ciphertext []byte) []byte {
+	c := make([]byte, 4)
+	binary.LittleEndian.PutUint32(c, counter)
+	return hmacSHA256(key, []byte{direction}, c, header, ciphertext)[:RFModel.MACLength]
+}
+
+// newSession answers F0SetNewSessionKey with device nonce and proof of the pre-shared key
+func (d *simDevice) newSession(id byte, hostNonce []byte) (RFModel.EResponseCode, []byte) {
+	if RFModel.NonceLength != len(hostNonce) {
+		return RFModel.ERCBadRequestData, []byte{}
+	}
+	deviceNonce := make([]byte, RFModel.NonceLength)
+	if _, err := rand.Read(deviceNonce); nil != err {
+		panic(err)
+	}
+	d.session = &simSession{
+		key:     hmacSHA256(d.key, []byte("session"), hostNonce, deviceNonce)[:RFModel.KeyLength],
+		counter: uint32(id),
+	}
+	proof := hmacSHA256(d.key, []byte("proof"), hostNonce, deviceNonce, []byte{id})[:RFModel.NonceLength]
+	return RFModel.ERCOk, append(deviceNonce, proof...)
+}
+
+// secureRequest checks and decrypts the request, calls the unit and seals the response
+func (d *simDevice) secureRequest(id byte, unitID byte, fno RFModel.FuncNo, data []byte) (RFModel.EResponseCode, []byte) {
+	if 0 == unitID && RFModel.F0SetNewSessionKey == fno {
+		return d.newSession(id, data)
+	}
+	s := d.session
+	if nil == s {
+		return RFModel.ERCChBadPermissions, []byte{}
+	}
+	if id == byte(s.counter) && nil != s.lastResponse {
+		return s.lastCode, s.lastResponse
+	}
+	if id != byte(s.counter+1) {
+		d.statistics.TransactionErrors++
+		return RFModel.ERCNotConsecutiveTransactionId, []byte{}
+	}
+	counter := s.counter + 1
+	if RFModel.MACLength > len(data) {
+		return RFModel.ERCChValidationFailed, []byte{}
+	}
+	ciphertext, tag := data[:len(data)-RFModel.MACLength], data[len(data)-RFModel.MACLength:]
+	if !hmac.Equal(tag, simMAC(s.key, RFModel.DirectionRequest, counter, []byte{0, id, unitID, byte(fno)}, ciphertext)) {
+		d.statistics.RxErrors++
+		return RFModel.ERCChValidationFailed, []byte{}
+	}
+	code, out := d.call(unitID, fno, simCrypt(s.key, RFModel.DirectionRequest, counter, ciphertext))
+	if int(RFModel.MaxSecureDataLengthRs) < len(out) {
+		code, out = RFModel.ERCResponseTooBig, []byte{}
+	}
+	sealed := simCrypt(s.key, RFModel.DirectionResponse, counter, out)
+	sealed = append(sealed, simMAC(s.key, RFModel.DirectionResponse, counter, []byte{0, id, byte(code)}, sealed)...)
+	s.counter, s.lastCode, s.lastResponse = counter, code, sealed
+	return code, sealed
+}
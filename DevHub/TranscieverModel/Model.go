@@ -1,5 +1,7 @@
 package TranscieverModel
 
+import "context"
+
 // Address of the physical device, basically address of the transciever
 type Address [5]byte
 
@@ -31,3 +33,18 @@ type Transmitter interface {
 	Close()
 	SendCommand(a Address, data Payload) (ret Message)
 }
+
+// ContextTransmitter is a transmitter with cancellable, error-returning api
+// SendCommand of such transmitters panics with the error SendCommandContext would return
+type ContextTransmitter interface {
+	Transmitter
+	SendCommandContext(ctx context.Context, a Address, data Payload) (Message, error)
+}
+
+// DefaultRFChannel is the channel of nRF24L01 after reset, factory boards and transmitters start on it
+const DefaultRFChannel byte = 2
+
+// ChannelSetter is a transmitter which can switch the RF channel it works on
+type ChannelSetter interface {
+	SetRFChannel(channel byte) error
+}
@@ -0,0 +1,359 @@
+//go:build linux
+// +build linux
+
+package UartTransciever
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"../TranscieverModel"
+	"github.com/creack/pty"
+)
+
+// ModemEmulator pretends to be the uart master modem on a pseudo-terminal
+// requests transmitted by the hub are passed to the air transmitter (simulated devices, for instance)
+// and its responses are put into the rx queue, so UMTransmitter can be pointed to PortName()
+type ModemEmulator struct {
+	master  *os.File
+	slave   *os.File
+	air     TranscieverModel.Transmitter
+	stopped chan struct{} // closed by serve on exit
+	mutex   sync.Mutex
+	rxQueue []rxItem
+	// nRF of the modem, management commands change them
+	registers ModemStatusRegisters
+	addresses ModemAddressRegisters
+	firmware  FirmwareVersion
+	// fault injection
+	responseDelay time.Duration
+	slaveDelay    time.Duration
+	ackTimeouts   int
+	wrongAddress  int
+	sendAck       bool
+}
+
+type rxItem struct {
+	code    responseCode
+	payload []byte
+}
+
+// WrongAddress is used as a source of injected responses from the wrong device
+var WrongAddress = TranscieverModel.Address{0xEE, 0xEE, 0xEE, 0xEE, 0xEE}
+
+// InitEmulator opens a pty and starts serving modem protocol on it
+func InitEmulator(em *ModemEmulator, air TranscieverModel.Transmitter) {
+	master, slave, err := pty.Open()
+	if nil != err {
+		panic(fmt.Errorf("UartTransciever.InitEmulator: pty.Open: %v", err))
+	}
+	em.master = master
+	em.slave = slave
+	em.air = air
+	em.sendAck = true
+	em.firmware = FirmwareVersion{Major: SupportedFirmwareMajor}
+	// nRF24L01 after reset, powered up
+	em.registers = ModemStatusRegisters{
+		Config: 0x0E, EnAA: 0x3F, EnRxAddr: 0x03, SetupAW: 0x03, SetupRetr: 0x03, RfCh: Register(TranscieverModel.DefaultRFChannel),
+		RfSetup: 0x0E, Status: 0x0E, FifoStatus: 0x11,
+	}
+	em.stopped = make(chan struct{})
+	go em.serve()
+}
+
+// PortName is the device name to use as [uart master] port
+func (em *ModemEmulator) PortName() string {
+	return em.slave.Name()
+}
+
+// Close the pty and wait for the serving goroutine, which exits on the read error
+func (em *ModemEmulator) Close() {
+	_ = em.master.Close()
+	_ = em.slave.Close()
+	<-em.stopped
+}
+
+// SetResponseDelay delays every modem answer on uart, more than uart transaction timeout makes the hub give up
+func (em *ModemEmulator) SetResponseDelay(d time.Duration) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.responseDelay = d
+}
+
+// SetSlaveDelay delays appearance of the slave response in the rx queue
+func (em *ModemEmulator) SetSlaveDelay(d time.Duration) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.slaveDelay = d
+}
+
+// SetFirmwareVersion changes the version the emulator reports
+func (em *ModemEmulator) SetFirmwareVersion(v FirmwareVersion) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.firmware = v
+}
+
+// SetSendAck controls if rAckPacket precedes every slave response
+func (em *ModemEmulator) SetSendAck(v bool) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.sendAck = v
+}
+
+// InjectAckTimeouts makes next count transmissions fail with rAckTimeout, without reaching the air
+func (em *ModemEmulator) InjectAckTimeouts(count int) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.ackTimeouts += count
+}
+
+// InjectWrongAddress makes next count slave responses be preceded by a data packet from WrongAddress
+func (em *ModemEmulator) InjectWrongAddress(count int) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.wrongAddress += count
+}
+
+func (em *ModemEmulator) pushRx(code responseCode, a TranscieverModel.Address, data []byte) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.rxQueue = append(em.rxQueue, rxItem{code: code, payload: append(a[:], data...)})
+}
+
+// parseRequest is the modem side of createRequest
+func parseRequest(data packet) (ret uartRequest, complete bool) {
+	if 3 > len(data) || 3+int(data[2]) != len(data) {
+		return ret, false
+	}
+	return uartRequest{
+		version: data[0],
+		command: command(data[1]),
+		payload: data[3:],
+	}, true
+}
+
+// createResponse is the modem side of parseResponse
+func createResponse(data uartResponse) (ret packet) {
+	ret = packet{data.version, byte(data.command), byte(data.code), byte(len(data.payload))}
+	return append(ret, data.payload...)
+}
+
+func (em *ModemEmulator) serve() {
+	defer close(em.stopped)
+	var buf packet
+	for {
+		chunk := make([]byte, 0x100)
+		n, err := em.master.Read(chunk)
+		if nil != err {
+			log.Debug(fmt.Sprintf("ModemEmulator.serve: read error %v, exiting", err))
+			return
+		}
+		buf = append(buf, chunk[:n]...)
+		for {
+			rq, rest, ok := em.nextRequest(buf)
+			if !ok {
+				break
+			}
+			buf = rest
+			em.respond(rq)
+		}
+	}
+}
+
+// nextRequest extracts the first complete packet from the uart stream
+func (em *ModemEmulator) nextRequest(buf packet) (rq uartRequest, rest packet, ok bool) {
+	// skip garbage before the start of a packet
+	for 0 < len(buf) && 0xC0 != buf[0] {
+		buf = buf[1:]
+	}
+	if 0 == len(buf) {
+		return rq, buf, false
+	}
+	end := 1
+	for end < len(buf) && 0xC0 != buf[end] {
+		end++
+	}
+	var data packet
+	if err := catchPanic(func() { data = unstuffPacket(buf[:end]) }); nil == err {
+		rq, ok = parseRequest(data)
+	}
+	if !ok {
+		if end < len(buf) {
+			// next packet has begun, this one will never complete
+			return em.nextRequest(buf[end:])
+		}
+		return rq, buf, false
+	}
+	return rq, buf[end:], true
+}
+
+func catchPanic(f func()) (err error) {
+	defer func() {
+		if r := recover(); nil != r {
+			err = errors.New(fmt.Sprint(r))
+		}
+	}()
+	f()
+	return nil
+}
+
+func (em *ModemEmulator) respond(rq uartRequest) {
+	rs := uartResponse{
+		version: 0,
+		command: rq.command | 0x80,
+		code:    rOk,
+		payload: []byte{},
+	}
+	if 0 != rq.version {
+		rs.code = rBadProtocolVersion
+	} else {
+		switch rq.command {
+		case cEcho:
+			rs.payload = rq.payload
+		case cTransmit:
+			rs.code = em.transmit(rq.payload)
+		case cGetRxItem:
+			em.mutex.Lock()
+			if 0 == len(em.rxQueue) {
+				rs.code = rNoPackets
+			} else {
+				rs.code = em.rxQueue[0].code
+				rs.payload = em.rxQueue[0].payload
+				em.rxQueue = em.rxQueue[1:]
+			}
+			em.mutex.Unlock()
+		case cClearRxQueue:
+			em.mutex.Lock()
+			em.rxQueue = nil
+			em.mutex.Unlock()
+		case cClearTxQueue:
+		case cSetRFChannel:
+			rs.code = em.setRFChannel(rq.payload)
+		case cFWVersion:
+			em.mutex.Lock()
+			rs.payload = []byte{em.firmware.Major, em.firmware.Minor}
+			em.mutex.Unlock()
+		case cModemStatus:
+			em.mutex.Lock()
+			em.registers.BufferPacketCount = byte(len(em.rxQueue))
+			rs.payload = em.encode(em.registers)
+			em.mutex.Unlock()
+		case cAddresses:
+			em.mutex.Lock()
+			rs.payload = em.encode(em.addresses)
+			em.mutex.Unlock()
+		case cSetTxPower, cSetBitRate, cSetAutoRetransmitDelay, cSetAutoRetransmitCount:
+			rs.code = em.setRegister(rq.command, rq.payload)
+		case cListen, cSetMasterSlaveMode, cSetMasterAddress:
+			rs.code = rNotImplemented
+		default:
+			rs.code = rBadCommand
+		}
+	}
+	em.mutex.Lock()
+	delay := em.responseDelay
+	em.mutex.Unlock()
+	time.Sleep(delay)
+	if _, err := em.master.Write(stuffPacket(createResponse(rs))); nil != err {
+		log.Warning(fmt.Sprintf("ModemEmulator.respond: write error %v", err))
+	}
+}
+
+func (em *ModemEmulator) encode(data interface{}) []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, data)
+	return b.Bytes()
+}
+
+// setRegister changes the nRF register of the radio setting command
+func (em *ModemEmulator) setRegister(c command, payload []byte) responseCode {
+	// argument limits and register bits of the commands
+	limits := map[command]byte{cSetTxPower: 3, cSetBitRate: 2, cSetAutoRetransmitDelay: 15, cSetAutoRetransmitCount: 15}
+	if 1 != len(payload) || limits[c] < payload[0] {
+		return rArgumentValidationError
+	}
+	v := Register(payload[0])
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	r := &em.registers
+	switch c {
+	case cSetTxPower:
+		r.RfSetup = r.RfSetup&^0x06 | v<<1
+	case cSetBitRate:
+		// RF_DR_LOW and RF_DR_HIGH
+		r.RfSetup = r.RfSetup &^ 0x28
+		if Register(BR2Mbps) == v {
+			r.RfSetup |= 0x08
+		} else if Register(BR250Kbps) == v {
+			r.RfSetup |= 0x20
+		}
+	case cSetAutoRetransmitDelay:
+		r.SetupRetr = r.SetupRetr&0x0F | v<<4
+	case cSetAutoRetransmitCount:
+		r.SetupRetr = r.SetupRetr&0xF0 | v
+	}
+	return rOk
+}
+
+// setRFChannel passes the channel to the air, if it has channels
+func (em *ModemEmulator) setRFChannel(payload []byte) responseCode {
+	if 1 != len(payload) || 128 <= payload[0] {
+		return rArgumentValidationError
+	}
+	if air, ok := em.air.(TranscieverModel.ChannelSetter); ok {
+		if err := air.SetRFChannel(payload[0]); nil != err {
+			log.Warning(fmt.Sprintf("ModemEmulator.setRFChannel: %v", err))
+			return rFail
+		}
+	}
+	em.mutex.Lock()
+	em.registers.RfCh = Register(payload[0])
+	em.mutex.Unlock()
+	return rOk
+}
+
+// transmit starts the radio transaction in background, as the modem does
+func (em *ModemEmulator) transmit(payload []byte) responseCode {
+	var a TranscieverModel.Address
+	if len(a) > len(payload) {
+		return rArgumentValidationError
+	}
+	copy(a[:], payload)
+	data := append(TranscieverModel.Payload{}, payload[len(a):]...)
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.addresses.TxAddr = Address(a)
+	em.addresses.RxAddrP0 = Address(a)
+	if 0 < em.ackTimeouts {
+		em.ackTimeouts--
+		em.rxQueue = append(em.rxQueue, rxItem{code: rAckTimeout, payload: a[:]})
+		return rOk
+	}
+	wrongAddress := 0 < em.wrongAddress
+	if wrongAddress {
+		em.wrongAddress--
+	}
+	delay, sendAck := em.slaveDelay, em.sendAck
+	go func() {
+		time.Sleep(delay)
+		m := em.air.SendCommand(a, data)
+		if sendAck {
+			em.pushRx(rAckPacket, a, nil)
+		}
+		if wrongAddress {
+			em.pushRx(rDataPacket, WrongAddress, m.Payload)
+		}
+		if TranscieverModel.EMSDataPacket == m.Status {
+			em.pushRx(rDataPacket, a, m.Payload)
+		} else {
+			em.pushRx(rSlaveResponseTimeout, a, nil)
+		}
+	}()
+	return rOk
+}
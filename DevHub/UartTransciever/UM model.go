@@ -1,7 +1,10 @@
 package UartTransciever
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"sync"
 	"time"
@@ -19,25 +22,40 @@ type UMTransmitter struct {
 	ReceiveMessage chan TranscieverModel.Message
 	//SendMessage       chan Message
 	SendMessageStatus chan TranscieverModel.Message
-	mutex             sync.Mutex
+	// complete packets from the reader, the transaction takes the response from them
+	// the reader is the only one reading the port, a late response can not be taken by the next reader
+	packets chan []byte
+	// the error the reader has stopped on, valid after packets is closed
+	readError error
+	// until when the response of the abandoned transaction can still come
+	abandoned time.Time
+	// stop is closed by Close, stopped is closed by the reader on exit
+	stop            chan struct{}
+	stopped         chan struct{}
+	closeOnce       sync.Once
+	mutex           sync.Mutex
 	sendCommandLock sync.Mutex
 }
 
+// transactionTimeout of the modem response, TODO read from config
+const transactionTimeout = 500 * time.Millisecond
+
+// readTimeout of the port, the reader checks stop this often
+const readTimeout = 100 * time.Millisecond
+
 // TransmitterSettings ...
 type TransmitterSettings struct {
 	PortName string
 	Speed    int
 }
 
-// Init ...
+// Init opens the port and checks the modem with the handshake
 func Init(tr *UMTransmitter, settings TransmitterSettings) {
-	tr.mutex.Lock()
-	defer tr.mutex.Unlock()
 	log.Formatter = new(logrus.TextFormatter)
 	log.Level = logrus.InfoLevel
 	log.Out = os.Stdout
 	log.Info(fmt.Sprintf("OpenTransmitter begin"))
-	c := &serial.Config{Name: settings.PortName, Baud: settings.Speed}
+	c := &serial.Config{Name: settings.PortName, Baud: settings.Speed, ReadTimeout: readTimeout}
 	port, err := serial.OpenPort(c)
 	if err != nil {
 		panic(fmt.Errorf("serial.OpenPort(%v): %v", settings.PortName, err.Error()))
@@ -50,83 +68,154 @@ func Init(tr *UMTransmitter, settings TransmitterSettings) {
 			panic(r)
 		}
 	}()
+	// a few late responses, the transaction drops them before the request
+	tr.packets = make(chan []byte, 4)
+	tr.stop = make(chan struct{})
+	tr.stopped = make(chan struct{})
 	go run(tr)
+	if err := handshake(tr); nil != err {
+		panic(fmt.Errorf("UartTransciever.Init(%v): %v", settings.PortName, err))
+	}
 }
 
-// Close port (in current library does nothing)
+// Close — stop the reader and close the port
 func (tr *UMTransmitter) Close() {
-	/*if nil != tr.port {
-		_ = tr.port.Close()
-	}*/
+	tr.closeOnce.Do(func() {
+		if nil != tr.stop {
+			close(tr.stop)
+		}
+		if nil != tr.stopped {
+			<-tr.stopped
+		}
+		if nil != tr.port {
+			_ = tr.port.Close()
+		}
+	})
 }
 
+// run is the reader of the port, it passes complete packets to the transactions
 func run(tr *UMTransmitter) {
-
+	defer close(tr.stopped)
+	defer close(tr.packets)
+	var bigBuf []byte
+	for {
+		select {
+		case <-tr.stop:
+			tr.readError = errors.New("rf.port is closed")
+			return
+		default:
+		}
+		buf := make([]byte, 0x100)
+		n, err := tr.port.Read(buf)
+		// read timeout
+		if io.EOF == err {
+			continue
+		}
+		if nil != err {
+			tr.readError = fmt.Errorf("rf.port.Read error: %v", err)
+			return
+		}
+		bigBuf = append(bigBuf, buf[:n]...)
+		if !isPacketComplete(bigBuf) {
+			continue
+		}
+		select {
+		case tr.packets <- bigBuf:
+		default:
+			log.Warn(fmt.Sprintf("UartTransciever.run: nobody waits for %v, dropped", bigBuf))
+		}
+		bigBuf = nil
+	}
 }
 
-func uartTransaction(rf *UMTransmitter, data []byte) []byte {
+// uartTransaction writes the request and waits for the complete response packet
+func uartTransaction(ctx context.Context, rf *UMTransmitter, data []byte) ([]byte, error) {
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
+	// the modem answers requests in order, so the late response of the abandoned transaction comes first
+	if wait := time.Until(rf.abandoned); 0 < wait {
+		select {
+		case rs, ok := <-rf.packets:
+			if !ok {
+				return nil, rf.readError
+			}
+			log.Debug(fmt.Sprintf("uartTransaction(%v) late response %v dropped", data, rs))
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		rf.abandoned = time.Time{}
+	}
+	for drained := false; !drained; {
+		select {
+		case rs, ok := <-rf.packets:
+			if !ok {
+				return nil, rf.readError
+			}
+			log.Warn(fmt.Sprintf("uartTransaction(%v) unexpected packet %v dropped", data, rs))
+		default:
+			drained = true
+		}
+	}
 	_, err := rf.port.Write(data)
 	if nil != err {
-		panic(fmt.Errorf("rf.port.Write error: %v", err))
+		return nil, fmt.Errorf("rf.port.Write error: %v", err)
 	}
-	receive := make(chan []byte)
-	go func() {
-		var bigBuf []byte
-		for {
-			buf := make([]byte, 0x100)
-			n, err := rf.port.Read(buf)
-			if nil != err {
-				panic(fmt.Errorf("rf.port.Read error: %v", err))
-			}
-			bigBuf = append(bigBuf, buf[:n]...)
-			if isPacketComplete(bigBuf) {
-				break
-			}
+	select {
+	case rs, ok := <-rf.packets:
+		if !ok {
+			return nil, rf.readError
+		}
+		log.Debug(fmt.Sprintf("uartTransaction(%v) data %v", data, rs))
+		return rs, nil
+	case <-time.After(transactionTimeout):
+		rf.abandoned = time.Now().Add(transactionTimeout)
+		return nil, fmt.Errorf("uartTransaction response timeout. Request %v", data)
+	case <-ctx.Done():
+		rf.abandoned = time.Now().Add(transactionTimeout)
+		return nil, ctx.Err()
+	}
+}
+
+// modemCommand makes uart transaction and returns validated modem response
+func modemCommand(ctx context.Context, rf *UMTransmitter, rq uartRequest) (rs uartResponse, err error) {
+	response, err := uartTransaction(ctx, rf, stuffPacket(createRequest(rq)))
+	if nil != err {
+		return rs, err
+	}
+	defer func() {
+		if r := recover(); nil != r {
+			err = fmt.Errorf("modem response parsing failed. Command %v, response %v: %v", rq.command, response, r)
 		}
-		log.Debug(fmt.Sprintf("uartTransaction(%v) data %v", data, bigBuf))
-		receive <- bigBuf
-	}()
-	timeout := make(chan bool)
-	go func() {
-		// TODO read from config
-		<-time.After(500 * time.Millisecond)
-		timeout <- true
 	}()
-	select {
-	case rs := <-receive:
-		return rs
-	case <-timeout:
-		panic(fmt.Errorf("uartTransaction response timeout. Request %v", data))
+	rs = parseResponse(unstuffPacket(response))
+	if !validateResponse(rs, rq.command) {
+		return rs, fmt.Errorf("modem response validation failed. Command %v, payload %v, response %v", rq.command, rq.payload, response)
 	}
+	return rs, nil
 }
 
-func transmit(rf *UMTransmitter, a TranscieverModel.Address, data TranscieverModel.Payload) {
+func transmit(ctx context.Context, rf *UMTransmitter, a TranscieverModel.Address, data TranscieverModel.Payload) error {
 	rq := uartRequest{
 		command: cTransmit,
 		payload: append(a[:], data...),
 	}
-	response := uartTransaction(rf, stuffPacket(createRequest(rq)))
-	rs := parseResponse(unstuffPacket(response))
-	if !validateResponse(rs, cTransmit) {
-		panic(fmt.Errorf("modem response validation failed. Command %v, payload %v, response %v", cTransmit, data, response))
+	rs, err := modemCommand(ctx, rf, rq)
+	if nil != err {
+		return err
 	}
 	// here should be not ok if RF response queue was not empty
 	if rOk != rs.code {
-		panic(fmt.Errorf("modem response code is not ok. Request %v, response %v", rq, rs))
+		return fmt.Errorf("modem response code is not ok. Request %v, response %v", rq, rs)
 	}
+	return nil
 }
 
-func getRxItem(rf *UMTransmitter) (ret TranscieverModel.Message) {
+func getRxItem(ctx context.Context, rf *UMTransmitter) (ret TranscieverModel.Message, err error) {
 	ret = TranscieverModel.Message{}
-	rq := uartRequest{
-		command: cGetRxItem,
-	}
-	response := uartTransaction(rf, stuffPacket(createRequest(rq)))
-	rs := parseResponse(unstuffPacket(response))
-	if !validateResponse(rs, cGetRxItem) {
-		panic(fmt.Errorf("modem response validation failed. Command %v, response %v", cGetRxItem, response))
+	rs, err := modemCommand(ctx, rf, uartRequest{command: cGetRxItem})
+	if nil != err {
+		return ret, err
 	}
 	codeToStatus := map[responseCode]TranscieverModel.EMessageStatus{
 		rNoPackets:            TranscieverModel.EMSNone,
@@ -142,20 +231,50 @@ func getRxItem(rf *UMTransmitter) (ret TranscieverModel.Message) {
 	if len(ret.Address) < len(rs.payload) {
 		ret.Payload = rs.payload[len(ret.Address):]
 	}
+	return ret, nil
+}
+
+// SendCommand panics on modem failures, see SendCommandContext
+func (tr *UMTransmitter) SendCommand(a TranscieverModel.Address, data TranscieverModel.Payload) TranscieverModel.Message {
+	ret, err := tr.SendCommandContext(context.Background(), a, data)
+	if nil != err {
+		panic(err)
+	}
 	return ret
 }
 
-// SendCommand commands modem to make a transaction to a given slave device and polls for the response
-func (tr *UMTransmitter) SendCommand(a TranscieverModel.Address, data TranscieverModel.Payload) (ret TranscieverModel.Message) {
+// SendCommandContext commands modem to make a transaction to a given slave device and polls for the response
+// errors are modem failures only, device not responding is reported by the message status
+func (tr *UMTransmitter) SendCommandContext(ctx context.Context, a TranscieverModel.Address, data TranscieverModel.Payload) (TranscieverModel.Message, error) {
 	tr.sendCommandLock.Lock()
 	defer tr.sendCommandLock.Unlock()
 	log.Debug(fmt.Sprintf("UM.SendCommand(%v, %v): transmit", a, data))
-	transmit(tr, a, data)
-	response := make(chan TranscieverModel.Message)
-	timeout := make(chan bool)
+	if err := transmit(ctx, tr, a, data); nil != err {
+		return TranscieverModel.Message{}, err
+	}
+	// buffered and joined, so polling does not outlive SendCommand and steal the next responses
+	response := make(chan TranscieverModel.Message, 1)
+	timeout := make(chan bool, 1)
+	failure := make(chan error, 1)
+	pollCtx, stop := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	defer func() {
+		stop()
+		<-stopped
+	}()
 	go func() {
+		defer close(stopped)
 		for {
-			msg := getRxItem(tr)
+			select {
+			case <-pollCtx.Done():
+				return
+			default:
+			}
+			msg, err := getRxItem(pollCtx, tr)
+			if nil != err {
+				failure <- err
+				return
+			}
 			switch msg.Status {
 			default:
 				continue
@@ -166,6 +285,7 @@ func (tr *UMTransmitter) SendCommand(a TranscieverModel.Address, data Transcieve
 			// wasn't even sent
 			case TranscieverModel.EMSAckTimeout:
 				log.Debug(fmt.Sprintf("UM.SendCommand(%v, %v).goroutine: received ack timeout from a modem", a, data))
+				ackTimeouts.Inc()
 				timeout <- false
 				return
 			// we need one of those to return
@@ -178,29 +298,35 @@ func (tr *UMTransmitter) SendCommand(a TranscieverModel.Address, data Transcieve
 				return
 			}
 			log.Warning(fmt.Sprintf("UMModel.SendCommand(%v, %v) got response from the wrong address %v", a, data, msg))
+			wrongAddressResponses.Inc()
 		}
 	}()
-	/*go func() {
-		// in case modem won't say anything about timeout
-		<-time.After(1000 * time.Millisecond)
-		log.Warning(fmt.Sprintf("UMModel.SendCommand(%v, %v) modem did not generated any response packet in 1000ms", a, data))
-		timeout <- true
-	}()*/
 	select {
 	case msg := <-response:
 		log.Debug(fmt.Sprintf("UM.SendCommand(%v, %v): returning response %v", a, data, msg))
-		return msg
+		return msg, nil
+	case err := <-failure:
+		return TranscieverModel.Message{}, err
 	case <-timeout:
 		log.Warning(fmt.Sprintf("UMModel.SendCommand(%v, %v): returning RF response timeout", a, data))
 		return TranscieverModel.Message{
 			Address: a,
 			Status:  TranscieverModel.EMSNone,
-		}
+		}, nil
 	case <-time.After(1000 * time.Millisecond):
 		log.Warning(fmt.Sprintf("UMModel.SendCommand(%v, %v) modem did not generated any response packet in 1000ms", a, data))
+		modemSilences.Inc()
 		return TranscieverModel.Message{
 			Address: a,
 			Status:  TranscieverModel.EMSNone,
-		}
+		}, nil
+	case <-ctx.Done():
+		return TranscieverModel.Message{}, ctx.Err()
 	}
 }
+
+// SetRFChannel commands modem to switch the RF channel
+func (tr *UMTransmitter) SetRFChannel(channel byte) error {
+	_, err := tr.managementCommand(cSetRFChannel, []byte{channel})
+	return err
+}
@@ -0,0 +1,176 @@
+//go:build linux
+// +build linux
+
+package UartTransciever
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"../TranscieverModel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var testAddress = TranscieverModel.Address{0xAA, 0xAA, 0xAA, 0xAA, 0x01}
+
+// echoAir answers every request with the same payload, except for the silent address
+type echoAir struct {
+	silent TranscieverModel.Address
+}
+
+func (a *echoAir) Close() {}
+
+func (a *echoAir) SendCommand(address TranscieverModel.Address, data TranscieverModel.Payload) TranscieverModel.Message {
+	if address == a.silent {
+		return TranscieverModel.Message{Address: address, Status: TranscieverModel.EMSSlaveTimeout}
+	}
+	return TranscieverModel.Message{Address: address, Payload: data, Status: TranscieverModel.EMSDataPacket}
+}
+
+func initTestModem(t *testing.T) (*ModemEmulator, *UMTransmitter) {
+	var em ModemEmulator
+	InitEmulator(&em, &echoAir{silent: TranscieverModel.Address{1, 2, 3, 4, 5}})
+	t.Cleanup(em.Close)
+	var tr UMTransmitter
+	Init(&tr, TransmitterSettings{PortName: em.PortName(), Speed: 115200})
+	t.Cleanup(tr.Close)
+	return &em, &tr
+}
+
+func Test_uartTransaction(t *testing.T) {
+	_, tr := initTestModem(t)
+	ctx := context.Background()
+	rs, err := modemCommand(ctx, tr, uartRequest{command: cEcho, payload: []byte{0xC0, 0xDB, 1}})
+	if nil != err || rOk != rs.code || !reflect.DeepEqual([]byte{0xC0, 0xDB, 1}, []byte(rs.payload)) {
+		t.Errorf("unexpected echo response %v, error %v", rs, err)
+	}
+	response, err := uartTransaction(ctx, tr, stuffPacket(createRequest(uartRequest{version: 1, command: cEcho})))
+	if nil != err {
+		t.Fatalf("uartTransaction: %v", err)
+	}
+	if rs = parseResponse(unstuffPacket(response)); rBadProtocolVersion != rs.code {
+		t.Errorf("expected bad protocol version, got %v", rs)
+	}
+}
+
+func Test_transmit_getRxItem(t *testing.T) {
+	em, tr := initTestModem(t)
+	ctx := context.Background()
+	if msg, err := getRxItem(ctx, tr); nil != err || TranscieverModel.EMSNone != msg.Status {
+		t.Errorf("expected no packets, got %v, error %v", msg, err)
+	}
+	em.SetSendAck(false)
+	if err := transmit(ctx, tr, testAddress, TranscieverModel.Payload{1, 2, 3}); nil != err {
+		t.Fatalf("transmit: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	msg, err := getRxItem(ctx, tr)
+	if nil != err || TranscieverModel.EMSDataPacket != msg.Status || testAddress != msg.Address || !reflect.DeepEqual(TranscieverModel.Payload{1, 2, 3}, msg.Payload) {
+		t.Errorf("unexpected rx item %v", msg)
+	}
+}
+
+func TestSendCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		address TranscieverModel.Address
+		inject  func(em *ModemEmulator)
+		status  TranscieverModel.EMessageStatus
+		payload TranscieverModel.Payload
+		counter prometheus.Counter // increased by one
+	}{
+		{
+			name:    "response",
+			address: testAddress,
+			inject:  func(em *ModemEmulator) {},
+			status:  TranscieverModel.EMSDataPacket,
+			payload: TranscieverModel.Payload{0, 1, 2},
+		},
+		{
+			name:    "response from the wrong address first",
+			address: testAddress,
+			inject:  func(em *ModemEmulator) { em.InjectWrongAddress(1) },
+			status:  TranscieverModel.EMSDataPacket,
+			payload: TranscieverModel.Payload{0, 1, 2},
+			counter: wrongAddressResponses,
+		},
+		{
+			name:    "slow slave",
+			address: testAddress,
+			inject:  func(em *ModemEmulator) { em.SetSlaveDelay(300 * time.Millisecond) },
+			status:  TranscieverModel.EMSDataPacket,
+			payload: TranscieverModel.Payload{0, 1, 2},
+		},
+		{
+			name:    "slave timeout",
+			address: TranscieverModel.Address{1, 2, 3, 4, 5},
+			inject:  func(em *ModemEmulator) {},
+			status:  TranscieverModel.EMSSlaveTimeout,
+		},
+		{
+			name:    "ack timeout",
+			address: testAddress,
+			inject:  func(em *ModemEmulator) { em.InjectAckTimeouts(1) },
+			status:  TranscieverModel.EMSNone,
+			counter: ackTimeouts,
+		},
+		{
+			name:    "modem does not report anything",
+			address: testAddress,
+			inject:  func(em *ModemEmulator) { em.SetSlaveDelay(1500 * time.Millisecond) },
+			status:  TranscieverModel.EMSNone,
+			counter: modemSilences,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			em, tr := initTestModem(t)
+			tt.inject(em)
+			var before float64
+			if nil != tt.counter {
+				before = testutil.ToFloat64(tt.counter)
+			}
+			msg := tr.SendCommand(tt.address, TranscieverModel.Payload{0, 1, 2})
+			if nil != tt.counter && before+1 != testutil.ToFloat64(tt.counter) {
+				t.Errorf("counter is %v, was %v", testutil.ToFloat64(tt.counter), before)
+			}
+			if tt.status != msg.Status || tt.address != msg.Address {
+				t.Errorf("SendCommand() = %v, want status %v", msg, tt.status)
+			}
+			if nil != tt.payload && !reflect.DeepEqual(tt.payload, msg.Payload) {
+				t.Errorf("SendCommand() payload = %v, want %v", msg.Payload, tt.payload)
+			}
+		})
+	}
+}
+
+func Test_uartTransaction_timeout(t *testing.T) {
+	em, tr := initTestModem(t)
+	em.SetResponseDelay(700 * time.Millisecond)
+	if _, err := uartTransaction(context.Background(), tr, stuffPacket(createRequest(uartRequest{command: cEcho}))); nil == err {
+		t.Error("no error on modem response delay")
+	}
+	// the late response is not the answer to the next request
+	em.SetResponseDelay(0)
+	rs, err := modemCommand(context.Background(), tr, uartRequest{command: cEcho, payload: []byte{7}})
+	if nil != err || !reflect.DeepEqual([]byte{7}, []byte(rs.payload)) {
+		t.Errorf("unexpected echo response %v, error %v", rs, err)
+	}
+}
+
+func TestSendCommandContext_cancel(t *testing.T) {
+	em, tr := initTestModem(t)
+	em.SetSlaveDelay(300 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := tr.SendCommandContext(ctx, testAddress, TranscieverModel.Payload{0}); context.DeadlineExceeded != err {
+		t.Errorf("SendCommandContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// the response to the cancelled poll is consumed, the next command gets its own one
+	if rs, err := tr.Echo([]byte{7}); nil != err || !reflect.DeepEqual([]byte{7}, rs) {
+		t.Errorf("Echo() after cancel = %v, %v", rs, err)
+	}
+}
@@ -0,0 +1,214 @@
+package UartTransciever
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FirmwareVersion of the modem, the hub works with the firmware of SupportedFirmwareMajor
+type FirmwareVersion struct {
+	Major byte
+	Minor byte
+}
+
+func (v FirmwareVersion) String() string {
+	return fmt.Sprintf("%v.%v", v.Major, v.Minor)
+}
+
+// SupportedFirmwareMajor is the protocol the hub speaks, minor versions only add commands
+const SupportedFirmwareMajor byte = 1
+
+// BitRate of the modem radio, devices have to use the same one
+type BitRate byte
+
+const (
+	BR1Mbps BitRate = iota
+	BR2Mbps
+	BR250Kbps
+)
+
+var bitRateNames = map[BitRate]string{
+	BR250Kbps: "250kbps",
+	BR1Mbps:   "1mbps",
+	BR2Mbps:   "2mbps",
+}
+
+func (r BitRate) String() string {
+	if name, ok := bitRateNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("BitRate(%v)", byte(r))
+}
+
+// ParseBitRate takes 250kbps, 1mbps and 2mbps
+func ParseBitRate(s string) (BitRate, error) {
+	for rate, name := range bitRateNames {
+		if strings.EqualFold(name, strings.TrimSpace(s)) {
+			return rate, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown bit rate <%v>, it is one of 250kbps, 1mbps, 2mbps", s)
+}
+
+// tx power levels in dBm by the cSetTxPower argument
+var txPowerLevels = []int{-18, -12, -6, 0}
+
+// retransmitDelayStep is the unit of cSetAutoRetransmitDelay, 0 is 250us and 15 is 4ms
+const retransmitDelayStep = 250 * time.Microsecond
+
+var responseCodeNames = map[responseCode]string{
+	rOk:                      "rOk",
+	rNoPackets:               "rNoPackets",
+	rSlaveResponseTimeout:    "rSlaveResponseTimeout",
+	rAckTimeout:              "rAckTimeout",
+	rDataPacket:              "rDataPacket",
+	rAckPacket:               "rAckPacket",
+	rFail:                    "rFail",
+	rBadProtocolVersion:      "rBadProtocolVersion",
+	rBadCommand:              "rBadCommand",
+	rMemoryError:             "rMemoryError",
+	rArgumentValidationError: "rArgumentValidationError",
+	rNotImplemented:          "rNotImplemented",
+}
+
+func (c responseCode) String() string {
+	if name, ok := responseCodeNames[c]; ok {
+		return fmt.Sprintf("%v (0x%02X)", name, byte(c))
+	}
+	return fmt.Sprintf("0x%02X", byte(c))
+}
+
+// managementCommand is the modem command, which is answered with rOk
+func (tr *UMTransmitter) managementCommand(c command, payload []byte) ([]byte, error) {
+	tr.sendCommandLock.Lock()
+	defer tr.sendCommandLock.Unlock()
+	rs, err := modemCommand(context.Background(), tr, uartRequest{command: c, payload: payload})
+	if nil != err {
+		return nil, err
+	}
+	if rOk != rs.code {
+		return nil, fmt.Errorf("modem response code is %v. Command 0x%02X, payload %v", rs.code, byte(c), payload)
+	}
+	return rs.payload, nil
+}
+
+// Echo returns the payload the modem answered
+func (tr *UMTransmitter) Echo(payload []byte) ([]byte, error) {
+	return tr.managementCommand(cEcho, payload)
+}
+
+// FirmwareVersion asks the modem for its firmware version
+func (tr *UMTransmitter) FirmwareVersion() (ret FirmwareVersion, err error) {
+	payload, err := tr.managementCommand(cFWVersion, nil)
+	if nil != err {
+		return ret, err
+	}
+	if 2 > len(payload) {
+		return ret, fmt.Errorf("modem firmware version %v is too short", payload)
+	}
+	return FirmwareVersion{Major: payload[0], Minor: payload[1]}, nil
+}
+
+// readStruct fills the struct of bytes with the response payload of the command
+func (tr *UMTransmitter) readStruct(c command, ret interface{}) error {
+	payload, err := tr.managementCommand(c, nil)
+	if nil != err {
+		return err
+	}
+	if binary.Size(ret) != len(payload) {
+		return fmt.Errorf("modem response to 0x%02X has %v bytes instead of %v", byte(c), len(payload), binary.Size(ret))
+	}
+	return binary.Read(bytes.NewReader(payload), binary.LittleEndian, ret)
+}
+
+// ModemStatus reads the nRF registers of the modem and the count of packets in its rx queue
+func (tr *UMTransmitter) ModemStatus() (ret ModemStatusRegisters, err error) {
+	err = tr.readStruct(cModemStatus, &ret)
+	return ret, err
+}
+
+// Addresses reads the nRF address registers of the modem
+func (tr *UMTransmitter) Addresses() (ret ModemAddressRegisters, err error) {
+	err = tr.readStruct(cAddresses, &ret)
+	return ret, err
+}
+
+// SetTxPower sets the power in dBm, one of -18, -12, -6, 0
+func (tr *UMTransmitter) SetTxPower(dBm int) error {
+	for level, value := range txPowerLevels {
+		if value == dBm {
+			_, err := tr.managementCommand(cSetTxPower, []byte{byte(level)})
+			return err
+		}
+	}
+	return fmt.Errorf("tx power %vdBm is not one of %v", dBm, txPowerLevels)
+}
+
+// SetBitRate sets the bit rate of the modem radio
+func (tr *UMTransmitter) SetBitRate(rate BitRate) error {
+	if _, ok := bitRateNames[rate]; !ok {
+		return fmt.Errorf("unknown bit rate %v", rate)
+	}
+	_, err := tr.managementCommand(cSetBitRate, []byte{byte(rate)})
+	return err
+}
+
+// SetAutoRetransmitDelay sets the delay of retransmits, 250us..4ms by 250us
+func (tr *UMTransmitter) SetAutoRetransmitDelay(delay time.Duration) error {
+	if retransmitDelayStep > delay || 16*retransmitDelayStep < delay || 0 != delay%retransmitDelayStep {
+		return fmt.Errorf("retransmit delay %v is not 250us..4ms by 250us", delay)
+	}
+	_, err := tr.managementCommand(cSetAutoRetransmitDelay, []byte{byte(delay/retransmitDelayStep - 1)})
+	return err
+}
+
+// SetAutoRetransmitCount sets the count of retransmits, 0..15
+func (tr *UMTransmitter) SetAutoRetransmitCount(count byte) error {
+	if 15 < count {
+		return fmt.Errorf("retransmit count %v is not in 0..15", count)
+	}
+	_, err := tr.managementCommand(cSetAutoRetransmitCount, []byte{count})
+	return err
+}
+
+// ClearTxQueue drops the packets the modem did not transmit yet
+func (tr *UMTransmitter) ClearTxQueue() error {
+	_, err := tr.managementCommand(cClearTxQueue, nil)
+	return err
+}
+
+// ClearRxQueue drops the received packets, which were not taken by cGetRxItem
+func (tr *UMTransmitter) ClearRxQueue() error {
+	_, err := tr.managementCommand(cClearRxQueue, nil)
+	return err
+}
+
+// handshake checks that the modem answers and speaks the protocol of the hub
+// the echo has the bytes, which are stuffed, so the port settings are checked too
+func handshake(tr *UMTransmitter) error {
+	echo := []byte{0xC0, 0xDB, 0x55, 0xAA, 0x00, 0xFF}
+	answer, err := tr.Echo(echo)
+	if nil != err {
+		return fmt.Errorf("modem does not answer echo, check the port and its speed: %v", err)
+	}
+	if !bytes.Equal(echo, answer) {
+		return fmt.Errorf("modem echo is %v instead of %v", answer, echo)
+	}
+	version, err := tr.FirmwareVersion()
+	if nil != err {
+		return fmt.Errorf("modem firmware version: %v", err)
+	}
+	if SupportedFirmwareMajor != version.Major {
+		return fmt.Errorf("modem firmware %v is not compatible, the hub needs %v.x", version, SupportedFirmwareMajor)
+	}
+	log.Info(fmt.Sprintf("UM: modem firmware %v", version))
+	// responses of the previous run of the hub are not taken for the responses of this one
+	if err := tr.ClearRxQueue(); nil != err {
+		return fmt.Errorf("modem rx queue: %v", err)
+	}
+	return nil
+}
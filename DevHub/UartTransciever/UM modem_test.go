@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package UartTransciever
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"../TranscieverModel"
+)
+
+func TestModemManagement(t *testing.T) {
+	_, tr := initTestModem(t)
+	if version, err := tr.FirmwareVersion(); nil != err || (FirmwareVersion{Major: SupportedFirmwareMajor}) != version {
+		t.Errorf("firmware version %v, error %v", version, err)
+	}
+	for name, err := range map[string]error{
+		"channel":          tr.SetRFChannel(76),
+		"power":            tr.SetTxPower(-6),
+		"bit rate":         tr.SetBitRate(BR250Kbps),
+		"retransmit delay": tr.SetAutoRetransmitDelay(time.Millisecond),
+		"retransmit count": tr.SetAutoRetransmitCount(15),
+	} {
+		if nil != err {
+			t.Errorf("%v: %v", name, err)
+		}
+	}
+	for name, err := range map[string]error{
+		"channel":          tr.SetRFChannel(200),
+		"power":            tr.SetTxPower(-3),
+		"bit rate":         tr.SetBitRate(3),
+		"retransmit delay": tr.SetAutoRetransmitDelay(300 * time.Microsecond),
+		"retransmit count": tr.SetAutoRetransmitCount(16),
+	} {
+		if nil == err {
+			t.Errorf("bad %v is set", name)
+		}
+	}
+	if err := transmit(context.Background(), tr, testAddress, TranscieverModel.Payload{1}); nil != err {
+		t.Fatalf("transmit: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	status, err := tr.ModemStatus()
+	if nil != err || 76 != status.RfCh || 0x24 != status.RfSetup || 0x3F != status.SetupRetr || 0 == status.BufferPacketCount {
+		t.Errorf("modem status %+v, error %v", status, err)
+	}
+	if addresses, err := tr.Addresses(); nil != err || Address(testAddress) != addresses.TxAddr {
+		t.Errorf("modem addresses %+v, error %v", addresses, err)
+	}
+	if err := tr.ClearRxQueue(); nil != err {
+		t.Errorf("ClearRxQueue: %v", err)
+	}
+	if status, err = tr.ModemStatus(); nil != err || 0 != status.BufferPacketCount {
+		t.Errorf("modem status after ClearRxQueue %+v, error %v", status, err)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	var em ModemEmulator
+	InitEmulator(&em, &echoAir{})
+	defer em.Close()
+	em.SetFirmwareVersion(FirmwareVersion{Major: SupportedFirmwareMajor + 1})
+	defer func() {
+		if r := recover(); nil == r || !strings.Contains(fmt.Sprint(r), "is not compatible") {
+			t.Errorf("incompatible modem is opened: %v", r)
+		}
+	}()
+	var tr UMTransmitter
+	Init(&tr, TransmitterSettings{PortName: em.PortName(), Speed: 115200})
+}
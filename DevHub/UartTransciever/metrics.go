@@ -0,0 +1,22 @@
+package UartTransciever
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// prometheus metrics of SendCommand
+var (
+	ackTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "devhub", Subsystem: "uart", Name: "ack_timeouts_total",
+		Help: "Requests the modem could not deliver to the device.",
+	})
+	wrongAddressResponses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "devhub", Subsystem: "uart", Name: "wrong_address_responses_total",
+		Help: "Responses from other devices than the request was sent to.",
+	})
+	modemSilences = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "devhub", Subsystem: "uart", Name: "modem_silences_total",
+		Help: "Requests the modem did not answer with any packet.",
+	})
+)
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"./Cache"
+	"gopkg.in/ini.v1"
+)
+
+// checkConfig validates devices.json the way the hub does on start and prints every problem of it
+// it does not need the radio, so it runs on any machine
+func checkConfig(settings *ini.File, args []string) error {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	devicesFile := flags.String("devices", settings.Section("").Key("devices").String(), "devices file to check")
+	if err := flags.Parse(args); nil != err {
+		return err
+	}
+	devices, err := Cache.ReadDevices(*devicesFile)
+	if configError, ok := err.(*Cache.ConfigError); ok {
+		for _, problem := range configError.Problems {
+			fmt.Println(problem)
+		}
+		return fmt.Errorf("%v has %v problems", *devicesFile, len(configError.Problems))
+	}
+	if nil != err {
+		return err
+	}
+	functions := 0
+	for _, device := range devices {
+		for _, unit := range device.Units {
+			functions += len(unit.Functions)
+		}
+	}
+	fmt.Printf("%v is ok: %v devices, %v functions\n", *devicesFile, len(devices), functions)
+	return nil
+}
@@ -0,0 +1,127 @@
+// rfctl calls a single function of a device from the shell:
+//
+//	rfctl read AA:AA:AA:AA:01 1 0x10
+//	rfctl write AA:AA:AA:AA:01 1 0x11 true
+//	rfctl raw AA:AA:AA:AA:01 1 0x10 0102
+//	rfctl describe AA:AA:AA:AA:01
+//
+// it goes through the running hub, if [rfctl] socket of settings.ini is served, and opens the transmitter itself otherwise
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"../../RFControl"
+	"../../RFModel"
+	"../../Radio"
+	"gopkg.in/ini.v1"
+)
+
+const usage = `usage: rfctl [flags] command
+  read ADDRESS UNIT FUNCTION
+  write ADDRESS UNIT FUNCTION VALUE
+  raw ADDRESS UNIT FUNCTION [HEX PAYLOAD]
+  describe ADDRESS
+numbers are decimal or 0x hex, byte array values are hex
+flags:
+`
+
+func main() {
+	settingsFile := flag.String("settings", "settings.ini", "hub settings file")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of the command")
+	local := flag.Bool("local", false, "use the transmitter even if the hub is running")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	request, err := parseRequest(flag.Args())
+	if nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	settings, err := ini.Load(*settingsFile)
+	if nil != err {
+		fmt.Fprintf(os.Stderr, "unable to load %v, %v\n", *settingsFile, err)
+		os.Exit(1)
+	}
+	output, err := execute(settings, request, *timeout, *local)
+	if "" != output {
+		fmt.Println(output)
+	}
+	if nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// execute sends the request to the hub, the transmitter can not be shared with it
+func execute(settings *ini.File, request RFControl.Request, timeout time.Duration, local bool) (output string, err error) {
+	if socket := settings.Section("rfctl").Key("socket").String(); !local && "" != socket {
+		output, err := RFControl.Call(socket, request, timeout)
+		if !errors.Is(err, RFControl.ErrNoHub) {
+			return output, err
+		}
+	}
+	defer func() {
+		// transmitters panic when they fail to open
+		if r := recover(); nil != r {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	var model RFModel.RFModel
+	Radio.Open(settings, &model)
+	defer model.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return RFControl.Execute(ctx, &model, request)
+}
+
+func parseRequest(args []string) (ret RFControl.Request, err error) {
+	if 2 > len(args) {
+		return ret, fmt.Errorf("command and address are required")
+	}
+	ret.Command, ret.Address = args[0], args[1]
+	// least and most arguments
+	counts := map[string][2]int{
+		RFControl.CRead:     {4, 4},
+		RFControl.CWrite:    {5, 5},
+		RFControl.CRaw:      {4, 5},
+		RFControl.CDescribe: {2, 2},
+	}
+	count, ok := counts[ret.Command]
+	if !ok {
+		return ret, fmt.Errorf("unknown command %v", ret.Command)
+	}
+	if count[0] > len(args) || count[1] < len(args) {
+		return ret, fmt.Errorf("wrong number of %v arguments", ret.Command)
+	}
+	if RFControl.CDescribe == ret.Command {
+		return ret, nil
+	}
+	if ret.Unit, err = parseByte("unit", args[2]); nil != err {
+		return ret, err
+	}
+	if ret.Function, err = parseByte("function", args[3]); nil != err {
+		return ret, err
+	}
+	if 4 < len(args) {
+		ret.Value = args[4]
+	}
+	return ret, nil
+}
+
+func parseByte(name string, s string) (byte, error) {
+	ret, err := strconv.ParseUint(s, 0, 8)
+	if nil != err {
+		return 0, fmt.Errorf("bad %v %v: %v", name, s, err)
+	}
+	return byte(ret), nil
+}
@@ -2,23 +2,24 @@ package main
 
 import (
 	"fmt"
-	"time"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"./Cache"
-	"./NRFTransciever"
+	"./Mqtt"
+	"./Opcua"
+	"./OutsideInterface"
+	"./RFControl"
 	"./RFModel"
+	"./Radio"
 	"./Redis"
-	"./UartTransciever"
+	"./Rest"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/ini.v1"
 )
 
-func wrapErrPanic(value RFModel.Variant, err error) RFModel.Variant {
-	if nil == err {
-		return value
-	}
-	panic(err)
-}
-
 func main() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -29,32 +30,100 @@ func main() {
 	if nil != err {
 		panic(fmt.Errorf("unable to load settings.ini, %v", err))
 	}
+	if 1 < len(os.Args) && "check-config" == os.Args[1] {
+		if err := checkConfig(settings, os.Args[2:]); nil != err {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 	var model RFModel.RFModel
-	switch settings.Section("").Key("rf model").In("nrf", []string{"nrf", "uart master"}) {
-	case "nrf":
-		var transmitter NRFTransciever.NRFTransmitter
-		NRFTransciever.Init(&transmitter, NRFTransciever.TransmitterSettings{
-			PortName: settings.Section("nrf").Key("port").String(),
-			IrqName:  settings.Section("nrf").Key("irq").String(),
-			CEName:   settings.Section("nrf").Key("ce").String(),
-			Speed:    float32(wrapErrPanic(settings.Section("nrf").Key("speed").Float64()).(float64)),
+	transmitter := Radio.Open(settings, &model)
+	defer model.Close()
+	if 1 < len(os.Args) {
+		// subcommands use the radio alone, the hub should not be running
+		var err error
+		switch os.Args[1] {
+		case "provision":
+			err = provision(settings, &model, transmitter, os.Args[2:])
+		case "scan":
+			err = scan(&model, os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %v", os.Args[1])
+		}
+		if nil != err {
+			model.Close()
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	var output OutsideInterface.Interface
+	switch settings.Section("").Key("output interface").In("redis", []string{"redis", "opcua", "mqtt"}) {
+	case "redis":
+		var redis Redis.Interface
+		db, _ := settings.Section("redis").Key("db").Int()
+		Redis.Init(&redis, Redis.Settings{
+			Server:        settings.Section("redis").Key("server").String(),
+			DB:            db,
+			ValueFormat:   settings.Section("redis").Key("value format").In(Redis.VFPlain, []string{Redis.VFPlain, Redis.VFJson}),
+			HistoryLength: settings.Section("redis").Key("history length").MustInt64(0),
 		})
-		RFModel.Init(&model, &transmitter)
-	case "uart master":
-		var transmitter UartTransciever.UMTransmitter
-		UartTransciever.Init(&transmitter, UartTransciever.TransmitterSettings{
-			PortName: settings.Section("uart master").Key("port").String(),
-			Speed:    wrapErrPanic(settings.Section("uart master").Key("speed").Int()).(int),
+		output = &redis
+	case "opcua":
+		var opcua Opcua.Interface
+		Opcua.Init(&opcua, settings.Section("opcua").Key("host").MustString("0.0.0.0"), settings.Section("opcua").Key("port").MustInt(4840))
+		defer opcua.Close()
+		output = &opcua
+	case "mqtt":
+		var mqtt Mqtt.Interface
+		Mqtt.Init(&mqtt, Mqtt.Settings{
+			Server:          settings.Section("mqtt").Key("server").String(),
+			ClientID:        settings.Section("mqtt").Key("client id").MustString("devhub"),
+			Username:        settings.Section("mqtt").Key("username").String(),
+			Password:        settings.Section("mqtt").Key("password").String(),
+			TopicPrefix:     settings.Section("mqtt").Key("topic prefix").MustString("devhub"),
+			DiscoveryPrefix: settings.Section("mqtt").Key("discovery prefix").String(),
 		})
-		RFModel.Init(&model, &transmitter)
+		output = &mqtt
+	}
+	if listen := settings.Section("metrics").Key("listen").String(); "" != listen {
+		go serveMetrics(listen)
+	}
+	var rest *Rest.Interface
+	if settings.Section("http").Key("enable").MustBool(false) {
+		rest = new(Rest.Interface)
+		Rest.Init(rest, Rest.Settings{Listen: settings.Section("http").Key("listen").MustString(":8080")})
+		defer rest.Close()
+		output = OutsideInterface.Outputs{output, rest}
 	}
-	defer model.Close()
-	var output Redis.Interface
-	db, _ := settings.Section("redis").Key("db").Int()
-	Redis.Init(&output, settings.Section("redis").Key("server").String(), db)
 	var cache Cache.Cache
-	Cache.Init(&cache, &model, &output, settings.Section("").Key("devices").String())
-	for {
-		time.Sleep(time.Second)
+	Cache.Init(&cache, &model, output, settings.Section("").Key("devices").String())
+	if nil != rest {
+		rest.Attach(&cache)
+	}
+	if socket := settings.Section("rfctl").Key("socket").String(); "" != socket {
+		var control RFControl.Server
+		RFControl.Init(&control, &model, socket)
+		defer control.Close()
+	}
+	if err := cache.Watch(); nil != err {
+		fmt.Println(fmt.Errorf("devices.json is reloaded on SIGHUP only: %v", err))
+	}
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := cache.Reload(); nil != err {
+			fmt.Println(fmt.Errorf("devices.json is not reloaded: %v", err))
+		}
+	}
+}
+
+// serveMetrics serves prometheus /metrics of all the packages
+func serveMetrics(listen string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if err := http.ListenAndServe(listen, mux); nil != err {
+		fmt.Println(fmt.Errorf("metrics server on %v: %v", listen, err))
 	}
 }
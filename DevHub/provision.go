@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"./RFModel"
+	"./Radio"
+	"./TranscieverModel"
+	"gopkg.in/ini.v1"
+)
+
+// provision moves a freshly flashed board from the factory address and channel to the given ones,
+// checks it answers there and adds it to devices.json
+func provision(settings *ini.File, model *RFModel.RFModel, transmitter TranscieverModel.Transmitter, args []string) error {
+	section := settings.Section("provision")
+	flags := flag.NewFlagSet("provision", flag.ContinueOnError)
+	from := flags.String("from", section.Key("factory address").MustString("E7:E7:E7:E7:E7"), "factory address of the board")
+	factoryChannel := flags.Int("factory-channel", section.Key("factory channel").MustInt(int(TranscieverModel.DefaultRFChannel)), "factory RF channel of the board")
+	to := flags.String("to", "", "new address of the board, required")
+	channel := flags.Int("channel", settings.Section("").Key("rf channel").MustInt(int(TranscieverModel.DefaultRFChannel)), "RF channel of the house")
+	name := flags.String("name", "", "device name in devices.json, the new address by default")
+	devices := flags.String("devices", settings.Section("").Key("devices").String(), "devices file to add the board to")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of the whole provisioning")
+	if err := flags.Parse(args); nil != err {
+		return err
+	}
+	factoryAddress, err := RFModel.TryParseAddress(*from)
+	if nil != err {
+		return err
+	}
+	if "" == *to {
+		return fmt.Errorf("provision: -to address is required")
+	}
+	newAddress, err := RFModel.TryParseAddress(*to)
+	if nil != err {
+		return err
+	}
+	if 0 > *channel || 128 <= *channel || 0 > *factoryChannel || 128 <= *factoryChannel {
+		return fmt.Errorf("provision: RF channel has to be 0..127")
+	}
+	if "" == *name {
+		*name = RFModel.AddressToString(newAddress)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := Radio.SetChannel(transmitter, byte(*factoryChannel)); nil != err {
+		return err
+	}
+	if _, err := model.UnitCount(ctx, factoryAddress); nil != err {
+		return fmt.Errorf("provision: board does not answer at %v channel %v: %v", *from, *factoryChannel, err)
+	}
+	if err := model.SetDeviceAddress(ctx, factoryAddress, newAddress); nil != err {
+		return fmt.Errorf("provision: set address %v: %v", *to, err)
+	}
+	fmt.Printf("address is set to %v\n", RFModel.AddressToString(newAddress))
+	if *channel != *factoryChannel {
+		if err := model.SetDeviceRFChannel(ctx, newAddress, byte(*channel)); nil != err {
+			return fmt.Errorf("provision: set RF channel %v: %v", *channel, err)
+		}
+		if err := Radio.SetChannel(transmitter, byte(*channel)); nil != err {
+			return err
+		}
+		fmt.Printf("RF channel is set to %v\n", *channel)
+	}
+	device, err := deviceSkeleton(ctx, model, newAddress)
+	if nil != err {
+		return fmt.Errorf("provision: board does not answer at the new address %v channel %v: %v", *to, *channel, err)
+	}
+	if err := appendDevice(*devices, *name, device); nil != err {
+		return fmt.Errorf("provision: %v", err)
+	}
+	fmt.Printf("%v with %v units is added to %v\n", *name, len(device.Units), *devices)
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"./RFModel"
+	"./SimTransciever"
+	"github.com/flynn/json5"
+	"gopkg.in/ini.v1"
+)
+
+const factoryBoard = `{
+	"new board": {
+		"address": "E7:E7:E7:E7:E7",
+		"units": {
+			"unit 1": {
+				"address": 1,
+				"description": "relay",
+				"functions": {
+					"out": {"function": 16, "read": true, "write": true},
+					"in": {"function": 18, "read": true, "write": false, "type": "int32"},
+					"opt": {"function": 20, "read": false, "write": true, "type": "byte"},
+				},
+			},
+		},
+	},
+}`
+
+const existingDevices = `{
+	// the house
+	"lamp": {
+		"address": "AA:AA:AA:AA:01",
+		"units": {},
+	},
+}
+`
+
+func TestProvision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provision")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	simFile := filepath.Join(dir, "sim.json")
+	devicesFile := filepath.Join(dir, "devices.json")
+	_ = ioutil.WriteFile(simFile, []byte(factoryBoard), 0644)
+	_ = ioutil.WriteFile(devicesFile, []byte(existingDevices), 0644)
+	var transmitter SimTransciever.SimTransmitter
+	SimTransciever.Init(&transmitter, SimTransciever.TransmitterSettings{DevicesFile: simFile})
+	var model RFModel.RFModel
+	RFModel.Init(&model, &transmitter)
+	settings := ini.Empty()
+	settings.Section("").Key("devices").SetValue(devicesFile)
+	err = provision(settings, &model, &transmitter, []string{"-to", "AA:AA:AA:AA:05", "-channel", "76", "-name", "relay board"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	text, _ := ioutil.ReadFile(devicesFile)
+	var data map[string]map[string]interface{}
+	if err := json5.Unmarshal(text, &data); nil != err {
+		t.Fatalf("devices.json is broken: %v\n%s", err, text)
+	}
+	if _, ok := data["lamp"]; !ok {
+		t.Errorf("existing device is lost")
+	}
+	units := data["relay board"]["units"].(map[string]interface{})
+	functions := units["relay"].(map[string]interface{})["functions"].(map[string]interface{})
+	out := functions["function 0x10"].(map[string]interface{})
+	in := functions["function 0x12"].(map[string]interface{})
+	opt := functions["function 0x14"].(map[string]interface{})
+	if true != out["write"] || false != in["write"] || "int32" != in["type"] || true != opt["write"] || false != opt["read"] {
+		t.Errorf("unexpected functions %v", functions)
+	}
+	// the board is at the new address and channel only
+	if _, err := model.UnitCount(context.Background(), RFModel.ParseAddress("E7:E7:E7:E7:E7")); nil == err {
+		t.Errorf("board still answers at the factory address")
+	}
+	if nil == provision(settings, &model, &transmitter, []string{"-to", "AA:AA:AA:AA:06"}) {
+		t.Errorf("provisioning without a factory board succeeded")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"./RFModel"
+)
+
+// maxScanAddresses limits the range, a device that does not answer takes the whole timeout
+const maxScanAddresses = 1024
+
+// scan asks every address of the list or the range for its units and functions
+// and writes devices.json5 skeleton of the devices, which answered
+func scan(model *RFModel.RFModel, args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	list := flags.String("addresses", "", "comma separated addresses to scan")
+	from := flags.String("from", "", "first address of the range to scan")
+	to := flags.String("to", "", "last address of the range to scan, the first one by default")
+	output := flags.String("output", "", "devices.json5 file to write, standard output by default")
+	timeout := flags.Duration("timeout", 3*time.Second, "timeout of a single address")
+	if err := flags.Parse(args); nil != err {
+		return err
+	}
+	addresses, err := scanAddresses(*list, *from, *to)
+	if nil != err {
+		return err
+	}
+	if "" != *output {
+		if _, err := os.Stat(*output); nil == err {
+			return fmt.Errorf("scan: %v already exists", *output)
+		}
+	}
+	devices := map[string]skeletonDevice{}
+	for _, address := range addresses {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		device, err := deviceSkeleton(ctx, model, address)
+		cancel()
+		switch {
+		case nil == err:
+			fmt.Fprintf(os.Stderr, "%v: %v units\n", RFModel.AddressToString(address), len(device.Units))
+			devices[RFModel.AddressToString(address)] = device
+		case errors.Is(err, RFModel.ErrDeviceTimeout) || errors.Is(err, context.DeadlineExceeded):
+			fmt.Fprintf(os.Stderr, "%v: no answer\n", RFModel.AddressToString(address))
+		default:
+			fmt.Fprintf(os.Stderr, "%v: %v\n", RFModel.AddressToString(address), err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%v of %v addresses answered\n", len(devices), len(addresses))
+	if 0 == len(devices) {
+		return fmt.Errorf("scan: no devices found")
+	}
+	text := formatDevices(devices)
+	if "" == *output {
+		fmt.Print(text)
+		return nil
+	}
+	return ioutil.WriteFile(*output, []byte(text), 0644)
+}
+
+// scanAddresses is either the list or the range from..to, counting the address as a big endian number
+func scanAddresses(list string, from string, to string) (ret []RFModel.DeviceAddress, err error) {
+	if "" != list {
+		if "" != from || "" != to {
+			return nil, fmt.Errorf("scan: -addresses and -from/-to are exclusive")
+		}
+		for _, s := range strings.Split(list, ",") {
+			address, err := RFModel.TryParseAddress(strings.TrimSpace(s))
+			if nil != err {
+				return nil, err
+			}
+			ret = append(ret, address)
+		}
+		return ret, nil
+	}
+	if "" == from {
+		return nil, fmt.Errorf("scan: -addresses or -from is required")
+	}
+	if "" == to {
+		to = from
+	}
+	first, err := RFModel.TryParseAddress(from)
+	if nil != err {
+		return nil, err
+	}
+	last, err := RFModel.TryParseAddress(to)
+	if nil != err {
+		return nil, err
+	}
+	start, end := addressNumber(first), addressNumber(last)
+	if start > end {
+		return nil, fmt.Errorf("scan: %v is after %v", to, from)
+	}
+	if maxScanAddresses <= end-start {
+		return nil, fmt.Errorf("scan: range is longer than %v addresses", maxScanAddresses)
+	}
+	for n := start; n <= end; n++ {
+		var address RFModel.DeviceAddress
+		for i := len(address) - 1; 0 <= i; i-- {
+			address[i] = byte(n >> (8 * uint(len(address)-1-i)))
+		}
+		ret = append(ret, address)
+	}
+	return ret, nil
+}
+
+func addressNumber(address RFModel.DeviceAddress) (ret uint64) {
+	for _, b := range address {
+		ret = ret<<8 | uint64(b)
+	}
+	return ret
+}
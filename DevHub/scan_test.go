@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"./Cache"
+	"./RFModel"
+	"./SimTransciever"
+)
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	simFile := filepath.Join(dir, "sim.json")
+	output := filepath.Join(dir, "devices.json5")
+	_ = ioutil.WriteFile(simFile, []byte(factoryBoard), 0644)
+	var transmitter SimTransciever.SimTransmitter
+	SimTransciever.Init(&transmitter, SimTransciever.TransmitterSettings{DevicesFile: simFile})
+	var model RFModel.RFModel
+	RFModel.Init(&model, &transmitter)
+	if err := scan(&model, []string{"-from", "E7:E7:E7:E7:E6", "-to", "E7:E7:E7:E7:E8", "-timeout", "1s", "-output", output}); nil != err {
+		t.Fatal(err)
+	}
+	// the skeleton is valid devices.json
+	devices, err := Cache.ReadDevices(output)
+	if nil != err {
+		text, _ := ioutil.ReadFile(output)
+		t.Fatalf("%v\n%s", err, text)
+	}
+	device, ok := devices["E7:E7:E7:E7:E7"]
+	if !ok || 1 != len(devices) {
+		t.Fatalf("devices are %v", devices)
+	}
+	functions := device.Units["relay"].Functions
+	out, in, opt := functions["function 0x10"], functions["function 0x12"], functions["function 0x14"]
+	if nil == out || nil == in || nil == opt {
+		t.Fatalf("functions are %v", functions)
+	}
+	if !out.Write || in.Write || RFModel.EDInt32 != in.Type || !opt.Write || opt.Read || RFModel.EDByte != opt.Type {
+		t.Errorf("unexpected functions %+v %+v %+v", out, in, opt)
+	}
+	if nil == scan(&model, []string{"-from", "E7:E7:E7:E7:E6", "-output", output}) {
+		t.Error("existing output is overwritten")
+	}
+}
+
+func TestScanAddresses(t *testing.T) {
+	addresses, err := scanAddresses("", "AA:AA:AA:AA:FF", "AA:AA:AA:AB:01")
+	if nil != err || 3 != len(addresses) || RFModel.ParseAddress("AA:AA:AA:AB:00") != addresses[1] {
+		t.Errorf("range is %v, error %v", addresses, err)
+	}
+	if _, err := scanAddresses("", "AA:AA:AA:00:00", "AA:AA:AA:FF:FF"); nil == err {
+		t.Error("too long range is accepted")
+	}
+	if addresses, err := scanAddresses("AA:AA:AA:AA:01, AA:AA:AA:AA:03", "", ""); nil != err || 2 != len(addresses) {
+		t.Errorf("list is %v, error %v", addresses, err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"./RFModel"
+	"github.com/flynn/json5"
+)
+
+// devices.json entry, as the device describes itself
+type skeletonFunction struct {
+	Function int    `json:"function"`
+	Read     bool   `json:"read"`
+	Write    bool   `json:"write"`
+	Type     string `json:"type,omitempty"`
+}
+
+type skeletonUnit struct {
+	Address   int                         `json:"address"`
+	Functions map[string]skeletonFunction `json:"functions"`
+}
+
+type skeletonDevice struct {
+	Address string                  `json:"address"`
+	Units   map[string]skeletonUnit `json:"units"`
+}
+
+// deviceSkeleton asks the device for its units and functions
+// unit names are their text descriptions, functions are named after their numbers
+func deviceSkeleton(ctx context.Context, model *RFModel.RFModel, address RFModel.DeviceAddress) (skeletonDevice, error) {
+	ret := skeletonDevice{
+		Address: RFModel.AddressToString(address),
+		Units:   map[string]skeletonUnit{},
+	}
+	unitCount, err := model.UnitCount(ctx, address)
+	if nil != err {
+		return ret, err
+	}
+	for i := 1; i <= unitCount; i++ {
+		uid := RFModel.UID{Address: address, Unit: byte(i)}
+		functions, err := model.ListFunctions(ctx, uid)
+		if nil != err {
+			return ret, err
+		}
+		name, err := model.UnitDescription(ctx, uid)
+		if nil != err || "" == name {
+			name = fmt.Sprintf("unit %v", i)
+		}
+		if _, ok := ret.Units[name]; ok {
+			name = fmt.Sprintf("%v (unit %v)", name, i)
+		}
+		ret.Units[name] = skeletonUnit{
+			Address:   i,
+			Functions: unitSkeleton(functions),
+		}
+	}
+	return ret, nil
+}
+
+// unitSkeleton pairs functions the way devices.json does: write function number is read one + 1
+func unitSkeleton(functions []RFModel.FunctionInfo) map[string]skeletonFunction {
+	byNumber := map[RFModel.FuncNo]RFModel.FunctionInfo{}
+	var numbers []int
+	for _, f := range functions {
+		// standard functions of every unit
+		if RFModel.FSetTextDescription >= f.FNo {
+			continue
+		}
+		byNumber[f.FNo] = f
+		numbers = append(numbers, int(f.FNo))
+	}
+	sort.Ints(numbers)
+	ret := map[string]skeletonFunction{}
+	paired := map[RFModel.FuncNo]bool{}
+	for _, n := range numbers {
+		f := byNumber[RFModel.FuncNo(n)]
+		if paired[f.FNo] {
+			continue
+		}
+		var entry skeletonFunction
+		if RFModel.EDNone != f.Read {
+			entry = skeletonFunction{Function: n, Read: true, Type: f.Read.String()}
+			if next, ok := byNumber[f.FNo+1]; ok && RFModel.EDNone == next.Read && RFModel.EDNone != next.Write {
+				entry.Write = true
+				paired[next.FNo] = true
+			}
+		} else if RFModel.EDNone != f.Write {
+			entry = skeletonFunction{Function: n - 1, Write: true, Type: f.Write.String()}
+		} else {
+			continue
+		}
+		ret[fmt.Sprintf("function 0x%X", entry.Function)] = entry
+	}
+	return ret
+}
+
+// appendDevice adds the device to the end of devices.json, keeping the rest of the file as it is
+func appendDevice(fileName string, name string, device skeletonDevice) error {
+	text, err := ioutil.ReadFile(fileName)
+	if nil != err {
+		return err
+	}
+	var data map[string]interface{}
+	if err := json5.Unmarshal(text, &data); nil != err {
+		return fmt.Errorf("%v: %v", fileName, err)
+	}
+	if _, ok := data[name]; ok {
+		return fmt.Errorf("%v: device %v already exists", fileName, name)
+	}
+	for existingName, existing := range data {
+		if address, ok := existing.(map[string]interface{})["address"]; ok && strings.EqualFold(device.Address, fmt.Sprint(address)) {
+			return fmt.Errorf("%v: address %v already belongs to %v", fileName, device.Address, existingName)
+		}
+	}
+	entry := formatDeviceEntries(map[string]skeletonDevice{name: device})
+	body := strings.TrimRight(string(text), " \t\r\n")
+	if !strings.HasSuffix(body, "}") {
+		return fmt.Errorf("%v: file does not end with }", fileName)
+	}
+	body = strings.TrimRight(body[:len(body)-1], " \t\r\n")
+	if !strings.HasSuffix(body, "{") && !strings.HasSuffix(body, ",") {
+		body += ","
+	}
+	return ioutil.WriteFile(fileName, []byte(body+"\n"+entry+"}\n"), 0644)
+}
+
+// formatDevices makes devices.json5 text of the devices: hex function numbers, trailing commas
+// and the write function number as a comment, the way devices.json is written by hand
+func formatDevices(devices map[string]skeletonDevice) string {
+	return "{\n" + formatDeviceEntries(devices) + "}\n"
+}
+
+// formatDeviceEntries is devices.json5 text of the devices without the braces around, sorted by address
+func formatDeviceEntries(devices map[string]skeletonDevice) string {
+	var b strings.Builder
+	line := func(level int, format string, args ...interface{}) {
+		b.WriteString(strings.Repeat("\t", level) + fmt.Sprintf(format, args...) + "\n")
+	}
+	quote := func(s string) string {
+		ret, _ := json.Marshal(s)
+		return string(ret)
+	}
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return devices[names[i]].Address < devices[names[j]].Address })
+	for _, name := range names {
+		device := devices[name]
+		line(1, "%v: {", quote(name))
+		line(2, "\"address\": %v,", quote(device.Address))
+		line(2, "\"units\": {")
+		unitNames := make([]string, 0, len(device.Units))
+		for unitName := range device.Units {
+			unitNames = append(unitNames, unitName)
+		}
+		sort.Slice(unitNames, func(i, j int) bool { return device.Units[unitNames[i]].Address < device.Units[unitNames[j]].Address })
+		for _, unitName := range unitNames {
+			unit := device.Units[unitName]
+			line(3, "%v: {", quote(unitName))
+			line(4, "\"address\": %v,", unit.Address)
+			line(4, "\"functions\": {")
+			functionNames := make([]string, 0, len(unit.Functions))
+			for functionName := range unit.Functions {
+				functionNames = append(functionNames, functionName)
+			}
+			sort.Slice(functionNames, func(i, j int) bool {
+				return unit.Functions[functionNames[i]].Function < unit.Functions[functionNames[j]].Function
+			})
+			for _, functionName := range functionNames {
+				f := unit.Functions[functionName]
+				line(5, "%v: {", quote(functionName))
+				if f.Write {
+					line(6, "// write function is 0x%X", f.Function+1)
+				}
+				line(6, "\"function\": 0x%X,", f.Function)
+				line(6, "\"read\": %v,", f.Read)
+				line(6, "\"write\": %v,", f.Write)
+				if "" != f.Type {
+					line(6, "\"type\": %v,", quote(f.Type))
+				}
+				line(5, "},")
+			}
+			line(4, "},")
+			line(3, "},")
+		}
+		line(2, "},")
+		line(1, "},")
+	}
+	return b.String()
+}